@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"yambol/config"
 	"yambol/pkg/queue"
 	"yambol/pkg/telemetry"
 	"yambol/pkg/util/log"
@@ -45,7 +46,11 @@ func consumeLoop(q *queue.Queue, stop *bool) (total, successful int) {
 func test(val string, logger *log.Logger) {
 	size := len(val)
 
-	q := queue.New(minMaxLen, minMaxLen, maxSize, 0, &telemetry.QueueStats{})
+	q := queue.New(config.QueueConfig{
+		MaxSizeBytes: maxSize,
+		MinLength:    minMaxLen,
+		MaxLength:    minMaxLen,
+	}, &telemetry.QueueStats{})
 
 	stop := false
 	prodTotal := 0
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"yambol/config"
+
+	"yambol/pkg/queue"
+	"yambol/pkg/telemetry"
+	"yambol/pkg/util/log"
+)
+
+const (
+	minMaxLen = 1024 * 1024
+	maxSize   = 1024 * 1024 * 32
+	oneByte   = "a"
+)
+
+var (
+	seconds   = flag.Int("seconds", 10, "how long to run each queue type for")
+	producers = flag.Int("producers", 8, "how many goroutines publish")
+	consumers = flag.Int("consumers", 8, "how many goroutines consume")
+	shards    = flag.Int64("shards", 0, "how many shards the sharded queue uses, zero for one per CPU")
+	size      = flag.Int("size", 64, "payload size in bytes")
+)
+
+type counters struct {
+	produced, rejected, consumed, empty atomic.Int64
+}
+
+func run(queueType string, val string, logger *log.Logger) {
+	cfg := config.QueueConfig{
+		Type:         queueType,
+		MaxSizeBytes: maxSize,
+		MinLength:    minMaxLen,
+		MaxLength:    minMaxLen,
+	}
+	if queueType == config.QueueTypeSharded {
+		cfg.Shards = *shards
+	}
+	q := queue.New(cfg, &telemetry.QueueStats{})
+
+	var (
+		c    counters
+		stop atomic.Bool
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < *producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if _, err := q.Push(val); err == nil {
+					c.produced.Add(1)
+				} else {
+					c.rejected.Add(1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < *consumers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if _, err := q.Pop(); err == nil {
+					c.consumed.Add(1)
+				} else {
+					c.empty.Add(1)
+				}
+			}
+		}()
+	}
+
+	time.Sleep(time.Second * time.Duration(*seconds))
+	stop.Store(true)
+	wg.Wait()
+
+	logger.Info("[%s] %d producers, %d consumers, %dB payloads", queueType, *producers, *consumers, len(val))
+	logger.Info("[%s] Produced: %d (%d/s), rejected as full: %d", queueType, c.produced.Load(), c.produced.Load()/int64(*seconds), c.rejected.Load())
+	logger.Info("[%s] Consumed: %d (%d/s), found empty: %d", queueType, c.consumed.Load(), c.consumed.Load()/int64(*seconds), c.empty.Load())
+}
+
+// Compares the throughput of the regular FIFO queue with the sharded one under many producers and consumers
+func main() {
+	flag.Parse()
+
+	fh, err := log.NewDefaultFileHandler("./.logs/throughput_mpmc.log")
+	logger := log.New("throughput_mpmc", log.LevelDebug, fh, log.NewDefaultStdioHandler())
+	if err != nil {
+		panic(err)
+	}
+	val := strings.Repeat(oneByte, *size)
+	for _, queueType := range []string{config.QueueTypeFIFO, config.QueueTypeSharded} {
+		run(queueType, val, logger)
+	}
+}
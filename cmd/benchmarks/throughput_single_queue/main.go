@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math"
 	"os"
@@ -17,10 +18,11 @@ import (
 const (
 	minMaxLen = 1024 * 1024
 	maxSize   = 1024 * 1024 * 32
-	seconds   = 30
 	oneByte   = "a"
 )
 
+var seconds = flag.Int("seconds", 30, "how long to run each payload size for")
+
 func calculateVolume(n, size int) string {
 	totalSize := float64(n * size)
 	unit := "Bytes"
@@ -64,7 +66,7 @@ func test(val string, logger *log.Logger) {
 		consTotal, consSuccessful = consumeLoop(q, &stop)
 	}()
 
-	time.Sleep(time.Second * seconds)
+	time.Sleep(time.Second * time.Duration(*seconds))
 	stop = true
 	time.Sleep(time.Second * 1)
 
@@ -72,11 +74,12 @@ func test(val string, logger *log.Logger) {
 	logger.Info("[%dB] Total Produce: %d (%s)", size, consTotal, calculateVolume(consTotal, size))
 	logger.Info("[%dB] Successful Consume: %d (%s)", size, prodSuccessful, calculateVolume(prodSuccessful, size))
 	logger.Info("[%dB] Successful Produce: %d (%s)", size, consSuccessful, calculateVolume(consSuccessful, size))
-	logger.Info("[%dB] Total Throughput: %d (%s/s)", size, consTotal/seconds, calculateVolume(consTotal/seconds, size))
-	logger.Info("[%dB] Successful Throughput: %d (%s/s)", size, consSuccessful/seconds, calculateVolume(consSuccessful/seconds, size))
+	logger.Info("[%dB] Total Throughput: %d (%s/s)", size, consTotal / *seconds, calculateVolume(consTotal / *seconds, size))
+	logger.Info("[%dB] Successful Throughput: %d (%s/s)", size, consSuccessful / *seconds, calculateVolume(consSuccessful / *seconds, size))
 }
 
 func main() {
+	flag.Parse()
 
 	fh, err := log.NewDefaultFileHandler("./.logs/throughput.log")
 	logger := log.New("throughput", log.LevelDebug, fh, log.NewDefaultStdioHandler())
@@ -44,13 +44,18 @@ func main() {
 	broker.SetDefaultMaxLen(cfg.Broker.DefaultMaxLength)
 	broker.SetDefaultMaxSizeBytes(cfg.Broker.DefaultMaxSizeBytes)
 	broker.SetDefaultTTL(cfg.Broker.DefaultTTLSeconds)
+	broker.SetReapInterval(cfg.Broker.ReapIntervalSeconds)
+	broker.SetMaxUnsent(cfg.Broker.MaxUnsent)
 
 	b := broker.New(logger)
-	for qName, qCfg := range cfg.Broker.Queues {
-		if err = b.AddQueue(qName, qCfg); err != nil {
-			logger.Error("failed to add queue: %v", err)
-		}
+	if err = b.AddQueues(cfg.Broker.Queues); err != nil {
+		logger.Error("failed to add queues: %v", err)
+	}
+	if err = b.AddExchanges(cfg.Broker.Exchanges); err != nil {
+		logger.Error("failed to add exchanges: %v", err)
 	}
+	b.StartReaper(0)
+	defer b.Close()
 
 	certPath, err := filepath.Abs(cfg.API.Certificate)
 	if err != nil {
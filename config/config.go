@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 	"yambol/pkg/util"
@@ -14,6 +15,28 @@ var (
 	configFilePath, _ = filepath.Abs("config.json")
 )
 
+const (
+	QueueTypeFIFO     = "fifo"
+	QueueTypePriority = "priority"
+	// QueueTypeSharded is a FIFO queue whose producers push through shards, so they do not contend for one lock
+	QueueTypeSharded = "sharded"
+)
+
+// Overflow policies decide what happens to a publish when its queue is full
+const (
+	OverflowReject     = "reject"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+	OverflowBlock      = "block"
+)
+
+// Pause directions, which say whether consuming from a queue, publishing into it, or both are paused
+const (
+	PauseConsume = "consume"
+	PausePublish = "publish"
+	PauseBoth    = "both"
+)
+
 type QueueMap map[string]QueueConfig
 
 func (qm QueueMap) toQueueState() queueStateMap {
@@ -24,6 +47,15 @@ func (qm QueueMap) toQueueState() queueStateMap {
 			maxLength:    v.MaxLength,
 			maxSizeBytes: v.MaxSizeBytes,
 			ttl:          v.TTLDuration(),
+			visibility:   v.VisibilityTimeoutDuration(),
+			deadLetter:   v.DeadLetterQueue,
+			maxDelivery:  v.MaxDeliveries,
+			queueType:    v.Type,
+			dedupWindow:  v.DedupWindowDuration(),
+			overflow:     v.Overflow,
+			publishWait:  v.PublishTimeoutDuration(),
+			paused:       v.PausedDirection(),
+			shards:       v.Shards,
 		}
 	}
 	return rv
@@ -37,6 +69,24 @@ func (qm QueueMap) Copy() QueueMap {
 	return rv
 }
 
+type ExchangeMap map[string]ExchangeConfig
+
+func (em ExchangeMap) toExchangeState() exchangeStateMap {
+	rv := make(exchangeStateMap)
+	for k, v := range em {
+		rv[k] = v.state()
+	}
+	return rv
+}
+
+func (em ExchangeMap) Copy() ExchangeMap {
+	rv := make(ExchangeMap)
+	for k, v := range em {
+		rv[k] = v.Copy()
+	}
+	return rv
+}
+
 func init() {
 	val, ok := syscall.Getenv("YAMBOL_CONFIG")
 	if ok {
@@ -44,15 +94,101 @@ func init() {
 	}
 }
 
+// QueueConfig describes a queue. Its TTL is read as seconds or as a duration string such as "250ms",
+// and may also be given in milliseconds as ttl_ms. Shards is how many shards a sharded queue spreads its
+// producers over, where zero means one per CPU. Paused is which directions of the queue are paused: when
+// it is not set, a new queue starts unpaused and an updated queue keeps its pause as it is.
 type QueueConfig struct {
-	MinLength    int64 `json:"min_length"`
-	MaxLength    int64 `json:"max_length"`
-	MaxSizeBytes int64 `json:"max_size_bytes"`
-	TTL          int64 `json:"ttl"`
+	MinLength         int64         `json:"min_length"`
+	MaxLength         int64         `json:"max_length"`
+	MaxSizeBytes      int64         `json:"max_size_bytes"`
+	TTL               util.Duration `json:"ttl"`
+	VisibilityTimeout int64         `json:"visibility_timeout,omitempty"`
+	DeadLetterQueue   string        `json:"dead_letter_queue,omitempty"`
+	MaxDeliveries     int64         `json:"max_deliveries,omitempty"`
+	Type              string        `json:"type,omitempty"`
+	DedupWindow       int64         `json:"dedup_window,omitempty"`
+	Overflow          string        `json:"overflow,omitempty"`
+	PublishTimeout    int64         `json:"publish_timeout,omitempty"`
+	Paused            *string       `json:"paused,omitempty"`
+	Shards            int64         `json:"shards,omitempty"`
+}
+
+// UnmarshalJSON reads the config as usual, and also accepts the TTL in milliseconds as ttl_ms, which wins over ttl
+func (qc *QueueConfig) UnmarshalJSON(b []byte) error {
+	type plain QueueConfig
+	aux := struct {
+		*plain
+		TTLMilliseconds *int64 `json:"ttl_ms"`
+	}{plain: (*plain)(qc)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.TTLMilliseconds != nil {
+		qc.TTL = util.Duration(util.Milliseconds(*aux.TTLMilliseconds))
+	}
+	return nil
 }
 
 func (qc QueueConfig) TTLDuration() time.Duration {
-	return time.Duration(qc.TTL) * time.Second
+	return time.Duration(qc.TTL)
+}
+
+func (qc QueueConfig) VisibilityTimeoutDuration() time.Duration {
+	return time.Duration(qc.VisibilityTimeout) * time.Second
+}
+
+// DedupWindowDuration is how long the queue remembers deduplication keys. Zero means the queue's default.
+func (qc QueueConfig) DedupWindowDuration() time.Duration {
+	return time.Duration(qc.DedupWindow) * time.Second
+}
+
+// PublishTimeoutDuration is how long a publish may wait for room under the block overflow policy.
+// Zero means the queue's default.
+func (qc QueueConfig) PublishTimeoutDuration() time.Duration {
+	return time.Duration(qc.PublishTimeout) * time.Second
+}
+
+// PausedDirection is which directions of the queue are paused, or an empty string if neither is or Paused is not set
+func (qc QueueConfig) PausedDirection() string {
+	if qc.Paused == nil {
+		return ""
+	}
+	return *qc.Paused
+}
+
+func (qc QueueConfig) Validate() error {
+	switch qc.Type {
+	case "", QueueTypeFIFO, QueueTypePriority, QueueTypeSharded:
+	default:
+		return fmt.Errorf("unknown queue type `%s`", qc.Type)
+	}
+	if qc.Shards < 0 {
+		return fmt.Errorf("shards may not be negative")
+	}
+	if qc.Shards != 0 && qc.Type != QueueTypeSharded {
+		return fmt.Errorf("shards only apply to %s queues", QueueTypeSharded)
+	}
+	if qc.TTL < 0 {
+		return fmt.Errorf("ttl may not be negative")
+	}
+	if qc.DedupWindow < 0 {
+		return fmt.Errorf("dedup window may not be negative")
+	}
+	switch qc.Overflow {
+	case "", OverflowReject, OverflowDropOldest, OverflowDropNewest, OverflowBlock:
+	default:
+		return fmt.Errorf("unknown overflow policy `%s`", qc.Overflow)
+	}
+	if qc.PublishTimeout < 0 {
+		return fmt.Errorf("publish timeout may not be negative")
+	}
+	switch qc.PausedDirection() {
+	case "", PauseConsume, PausePublish, PauseBoth:
+	default:
+		return fmt.Errorf("unknown pause direction `%s`", qc.PausedDirection())
+	}
+	return nil
 }
 
 func (qc QueueConfig) String() string {
@@ -66,15 +202,98 @@ func (qc QueueConfig) state() queueState {
 		maxLength:    qc.MaxLength,
 		maxSizeBytes: qc.MaxSizeBytes,
 		ttl:          qc.TTLDuration(),
+		visibility:   qc.VisibilityTimeoutDuration(),
+		deadLetter:   qc.DeadLetterQueue,
+		maxDelivery:  qc.MaxDeliveries,
+		queueType:    qc.Type,
+		dedupWindow:  qc.DedupWindowDuration(),
+		overflow:     qc.Overflow,
+		publishWait:  qc.PublishTimeoutDuration(),
+		paused:       qc.PausedDirection(),
+		shards:       qc.Shards,
+	}
+}
+
+// ExchangeConfig describes an exchange, which routes every message published to it with a routing key
+// into each queue bound to it with a pattern that matches the key
+type ExchangeConfig struct {
+	Bindings []Binding `json:"bindings"`
+}
+
+// Binding routes the messages of an exchange whose routing key matches Pattern into Queue.
+// Routing keys and patterns are words separated by dots, and in a pattern `*` stands for exactly one word
+// while `#` stands for zero or more words, so `orders.*.created` matches `orders.eu.created`,
+// and `logs.#` matches `logs`, `logs.app` and `logs.app.error`.
+type Binding struct {
+	Queue   string `json:"queue"`
+	Pattern string `json:"pattern"`
+}
+
+func (ec ExchangeConfig) Copy() ExchangeConfig {
+	bindings := make([]Binding, len(ec.Bindings))
+	copy(bindings, ec.Bindings)
+	return ExchangeConfig{Bindings: bindings}
+}
+
+func (ec ExchangeConfig) Validate() error {
+	seen := make(map[Binding]bool, len(ec.Bindings))
+	for _, b := range ec.Bindings {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+		if seen[b] {
+			return fmt.Errorf("queue `%s` is bound with pattern `%s` more than once", b.Queue, b.Pattern)
+		}
+		seen[b] = true
+	}
+	return nil
+}
+
+func (ec ExchangeConfig) String() string {
+	b, _ := json.MarshalIndent(ec, "", "    ")
+	return string(b)
+}
+
+func (ec ExchangeConfig) state() exchangeState {
+	bindings := make([]bindingState, len(ec.Bindings))
+	for i, b := range ec.Bindings {
+		bindings[i] = bindingState{queue: b.Queue, pattern: b.Pattern}
+	}
+	return exchangeState{bindings: bindings}
+}
+
+func (b Binding) Validate() error {
+	if b.Queue == "" {
+		return fmt.Errorf("a binding needs a queue")
+	}
+	return ValidatePattern(b.Pattern)
+}
+
+// ValidatePattern checks that a binding pattern is made of non-empty words, where `*` and `#` are whole words
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("a binding needs a pattern")
+	}
+	for _, word := range strings.Split(pattern, ".") {
+		if word == "" {
+			return fmt.Errorf("pattern `%s` has an empty word", pattern)
+		}
+		if word != "*" && word != "#" && strings.ContainsAny(word, "*#") {
+			return fmt.Errorf("pattern `%s`: wildcards must be whole words", pattern)
+		}
 	}
+	return nil
 }
 
 type BrokerConfig struct {
-	DefaultMinLength    int64    `json:"default_min_length"`
-	DefaultMaxLength    int64    `json:"default_max_length"`
-	DefaultMaxSizeBytes int64    `json:"default_max_size_bytes"`
-	DefaultTTLSeconds   int64    `json:"default_ttl"`
-	Queues              QueueMap `json:"queues"`
+	DefaultMinLength    int64       `json:"default_min_length"`
+	DefaultMaxLength    int64       `json:"default_max_length"`
+	DefaultMaxSizeBytes int64       `json:"default_max_size_bytes"`
+	DefaultTTLSeconds   int64       `json:"default_ttl"`
+	ReapIntervalSeconds int64       `json:"reap_interval,omitempty"`
+	MaxUnsent           int64       `json:"max_unsent,omitempty"`
+	Queues              QueueMap    `json:"queues"`
+	Exchanges           ExchangeMap `json:"exchanges,omitempty"`
 }
 
 func (s BrokerConfig) Copy() (rv BrokerConfig) {
@@ -87,7 +306,10 @@ func (s BrokerConfig) Copy() (rv BrokerConfig) {
 		DefaultMaxLength:    s.DefaultMaxLength,
 		DefaultMaxSizeBytes: s.DefaultMaxSizeBytes,
 		DefaultTTLSeconds:   s.DefaultTTLSeconds,
+		ReapIntervalSeconds: s.ReapIntervalSeconds,
+		MaxUnsent:           s.MaxUnsent,
 		Queues:              q.Copy(),
+		Exchanges:           s.Exchanges.Copy(),
 	}
 }
 
@@ -120,7 +342,8 @@ type Configuration struct {
 func Empty() Configuration {
 	return Configuration{
 		Broker: BrokerConfig{
-			Queues: make(QueueMap),
+			Queues:    make(QueueMap),
+			Exchanges: make(ExchangeMap),
 		},
 	}
 }
@@ -152,7 +375,10 @@ func (c Configuration) state() state {
 			DefaultMaxLength:    c.Broker.DefaultMaxLength,
 			DefaultMaxSizeBytes: c.Broker.DefaultMaxSizeBytes,
 			DefaultTTL:          util.Seconds(c.Broker.DefaultTTLSeconds),
+			ReapInterval:        util.Seconds(c.Broker.ReapIntervalSeconds),
+			MaxUnsent:           c.Broker.MaxUnsent,
 			Queues:              c.Broker.Queues.toQueueState(),
+			Exchanges:           c.Broker.Exchanges.toExchangeState(),
 		},
 		Log: logState{
 			Level: c.Log.Level,
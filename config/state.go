@@ -28,14 +28,31 @@ func (qm queueStateMap) Copy() queueStateMap {
 	return rv
 }
 
+// pausedOrNil leaves the pause of a queue which is not paused out of its config
+func pausedOrNil(paused string) *string {
+	if paused == "" {
+		return nil
+	}
+	return &paused
+}
+
 func (qm queueStateMap) toQueueConfig() QueueMap {
 	rv := make(QueueMap)
 	for k, v := range qm {
 		rv[k] = QueueConfig{
-			MinLength:    v.minLength,
-			MaxLength:    v.maxLength,
-			MaxSizeBytes: v.maxSizeBytes,
-			TTL:          int64(v.ttl.Seconds()),
+			MinLength:         v.minLength,
+			MaxLength:         v.maxLength,
+			MaxSizeBytes:      v.maxSizeBytes,
+			TTL:               util.Duration(v.ttl),
+			VisibilityTimeout: int64(v.visibility.Seconds()),
+			DeadLetterQueue:   v.deadLetter,
+			MaxDeliveries:     v.maxDelivery,
+			Type:              v.queueType,
+			DedupWindow:       int64(v.dedupWindow.Seconds()),
+			Overflow:          v.overflow,
+			PublishTimeout:    int64(v.publishWait.Seconds()),
+			Paused:            pausedOrNil(v.paused),
+			Shards:            v.shards,
 		}
 	}
 	return rv
@@ -46,6 +63,48 @@ type queueState struct {
 	maxLength    int64
 	maxSizeBytes int64
 	ttl          time.Duration
+	visibility   time.Duration
+	deadLetter   string
+	maxDelivery  int64
+	queueType    string
+	dedupWindow  time.Duration
+	overflow     string
+	publishWait  time.Duration
+	paused       string
+	shards       int64
+}
+
+type exchangeStateMap map[string]exchangeState
+
+func (em exchangeStateMap) Copy() exchangeStateMap {
+	rv := make(exchangeStateMap)
+	for k, v := range em {
+		bindings := make([]bindingState, len(v.bindings))
+		copy(bindings, v.bindings)
+		rv[k] = exchangeState{bindings: bindings}
+	}
+	return rv
+}
+
+func (em exchangeStateMap) toExchangeConfig() ExchangeMap {
+	rv := make(ExchangeMap)
+	for k, v := range em {
+		bindings := make([]Binding, len(v.bindings))
+		for i, b := range v.bindings {
+			bindings[i] = Binding{Queue: b.queue, Pattern: b.pattern}
+		}
+		rv[k] = ExchangeConfig{Bindings: bindings}
+	}
+	return rv
+}
+
+type exchangeState struct {
+	bindings []bindingState
+}
+
+type bindingState struct {
+	queue   string
+	pattern string
 }
 
 type brokerState struct {
@@ -53,7 +112,10 @@ type brokerState struct {
 	DefaultMaxLength    int64
 	DefaultMaxSizeBytes int64
 	DefaultTTL          time.Duration
+	ReapInterval        time.Duration
+	MaxUnsent           int64
 	Queues              queueStateMap
+	Exchanges           exchangeStateMap
 }
 
 func (s brokerState) Copy() (rv brokerState) {
@@ -66,7 +128,10 @@ func (s brokerState) Copy() (rv brokerState) {
 		DefaultMaxLength:    s.DefaultMaxLength,
 		DefaultMaxSizeBytes: s.DefaultMaxSizeBytes,
 		DefaultTTL:          s.DefaultTTL,
+		ReapInterval:        s.ReapInterval,
+		MaxUnsent:           s.MaxUnsent,
 		Queues:              q.Copy(),
+		Exchanges:           s.Exchanges.Copy(),
 	}
 }
 
@@ -123,7 +188,10 @@ func (s state) asConfig() (rv Configuration) {
 			DefaultMaxLength:    s.Broker.DefaultMaxLength,
 			DefaultMaxSizeBytes: s.Broker.DefaultMaxSizeBytes,
 			DefaultTTLSeconds:   int64(s.Broker.DefaultTTL.Seconds()),
+			ReapIntervalSeconds: int64(s.Broker.ReapInterval.Seconds()),
+			MaxUnsent:           s.Broker.MaxUnsent,
 			Queues:              s.Broker.Queues.toQueueConfig(),
+			Exchanges:           s.Broker.Exchanges.toExchangeConfig(),
 		},
 		Log: LogConfig{
 			Level: s.Log.Level,
@@ -135,7 +203,8 @@ func (s state) asConfig() (rv Configuration) {
 func defaultState() state {
 	return state{
 		Broker: brokerState{
-			Queues: make(queueStateMap),
+			Queues:    make(queueStateMap),
+			Exchanges: make(exchangeStateMap),
 		},
 	}
 }
@@ -154,12 +223,18 @@ func SetLogger(l *log.Logger) {
 }
 
 func SetRunningConfig(config Configuration) {
+	mx.Lock()
+	defer mx.Unlock()
+
 	logger.Debug("Set running config to:\n%s", config.String())
 	activeState = config.state()
 	autoSave()
 }
 
 func DisableAutoSave(disable bool) {
+	mx.Lock()
+	defer mx.Unlock()
+
 	logger.Debug("Auto save: %s", util.BoolLabels(!disable, "enabled", "disabled"))
 	activeState.DisableAutoSave = disable
 	autoSave()
@@ -174,52 +249,152 @@ func CreateQueue(queueName string, cfg QueueConfig) {
 	autoSave()
 }
 
+// UpdateQueue replaces the config of an existing queue
+func UpdateQueue(queueName string, cfg QueueConfig) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if _, ok := activeState.Broker.Queues[queueName]; !ok {
+		return
+	}
+	activeState.Broker.Queues[queueName] = cfg.state()
+	logger.Debug("Queue `%s` updated", queueName)
+	autoSave()
+}
+
+// SetQueuePaused records which directions of a queue are paused. An empty direction means none are.
+func SetQueuePaused(queueName string, paused string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	qs, ok := activeState.Broker.Queues[queueName]
+	if !ok {
+		return
+	}
+	qs.paused = paused
+	activeState.Broker.Queues[queueName] = qs
+	logger.Debug("Queue `%s` paused: %s", queueName, util.BoolLabels(paused != "", paused, "none"))
+	autoSave()
+}
+
 func DeleteQueue(queueName string) {
 	mx.Lock()
-	mx.Unlock()
+	defer mx.Unlock()
+
 	logger.Debug("Queue `%s` deleted", queueName)
 	delete(activeState.Broker.Queues, queueName)
 	autoSave()
 }
 
+func CreateExchange(exchangeName string, cfg ExchangeConfig) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	activeState.Broker.Exchanges[exchangeName] = cfg.state()
+	logger.Debug("Exchange `%s` created", exchangeName)
+	autoSave()
+}
+
+// UpdateExchange replaces the bindings of an existing exchange
+func UpdateExchange(exchangeName string, cfg ExchangeConfig) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if _, ok := activeState.Broker.Exchanges[exchangeName]; !ok {
+		return
+	}
+	activeState.Broker.Exchanges[exchangeName] = cfg.state()
+	logger.Debug("Exchange `%s` updated", exchangeName)
+	autoSave()
+}
+
+func DeleteExchange(exchangeName string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	delete(activeState.Broker.Exchanges, exchangeName)
+	logger.Debug("Exchange `%s` deleted", exchangeName)
+	autoSave()
+}
+
 func SetDefaultMinLen(value int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
 	atomic.StoreInt64(&activeState.Broker.DefaultMinLength, value)
 	logger.Debug("default min len set to %d", value)
 	autoSave()
 }
 
 func SetDefaultMaxLen(value int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
 	atomic.StoreInt64(&activeState.Broker.DefaultMaxLength, value)
 	logger.Debug("default max len set to %d", value)
 	autoSave()
 }
 
 func SetDefaultMaxSizeBytes(value int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
 	atomic.StoreInt64(&activeState.Broker.DefaultMaxSizeBytes, value)
 	logger.Debug("default max size bytes set to %d", value)
 	autoSave()
 }
 
 func SetDefaultTTL(value int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
 	activeState.Broker.DefaultTTL = util.Seconds(atomic.LoadInt64(&value))
 	logger.Debug("default ttl set to %ds", value)
 	autoSave()
 }
 
+func SetReapInterval(value int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	activeState.Broker.ReapInterval = util.Seconds(atomic.LoadInt64(&value))
+	logger.Debug("reap interval set to %ds", value)
+	autoSave()
+}
+
+func SetMaxUnsent(value int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	activeState.Broker.MaxUnsent = value
+	logger.Debug("max unsent messages per queue set to %d", value)
+	autoSave()
+}
+
+// autoSave needs the lock
 func autoSave() {
 	if autoSaveDisabled() {
 		return
 	}
-	if err := CopyRunningConfigToStartupConfig(); err != nil {
+	if err := saveRunningConfig(); err != nil {
 		logger.Error("failed to auto save config:", err)
 	}
 }
 
 func GetRunningConfig() Configuration {
+	mx.RLock()
+	defer mx.RUnlock()
 	return activeState.asConfig()
 }
 
 func CopyRunningConfigToStartupConfig() error {
+	mx.RLock()
+	defer mx.RUnlock()
+	return saveRunningConfig()
+}
+
+// saveRunningConfig needs the lock
+func saveRunningConfig() error {
 	f, err := os.OpenFile(configFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return fmt.Errorf("failed to open state log file %s: %v", configFilePath, err)
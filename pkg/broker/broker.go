@@ -1,52 +1,93 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"yambol/config"
 	"yambol/pkg/queue"
 	"yambol/pkg/telemetry"
+	"yambol/pkg/util"
 	"yambol/pkg/util/log"
 )
 
 type MessageBroker struct {
-	queues    map[string]*queue.Queue
-	unsent    map[string][]string
-	stats     *telemetry.Collector
-	ephemeral bool
-	logger    *log.Logger
+	mx          *sync.RWMutex // guards queues, their lifecycle states, deadLetters, exchanges and the reaper
+	mgmt        *sync.Mutex   // serializes adding, updating and removing queues and exchanges
+	unsentMx    *sync.Mutex   // guards unsent
+	queues      map[string]*managedQueue
+	deadLetters map[string]string
+	exchanges   map[string]*exchange
+	unsent      map[string]*spool
+	unsentLen   atomic.Int64 // counts the messages of every spool, so retries can be skipped when there are none
+	stats       *telemetry.Collector
+	ephemeral   bool
+	reaper      *reaper
+	logger      *log.Logger
 }
 
 func New(logger *log.Logger) *MessageBroker {
 	return &MessageBroker{
-		queues: make(map[string]*queue.Queue),
-		unsent: make(map[string][]string),
-		stats:  telemetry.NewCollector(),
-		logger: logger.NewFrom("BROKER"),
+		mx:          &sync.RWMutex{},
+		mgmt:        &sync.Mutex{},
+		unsentMx:    &sync.Mutex{},
+		queues:      make(map[string]*managedQueue),
+		deadLetters: make(map[string]string),
+		exchanges:   make(map[string]*exchange),
+		unsent:      make(map[string]*spool),
+		stats:       telemetry.NewCollector(),
+		logger:      logger.NewFrom("BROKER"),
+	}
+}
+
+func defaultQueueConfig() config.QueueConfig {
+	return config.QueueConfig{
+		MinLength:    GetDefaultMinLen(),
+		MaxLength:    GetDefaultMaxLen(),
+		MaxSizeBytes: GetDefaultMaxSizeBytes(),
+		TTL:          determineTTL(0),
 	}
 }
 
 func (mb *MessageBroker) AddDefaultQueue(queueName string) error {
-	return mb.AddQueue(queueName,
-		config.QueueConfig{
-			MinLength:    GetDefaultMinLen(),
-			MaxLength:    GetDefaultMaxLen(),
-			MaxSizeBytes: GetDefaultMaxSizeBytes(),
-			TTL:          GetDefaultTTL(),
-		},
-	)
+	return mb.AddQueue(queueName, defaultQueueConfig())
 }
 
+// AddQueue creates a queue, which accepts operations once it is active
 func (mb *MessageBroker) AddQueue(queueName string, cfg config.QueueConfig) error {
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+	return mb.addQueue(queueName, cfg)
+}
+
+// addQueue needs the management lock
+func (mb *MessageBroker) addQueue(queueName string, cfg config.QueueConfig) error {
 	mb.logger.Info("Trying to add queue `%s`: %s", queueName, cfg)
 
-	_, exists := mb.queues[queueName]
-	if exists {
+	if mb.QueueExists(queueName) {
 		mb.logger.Error("failed to add queue `%s` as it already exists", queueName)
 		return fmt.Errorf("queue %s already exists", queueName)
 	}
+	if err := cfg.Validate(); err != nil {
+		mb.logger.Error("failed to add queue `%s`: %v", queueName, err)
+		return err
+	}
+	mq := &managedQueue{state: QueueCreating}
+	mb.mx.Lock()
+	mb.queues[queueName] = mq
+	mb.mx.Unlock()
+	if err := mb.ensureDeadLetterQueue(queueName, cfg.DeadLetterQueue); err != nil {
+		mb.logger.Error("failed to add queue `%s`: %v", queueName, err)
+		mb.mx.Lock()
+		delete(mb.queues, queueName)
+		mb.mx.Unlock()
+		return err
+	}
 	queueStats := mb.stats.AddQueue(queueName)
 
 	cfg.MinLength = determineMinLen(cfg.MinLength)
@@ -55,41 +96,266 @@ func (mb *MessageBroker) AddQueue(queueName string, cfg config.QueueConfig) erro
 	cfg.TTL = determineTTL(cfg.TTL)
 
 	mb.logger.Debug("Adding queue `%s` with determined: %s", queueName, cfg)
-	mb.queues[queueName] = queue.New(cfg, queueStats)
-	mb.unsent[queueName] = make([]string, 0)
+	q := queue.New(cfg, queueStats)
+	mb.mx.Lock()
+	if cfg.DeadLetterQueue != "" {
+		q.SetDeadLetterQueue(queueName, mb.queues[cfg.DeadLetterQueue].q)
+		mb.deadLetters[queueName] = cfg.DeadLetterQueue
+	}
+	mq.q = q
+	mq.state = QueueActive
+	mb.mx.Unlock()
+	mb.unsentMx.Lock()
+	mb.unsent[queueName] = &spool{}
+	mb.unsentMx.Unlock()
 	config.CreateQueue(queueName, cfg)
 	mb.logger.Info("Queue `%s` created", queueName)
 	return nil
 }
 
+// AddQueues adds several queues at once, creating dead-letter queues before the queues which use them
+// so that they get their own config instead of the defaults.
+func (mb *MessageBroker) AddQueues(queues config.QueueMap) error {
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+
+	names := make([]string, 0, len(queues))
+	for queueName := range queues {
+		names = append(names, queueName)
+	}
+	sort.Strings(names)
+
+	errors := make(map[string]error)
+	visiting := make(map[string]bool)
+	var add func(queueName string)
+	add = func(queueName string) {
+		cfg, ok := queues[queueName]
+		if !ok || mb.QueueExists(queueName) || errors[queueName] != nil {
+			return
+		}
+		if visiting[queueName] {
+			errors[queueName] = fmt.Errorf("dead-letter queues of `%s` form a cycle", queueName)
+			return
+		}
+		visiting[queueName] = true
+		if cfg.DeadLetterQueue != "" {
+			add(cfg.DeadLetterQueue)
+			if errors[cfg.DeadLetterQueue] != nil && errors[queueName] == nil {
+				errors[queueName] = fmt.Errorf("dead-letter queue `%s` could not be added", cfg.DeadLetterQueue)
+			}
+		}
+		if errors[queueName] == nil {
+			if err := mb.addQueue(queueName, cfg); err != nil {
+				errors[queueName] = err
+			}
+		}
+		visiting[queueName] = false
+	}
+	for _, queueName := range names {
+		add(queueName)
+	}
+	return mb.formatMultipleErrors("one or more queues could not be added:", errors)
+}
+
+// ensureDeadLetterQueue checks that using dlq as the dead-letter queue of queueName would not form a cycle,
+// and creates dlq with the default config if it does not exist yet.
+func (mb *MessageBroker) ensureDeadLetterQueue(queueName, dlq string) error {
+	if dlq == "" {
+		return nil
+	}
+	if err := mb.checkDeadLetterCycle(queueName, dlq); err != nil {
+		return err
+	}
+	if mb.QueueExists(dlq) {
+		return nil
+	}
+	mb.logger.Info("Dead-letter queue `%s` of `%s` does not exist, creating it with defaults", dlq, queueName)
+	return mb.addQueue(dlq, defaultQueueConfig())
+}
+
+func (mb *MessageBroker) checkDeadLetterCycle(queueName, dlq string) error {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	for next := dlq; next != ""; next = mb.deadLetters[next] {
+		if next == queueName {
+			return fmt.Errorf("dead-letter queue `%s` would form a cycle with `%s`", dlq, queueName)
+		}
+	}
+	return nil
+}
+
+// UpdateQueue changes the config of a live queue without losing its messages. Zero values fall back to
+// the defaults, like in AddQueue. Limits below what the queue currently holds are rejected, wrapping
+// queue.ErrLimitBelowContents. The queue type and min length cannot be changed.
+func (mb *MessageBroker) UpdateQueue(queueName string, cfg config.QueueConfig) error {
+	return mb.updateQueue(queueName, cfg, false)
+}
+
+// ForceUpdateQueue is like UpdateQueue, but accepts limits below what the queue currently holds.
+// The queue keeps its messages and refuses new ones until it is back under its limits.
+func (mb *MessageBroker) ForceUpdateQueue(queueName string, cfg config.QueueConfig) error {
+	return mb.updateQueue(queueName, cfg, true)
+}
+
+func (mb *MessageBroker) updateQueue(queueName string, cfg config.QueueConfig, force bool) error {
+	mb.logger.Info("Trying to update queue `%s`: %s", queueName, cfg)
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		mb.logger.Error(err.Error())
+		return err
+	}
+	defer release()
+	current, err := mb.QueueConfig(queueName)
+	if err != nil {
+		mb.logger.Error(err.Error())
+		return err
+	}
+	if err = cfg.Validate(); err != nil {
+		mb.logger.Error("failed to update queue `%s`: %v", queueName, err)
+		return err
+	}
+	cfg.MinLength = determineMinLen(cfg.MinLength)
+	cfg.MaxLength = determineMaxLen(cfg.MaxLength)
+	cfg.MaxSizeBytes = determineMaxSizeBytes(cfg.MaxSizeBytes)
+	cfg.TTL = determineTTL(cfg.TTL)
+	if err = checkImmutable(current, cfg); err != nil {
+		mb.logger.Error("failed to update queue `%s`: %v", queueName, err)
+		return err
+	}
+	// the dead-letter queue is set up before the queue is reconfigured, and removed again if that fails,
+	// so a failure leaves both the queue and its config as they were
+	dlqChanged := cfg.DeadLetterQueue != current.DeadLetterQueue
+	dlqCreated := dlqChanged && cfg.DeadLetterQueue != "" && !mb.QueueExists(cfg.DeadLetterQueue)
+	if dlqChanged {
+		if err = mb.ensureDeadLetterQueue(queueName, cfg.DeadLetterQueue); err != nil {
+			mb.logger.Error("failed to update dead-letter queue of `%s`: %v", queueName, err)
+			return err
+		}
+	}
+
+	if err = q.Reconfigure(cfg, force); err != nil {
+		mb.logger.Error("failed to update queue `%s`: %v", queueName, err)
+		if dlqCreated {
+			_ = mb.removeQueue(cfg.DeadLetterQueue)
+		}
+		return err
+	}
+	defer mb.retryUnsent(queueName, q, false)
+	if dlqChanged {
+		mb.mx.Lock()
+		if cfg.DeadLetterQueue == "" {
+			q.SetDeadLetterQueue(queueName, nil)
+			delete(mb.deadLetters, queueName)
+		} else {
+			q.SetDeadLetterQueue(queueName, mb.queues[cfg.DeadLetterQueue].q)
+			mb.deadLetters[queueName] = cfg.DeadLetterQueue
+		}
+		mb.mx.Unlock()
+	}
+	paused := q.Paused()
+	cfg.Paused = &paused
+	config.UpdateQueue(queueName, cfg)
+	mb.logger.Info("Queue `%s` updated", queueName)
+	return nil
+}
+
+// checkImmutable rejects changes to the parts of a queue's config which are fixed once it is created
+func checkImmutable(current, cfg config.QueueConfig) error {
+	if queueType(current.Type) != queueType(cfg.Type) {
+		return fmt.Errorf("the queue type cannot be changed from `%s` to `%s`", queueType(current.Type), queueType(cfg.Type))
+	}
+	if current.MinLength != cfg.MinLength {
+		return fmt.Errorf("the min length cannot be changed from %d to %d", current.MinLength, cfg.MinLength)
+	}
+	if current.Shards != cfg.Shards {
+		return fmt.Errorf("the shards cannot be changed from %d to %d", current.Shards, cfg.Shards)
+	}
+	return nil
+}
+
+func queueType(t string) string {
+	if t == "" {
+		return config.QueueTypeFIFO
+	}
+	return t
+}
+
+// QueueConfig returns the config a queue is running with, defaults included
+func (mb *MessageBroker) QueueConfig(queueName string) (config.QueueConfig, error) {
+	cfg, ok := config.GetRunningConfig().Broker.Queues[queueName]
+	if !ok {
+		return config.QueueConfig{}, fmt.Errorf("queue '%s' not found", queueName)
+	}
+	return cfg, nil
+}
+
+// DeadLetterQueue returns the name of the queue's dead-letter queue, if it has one
+func (mb *MessageBroker) DeadLetterQueue(queueName string) (string, bool) {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	dlq, ok := mb.deadLetters[queueName]
+	return dlq, ok
+}
+
+// formatMultipleErrors lists every queue's error. A single error is wrapped, so callers can still tell what it was.
 func (mb *MessageBroker) formatMultipleErrors(base string, errors map[string]error) error {
+	if len(errors) == 1 {
+		for queueName, err := range errors {
+			return fmt.Errorf("%s\n [%s] -> %w", base, queueName, err)
+		}
+	}
 	if len(errors) > 0 {
-		var queueName string
-		err := fmt.Errorf(base)
-		for queueName, err = range errors {
-			err = fmt.Errorf("\n [%s] -> %s", queueName, err)
+		msg := base
+		for queueName, err := range errors {
+			msg += fmt.Sprintf("\n [%s] -> %s", queueName, err)
 		}
-		return err
+		return fmt.Errorf("%s", msg)
 	}
 	return nil
 }
 
-func (mb *MessageBroker) PublishWithTTL(message string, ttl *time.Duration, queueNames ...string) (err error) {
+// MessageIDs maps each queue a message was published to onto the ID the message got in that queue
+type MessageIDs map[string]int
+
+func (mb *MessageBroker) PublishWithTTL(message string, ttl *time.Duration, queueNames ...string) (MessageIDs, error) {
+	return mb.PublishWithOptions(message, queue.MessageOptions{TTL: ttl}, queueNames...)
+}
+
+func (mb *MessageBroker) PublishWithOptions(message string, opts queue.MessageOptions, queueNames ...string) (MessageIDs, error) {
+	return mb.PublishBytes([]byte(message), opts, queueNames...)
+}
+
+// PublishBytes publishes a binary payload, optionally tagged with a content type through opts.
+// The returned IDs only cover the queues the message was published to successfully.
+func (mb *MessageBroker) PublishBytes(message []byte, opts queue.MessageOptions, queueNames ...string) (MessageIDs, error) {
+	return mb.PublishBytesContext(context.Background(), message, opts, queueNames...)
+}
+
+// PublishBytesContext is like PublishBytes, but stops waiting for room in queues with the block overflow policy once ctx is done
+func (mb *MessageBroker) PublishBytesContext(ctx context.Context, message []byte, opts queue.MessageOptions, queueNames ...string) (ids MessageIDs, err error) {
 	if len(queueNames) == 0 {
-		return fmt.Errorf("no queue name provided")
+		return nil, fmt.Errorf("no queue name provided")
 	}
+	ids = make(MessageIDs, len(queueNames))
 	errors := make(map[string]error)
 	for _, queueName := range queueNames {
-		if q, ok := mb.queues[queueName]; !ok {
-			errors[queueName] = fmt.Errorf("queue '%s' not found", queueName)
+		q, release, acquireErr := mb.acquire(queueName)
+		if acquireErr != nil {
+			errors[queueName] = acquireErr
 			mb.logger.Error(errors[queueName].Error())
+			continue
+		}
+		if id, pushErr := q.PushBytesContext(ctx, message, opts); pushErr != nil {
+			errors[queueName] = pushErr
+			mb.logger.Error("failed to push message to queue `%s`: %v", queueName, pushErr)
+			mb.spoolUnsent(queueName, message, opts, pushErr)
 		} else {
-			if _, err = q.PushWithTTL(message, ttl); err != nil {
-				errors[queueName] = err
-				mb.logger.Error("failed to push message to queue `%s`: %v", queueName, err)
-				mb.unsent[queueName] = append(mb.unsent[queueName], message)
-			}
+			ids[queueName] = id
 		}
+		release()
 	}
 	err = mb.formatMultipleErrors("one or more queues failed to send message:", errors)
 	if err != nil {
@@ -98,27 +364,242 @@ func (mb *MessageBroker) PublishWithTTL(message string, ttl *time.Duration, queu
 	return
 }
 
-func (mb *MessageBroker) Publish(message string, queueNames ...string) error {
+// PublishBatch publishes several messages to one queue at once. Either all of them are published, or none are.
+// A failed batch is not kept in the unsent spool.
+// Returns the ID of each message, in the order they were given.
+func (mb *MessageBroker) PublishBatch(queueName string, entries []queue.BatchEntry) ([]int, error) {
+	return mb.PublishBatchContext(context.Background(), queueName, entries)
+}
+
+// PublishBatchContext is like PublishBatch, but stops waiting for room if the queue has the block overflow policy once ctx is done
+func (mb *MessageBroker) PublishBatchContext(ctx context.Context, queueName string, entries []queue.BatchEntry) ([]int, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		mb.logger.Error(err.Error())
+		return nil, err
+	}
+	defer release()
+	ids, err := q.PushBatchBytesContext(ctx, entries)
+	if err != nil {
+		// a failed batch is not spooled, as retrying its messages one by one could publish only some of them
+		mb.logger.Error("failed to push batch of %d messages to queue `%s`: %v", len(entries), queueName, err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (mb *MessageBroker) Publish(message string, queueNames ...string) (MessageIDs, error) {
 	return mb.PublishWithTTL(message, nil, queueNames...)
 }
 
-func (mb *MessageBroker) Broadcast(message string) error {
+func (mb *MessageBroker) Broadcast(message string) (MessageIDs, error) {
 	return mb.Publish(message, mb.Queues()...)
 }
 
-func (mb *MessageBroker) BroadcastWithTTL(message string, ttl *time.Duration) error {
+func (mb *MessageBroker) BroadcastWithTTL(message string, ttl *time.Duration) (MessageIDs, error) {
 	return mb.PublishWithTTL(message, ttl, mb.Queues()...)
 }
 
+func (mb *MessageBroker) BroadcastWithOptions(message string, opts queue.MessageOptions) (MessageIDs, error) {
+	return mb.PublishWithOptions(message, opts, mb.Queues()...)
+}
+
+// ConsumeMessage is like Consume, but also returns what the queue knows about the message
+func (mb *MessageBroker) ConsumeMessage(queueName string) (queue.Message, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return queue.Message{}, err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	return q.PopMessage()
+}
+
+// ConsumeMessageContext is like ConsumeMessage, but waits for a message until ctx is done
+func (mb *MessageBroker) ConsumeMessageContext(ctx context.Context, queueName string) (queue.Message, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return queue.Message{}, err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	return q.PopMessageContext(ctx)
+}
+
+// ConsumeBatch consumes up to max messages at once. Fails with queue.ErrQueueEmpty if there are none.
+func (mb *MessageBroker) ConsumeBatch(queueName string, max int) ([]queue.Message, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	return q.PopN(max)
+}
+
+// ConsumeBatchContext is like ConsumeBatch, but waits for at least one message until ctx is done
+func (mb *MessageBroker) ConsumeBatchContext(ctx context.Context, queueName string, max int) ([]queue.Message, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	return q.PopNContext(ctx, max)
+}
+
+// ConsumeContext is like Consume, but waits for a message until ctx is done
+func (mb *MessageBroker) ConsumeContext(ctx context.Context, queueName string) (string, error) {
+	msg, err := mb.ConsumeMessageContext(ctx, queueName)
+	return string(msg.Value), err
+}
+
 func (mb *MessageBroker) Consume(queueName string) (string, error) {
-	if q, ok := mb.queues[queueName]; !ok {
-		return "", fmt.Errorf("queue '%s' not found", queueName)
-	} else {
-		return q.Pop()
+	msg, err := mb.ConsumeMessage(queueName)
+	return string(msg.Value), err
+}
+
+// ConsumeLease takes the next message from the queue under a lease, which must then be acked or nacked.
+// A nil visibility uses the queue's configured visibility timeout.
+func (mb *MessageBroker) ConsumeLease(queueName string, visibility *time.Duration) (queue.Lease, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return queue.Lease{}, err
+	}
+	defer release()
+	return q.PopLease(visibility)
+}
+
+// ConsumeLeaseContext is like ConsumeLease, but waits for a message until ctx is done
+func (mb *MessageBroker) ConsumeLeaseContext(ctx context.Context, queueName string, visibility *time.Duration) (queue.Lease, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return queue.Lease{}, err
+	}
+	defer release()
+	return q.PopLeaseContext(ctx, visibility)
+}
+
+func (mb *MessageBroker) Ack(queueName, receipt string) error {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	return q.Ack(receipt)
+}
+
+func (mb *MessageBroker) Nack(queueName, receipt string) error {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return q.Nack(receipt)
+}
+
+// Browse lists up to limit of the next messages in the queue without consuming them
+func (mb *MessageBroker) Browse(queueName string, limit int) ([]queue.MessageInfo, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return q.Browse(limit), nil
+}
+
+// BrowseAfter is like Browse, but lists the messages following the one with the given ID
+func (mb *MessageBroker) BrowseAfter(queueName string, after, limit int) ([]queue.MessageInfo, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
+	return q.BrowseAfter(after, limit)
+}
+
+// GetMessage describes the message with the given ID, wherever it is in the queue, without consuming it
+func (mb *MessageBroker) GetMessage(queueName string, id int) (queue.MessageInfo, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return queue.MessageInfo{}, err
+	}
+	defer release()
+	return q.Get(id)
+}
+
+// DeleteMessage removes the message with the given ID from the queue for good
+func (mb *MessageBroker) DeleteMessage(queueName string, id int) error {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	return q.Delete(id)
+}
+
+// PauseQueue stops consuming from a queue, publishing into it, or both, until it is resumed.
+// The direction is one of the config.Pause values, and the paused state is kept in the running config.
+func (mb *MessageBroker) PauseQueue(queueName, direction string) error {
+	return mb.setPaused(queueName, direction, (*queue.Queue).Pause)
+}
+
+// ResumeQueue undoes PauseQueue for the given direction
+func (mb *MessageBroker) ResumeQueue(queueName, direction string) error {
+	return mb.setPaused(queueName, direction, (*queue.Queue).Resume)
+}
+
+func (mb *MessageBroker) setPaused(queueName, direction string, set func(*queue.Queue, string) error) error {
+	// keeps an update of the queue from saving a pause which is being changed
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	if err = set(q, direction); err != nil {
+		return err
+	}
+	paused := q.Paused()
+	config.SetQueuePaused(queueName, paused)
+	mb.logger.Info("Queue `%s` paused: %s", queueName, util.BoolLabels(paused != "", paused, "none"))
+	return nil
+}
+
+// QueuePaused returns which directions of a queue are paused, or an empty string if neither is
+func (mb *MessageBroker) QueuePaused(queueName string) (string, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return q.Paused(), nil
+}
+
+// PurgeQueue throws away the messages of a queue which were published at least olderThan ago,
+// or all of them if olderThan is not positive, while keeping the queue itself. Returns how many were purged.
+func (mb *MessageBroker) PurgeQueue(queueName string, olderThan time.Duration) (int, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	defer mb.retryUnsent(queueName, q, false)
+	purged := q.Purge(olderThan)
+	mb.logger.Info("Purged %d messages from queue `%s`", purged, queueName)
+	return purged, nil
 }
 
 func (mb *MessageBroker) Stats() map[string]telemetry.QueueStats {
+	// queues notice run out leases and due messages lazily, so they are brought up to date first
+	for _, q := range mb.snapshot() {
+		q.Refresh()
+	}
 	stats := mb.stats.Stats()
 	if mb.logger.GetLevel() <= log.LevelDebug {
 		// slightly more complex debug logic to avoid marshaling each time
@@ -130,33 +611,95 @@ func (mb *MessageBroker) Stats() map[string]telemetry.QueueStats {
 	return stats
 }
 
+// QueueExists tells whether a queue by the name exists, whatever its lifecycle state
 func (mb *MessageBroker) QueueExists(queueName string) bool {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
 	_, ok := mb.queues[queueName]
 	return ok
 }
 
+// Queues lists the active queues
 func (mb *MessageBroker) Queues() (queueNames []string) {
-	for queueName := range mb.queues {
-		queueNames = append(queueNames, queueName)
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	for queueName, mq := range mb.queues {
+		if mq.state == QueueActive {
+			queueNames = append(queueNames, queueName)
+		}
 	}
 	return
 }
 
+// RemoveQueue deletes a queue along with its messages and its bindings to exchanges. The queue stops
+// accepting operations straight away, and is paused so consumers and publishers blocked on it give up,
+// then RemoveQueue waits for the operations in flight on it to finish before deleting it.
 func (mb *MessageBroker) RemoveQueue(queueName string) error {
 	mb.logger.Info("Trying to remove queue `%s`", queueName)
-	_, ok := mb.queues[queueName]
-	if !ok {
-		err := fmt.Errorf("queue '%s' not found", queueName)
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+	return mb.removeQueue(queueName)
+}
+
+// removeQueue needs the management lock
+func (mb *MessageBroker) removeQueue(queueName string) error {
+	mq, err := mb.startDraining(queueName)
+	if err != nil {
 		mb.logger.Error(err.Error())
 		return err
 	}
-	delete(mb.queues, queueName)
+	// a queue which is removed is gone for good, so its pause is not kept in the config
+	_ = mq.q.Pause(config.PauseBoth)
+	mq.ops.Wait()
+
+	mb.setState(mq, QueueDeleting)
+	mb.unbindQueue(queueName)
 	mb.stats.RemoveQueue(queueName)
 	config.DeleteQueue(queueName)
+	mb.unsentMx.Lock()
+	if sp, ok := mb.unsent[queueName]; ok {
+		mb.unsentLen.Add(int64(-len(sp.messages)))
+		delete(mb.unsent, queueName)
+	}
+	mb.unsentMx.Unlock()
+	mb.mx.Lock()
+	delete(mb.queues, queueName)
+	delete(mb.deadLetters, queueName)
+	mb.mx.Unlock()
 	// TODO: Save the queue messages?
 	mb.logger.Info("Queue `%s` removed", queueName)
 	return nil
+}
 
+// startDraining moves an active queue which is not the dead-letter queue of another one into draining
+func (mb *MessageBroker) startDraining(queueName string) (*managedQueue, error) {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	mq, ok := mb.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("queue '%s' not found", queueName)
+	}
+	if mq.state != QueueActive {
+		return nil, fmt.Errorf("%w: queue '%s' is %s", ErrQueueUnavailable, queueName, mq.state)
+	}
+	for source, dlq := range mb.deadLetters {
+		if dlq == queueName && source != queueName {
+			return nil, fmt.Errorf("queue '%s' is the dead-letter queue of '%s'", queueName, source)
+		}
+	}
+	mq.state = QueueDraining
+	return mq, nil
 }
 
-// Todo: Close() error -> dump queues to file or share to peers. Also, peers?
+// Close stops the broker's background work and waits for it to finish.
+// Todo: dump queues to file or share to peers. Also, peers?
+func (mb *MessageBroker) Close() error {
+	mb.mx.Lock()
+	r := mb.reaper
+	mb.reaper = nil
+	mb.mx.Unlock()
+	if r != nil {
+		r.stop()
+	}
+	return nil
+}
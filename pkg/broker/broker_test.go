@@ -1,6 +1,9 @@
 package broker
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -82,7 +85,7 @@ func TestBrokerBasics(t *testing.T) {
 
 	assert.Len(t, mb.queues, 0, "broker should have 0 queues after deletion")
 	assert.Len(t, mb.Stats(), 0, "broker queue stats for deleted queue remained")
-	assert.Len(t, mb.unsent, 1, "broker unsent box for deleted queue should remain")
+	assert.Len(t, mb.unsent, 0, "broker unsent spool for deleted queue remained")
 
 }
 
@@ -101,13 +104,13 @@ func TestBrokerPublishConsume(t *testing.T) {
 	_, err = mb.Consume("test")
 	assert.ErrorAs(t, err, &queue.ErrQueueEmpty, "expected to fail to consume from empty queue")
 
-	err = mb.Publish("my test message")
+	_, err = mb.Publish("my test message")
 	assert.Error(t, err, "expected fail for: no queue was specified")
 
-	err = mb.Publish("my test message", "nonexistentqueue")
+	_, err = mb.Publish("my test message", "nonexistentqueue")
 	assert.Error(t, err, "expected fail for: an unknown queue was specified")
 
-	err = mb.Publish("my test message", "test")
+	_, err = mb.Publish("my test message", "test")
 	assert.NoError(t, err, "failed to publish message")
 
 	msg, err := mb.Consume("test")
@@ -115,7 +118,7 @@ func TestBrokerPublishConsume(t *testing.T) {
 	assert.Equal(t, "my test message", msg, "failed to consume message")
 
 	oneNs := time.Nanosecond
-	err = mb.PublishWithTTL("fast disappearing", &oneNs, "test")
+	_, err = mb.PublishWithTTL("fast disappearing", &oneNs, "test")
 	assert.NoError(t, err, "failed to publish message")
 	time.Sleep(time.Millisecond * 10)
 	msg, err = mb.Consume("test")
@@ -135,7 +138,7 @@ func TestBrokerBroadcast(t *testing.T) {
 	err = mb.AddDefaultQueue("test2")
 	assert.NoError(t, err, "failed to add test2 queue")
 
-	err = mb.Broadcast("my test message")
+	_, err = mb.Broadcast("my test message")
 	assert.NoError(t, err, "broadcast failed")
 
 	msg, err := mb.Consume("test1")
@@ -146,3 +149,708 @@ func TestBrokerBroadcast(t *testing.T) {
 	assert.NoError(t, err, "consume failed for queue test2")
 	assert.Equal(t, "my test message", msg, "consume failed")
 }
+
+func TestBrokerLeases(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+
+	_, err := mb.ConsumeLease("test", nil)
+	assert.Error(t, err, "expected to fail to lease from non existent queue")
+
+	err = mb.AddDefaultQueue("test")
+	assert.NoError(t, err, "failed to add test queue")
+
+	_, err = mb.ConsumeLease("test", nil)
+	assert.ErrorIs(t, err, queue.ErrQueueEmpty, "expected to fail to lease from empty queue")
+
+	_, err = mb.Publish("my test message", "test")
+	assert.NoError(t, err, "failed to publish message")
+
+	l, err := mb.ConsumeLease("test", nil)
+	assert.NoError(t, err, "failed to lease message")
+	assert.Equal(t, "my test message", string(l.Value), "leased wrong message")
+
+	_, err = mb.Consume("test")
+	assert.ErrorIs(t, err, queue.ErrQueueEmpty, "leased message should be invisible")
+
+	err = mb.Nack("test", l.Receipt)
+	assert.NoError(t, err, "failed to nack message")
+	err = mb.Ack("test", l.Receipt)
+	assert.ErrorIs(t, err, queue.ErrLeaseNotFound, "acked a nacked lease")
+
+	l, err = mb.ConsumeLease("test", nil)
+	assert.NoError(t, err, "failed to lease nacked message")
+	assert.Equal(t, "my test message", string(l.Value), "nacked message was not redelivered")
+
+	err = mb.Ack("test", l.Receipt)
+	assert.NoError(t, err, "failed to ack message")
+	assert.Equal(t, int64(1), mb.Stats()["test"].Processed, "ack should count as processed")
+	assert.Equal(t, int64(1), mb.Stats()["test"].Requeued, "nack should count as requeued")
+
+	_, err = mb.Publish("my test message", "test")
+	assert.NoError(t, err, "failed to publish message")
+	visibility := time.Millisecond
+	_, err = mb.ConsumeLease("test", &visibility)
+	assert.NoError(t, err, "failed to lease message")
+	assert.Equal(t, int64(1), mb.Stats()["test"].InFlight, "mismatched in-flight count")
+	time.Sleep(visibility * 2)
+	assert.Zero(t, mb.Stats()["test"].InFlight, "stats should not count leases which ran out as in flight")
+}
+
+func TestBrokerDeadLetterQueues(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+
+	err := mb.AddQueue("self", config.QueueConfig{DeadLetterQueue: "self"})
+	assert.Error(t, err, "added a queue which is its own dead-letter queue")
+	assert.False(t, mb.QueueExists("self"), "queue with invalid dead-letter queue should not exist")
+
+	err = mb.AddQueue("test", config.QueueConfig{DeadLetterQueue: "test-dlq", MaxDeliveries: 1})
+	assert.NoError(t, err, "failed to add queue with dead-letter queue")
+	assert.True(t, mb.QueueExists("test-dlq"), "dead-letter queue was not created")
+	dlq, ok := mb.DeadLetterQueue("test")
+	assert.True(t, ok, "dead-letter queue not recorded")
+	assert.Equal(t, "test-dlq", dlq)
+
+	err = mb.RemoveQueue("test-dlq")
+	assert.Error(t, err, "removed a dead-letter queue which is still in use")
+
+	_, err = mb.Publish("my test message", "test")
+	assert.NoError(t, err, "failed to publish message")
+	l, err := mb.ConsumeLease("test", nil)
+	assert.NoError(t, err, "failed to lease message")
+	assert.NoError(t, mb.Nack("test", l.Receipt), "failed to nack message")
+
+	msg, err := mb.ConsumeMessage("test-dlq")
+	assert.NoError(t, err, "failed to consume from dead-letter queue")
+	assert.Equal(t, "my test message", string(msg.Value))
+	assert.Equal(t, "test", msg.DeadLetter.Queue)
+	assert.Equal(t, queue.DeadLetterMaxDeliveries, msg.DeadLetter.Reason)
+
+	assert.NoError(t, mb.RemoveQueue("test"), "failed to remove queue")
+	assert.NoError(t, mb.RemoveQueue("test-dlq"), "failed to remove unused dead-letter queue")
+
+	err = mb.AddQueues(config.QueueMap{
+		"a":     {DeadLetterQueue: "b"},
+		"b":     {DeadLetterQueue: "c", MaxLength: 7},
+		"c":     {MaxLength: 3},
+		"loop1": {DeadLetterQueue: "loop2"},
+		"loop2": {DeadLetterQueue: "loop1"},
+	})
+	assert.Error(t, err, "added queues with cyclic dead-letter queues")
+	assert.False(t, mb.QueueExists("loop1") || mb.QueueExists("loop2"), "added queues with cyclic dead-letter queues")
+	assert.True(t, mb.QueueExists("a") && mb.QueueExists("b") && mb.QueueExists("c"), "failed to add chained queues")
+	assert.Equal(t, int64(3), config.GetRunningConfig().Broker.Queues["c"].MaxLength, "dead-letter queue should keep its own config")
+}
+
+func TestBrokerPriority(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+
+	err := mb.AddQueue("test", config.QueueConfig{Type: "lifo"})
+	assert.Error(t, err, "added a queue with an unknown type")
+
+	err = mb.AddQueue("test", config.QueueConfig{Type: config.QueueTypePriority})
+	assert.NoError(t, err, "failed to add priority queue")
+
+	_, err = mb.Publish("normal", "test")
+	assert.NoError(t, err, "failed to publish message")
+	_, err = mb.PublishWithOptions("urgent", queue.MessageOptions{Priority: 10}, "test")
+	assert.NoError(t, err, "failed to publish message")
+
+	msg, err := mb.ConsumeMessage("test")
+	assert.NoError(t, err, "failed to consume message")
+	assert.Equal(t, "urgent", string(msg.Value), "expected the urgent message first")
+	assert.Equal(t, 10, msg.Priority)
+
+	val, err := mb.Consume("test")
+	assert.NoError(t, err, "failed to consume message")
+	assert.Equal(t, "normal", val)
+}
+
+func TestBrokerScheduled(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("test"), "failed to add test queue")
+
+	_, err := mb.PublishWithOptions("later", queue.MessageOptions{Delay: time.Millisecond * 20}, "test")
+	assert.NoError(t, err, "failed to publish delayed message")
+	_, err = mb.Consume("test")
+	assert.ErrorIs(t, err, queue.ErrQueueEmpty, "consumed a message before it was due")
+	assert.Equal(t, int64(1), mb.Stats()["test"].Scheduled, "mismatched scheduled count")
+
+	time.Sleep(time.Millisecond * 20)
+	msg, err := mb.Consume("test")
+	assert.NoError(t, err, "failed to consume due message")
+	assert.Equal(t, "later", msg)
+}
+
+func TestBrokerConsumeContext(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := mb.ConsumeContext(ctx, "test")
+	assert.Error(t, err, "expected to fail to consume from non existent queue")
+
+	assert.NoError(t, mb.AddDefaultQueue("test"), "failed to add test queue")
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_, _ = mb.Publish("my test message", "test")
+	}()
+	msg, err := mb.ConsumeContext(ctx, "test")
+	assert.NoError(t, err, "failed to wait for message")
+	assert.Equal(t, "my test message", msg)
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer shortCancel()
+	_, err = mb.ConsumeLeaseContext(shortCtx, "test", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "expected to time out waiting on an empty queue")
+}
+
+func TestBrokerBrowse(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+
+	_, err := mb.Browse("test", 10)
+	assert.Error(t, err, "expected to fail to browse non existent queue")
+
+	assert.NoError(t, mb.AddDefaultQueue("test"), "failed to add test queue")
+	_, err = mb.Publish("first", "test")
+	assert.NoError(t, err, "failed to publish")
+	_, err = mb.Publish("second", "test")
+	assert.NoError(t, err, "failed to publish")
+
+	infos, err := mb.Browse("test", 1)
+	assert.NoError(t, err, "failed to browse")
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "first", string(infos[0].Value))
+
+	infos, err = mb.BrowseAfter("test", infos[0].ID, 10)
+	assert.NoError(t, err, "failed to browse after cursor")
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "second", string(infos[0].Value))
+
+	msg, err := mb.Consume("test")
+	assert.NoError(t, err, "failed to consume after browsing")
+	assert.Equal(t, "first", msg, "browsing should not consume")
+}
+
+func TestBrokerPublishBytes(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("test"), "failed to add test queue")
+
+	payload := []byte{0xde, 0xad, 0xbe, 0xef}
+	_, err := mb.PublishBytes(payload, queue.MessageOptions{ContentType: "image/png"}, "test")
+	assert.NoError(t, err, "failed to publish bytes")
+
+	msg, err := mb.ConsumeMessage("test")
+	assert.NoError(t, err, "failed to consume bytes")
+	assert.Equal(t, payload, msg.Value, "payload was not returned as is")
+	assert.Equal(t, "image/png", msg.ContentType, "mismatched content type")
+}
+
+func TestBrokerMessageIDs(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("a"), "failed to add queue")
+	assert.NoError(t, mb.AddDefaultQueue("b"), "failed to add queue")
+
+	ids, err := mb.Publish("message", "a", "b", "nonexistent")
+	assert.Error(t, err, "expected to fail to publish to non existent queue")
+	assert.Len(t, ids, 2, "expected an ID from each queue the message was published to")
+
+	info, err := mb.GetMessage("a", ids["a"])
+	assert.NoError(t, err, "failed to get message by ID")
+	assert.Equal(t, "message", string(info.Value))
+
+	assert.NoError(t, mb.DeleteMessage("a", ids["a"]), "failed to delete message by ID")
+	_, err = mb.Consume("a")
+	assert.ErrorIs(t, err, queue.ErrQueueEmpty, "deleted message was consumed")
+	_, err = mb.GetMessage("b", ids["b"])
+	assert.NoError(t, err, "deleting from one queue should not affect another")
+}
+
+func TestBrokerReaper(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("test"), "failed to add test queue")
+
+	ttl := time.Millisecond * 5
+	_, err := mb.PublishWithTTL("expiring", &ttl, "test")
+	assert.NoError(t, err, "failed to publish")
+
+	mb.StartReaper(time.Millisecond * 10)
+	mb.StartReaper(time.Millisecond * 10) // no-op
+	assert.Eventually(t, func() bool {
+		return mb.Stats()["test"].Dropped == 1
+	}, time.Second, time.Millisecond*5, "reaper did not drop the expired message")
+
+	assert.NoError(t, mb.Close(), "failed to close broker")
+	assert.NoError(t, mb.Close(), "closing twice should be harmless")
+
+	_, err = mb.PublishWithTTL("expiring", &ttl, "test")
+	assert.NoError(t, err, "failed to publish")
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, int64(1), mb.Stats()["test"].Dropped, "reaper kept running after close")
+	assert.Equal(t, map[string]int{"test": 1}, mb.Reap(), "mismatched manual reap")
+}
+
+func TestBrokerBatch(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("a"), "failed to add queue")
+
+	ids, err := mb.PublishBatch("a", []queue.BatchEntry{{Value: []byte("1")}, {Value: []byte("2")}, {Value: []byte("3")}})
+	assert.NoError(t, err, "failed to publish batch")
+	assert.Len(t, ids, 3, "expected an ID for each message")
+	_, err = mb.PublishBatch("nonexistent", []queue.BatchEntry{{Value: []byte("1")}})
+	assert.Error(t, err, "expected to fail to publish to non existent queue")
+
+	messages, err := mb.ConsumeBatch("a", 2)
+	assert.NoError(t, err, "failed to consume batch")
+	assert.Len(t, messages, 2, "mismatched batch size")
+	messages, err = mb.ConsumeBatch("a", 2)
+	assert.NoError(t, err, "failed to consume batch")
+	if assert.Len(t, messages, 1, "mismatched batch size") {
+		assert.Equal(t, "3", string(messages[0].Value))
+	}
+	_, err = mb.ConsumeBatch("a", 2)
+	assert.ErrorIs(t, err, queue.ErrQueueEmpty, "consumed a batch from an empty queue")
+}
+
+func TestBrokerPause(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("a"), "failed to add queue")
+
+	assert.NoError(t, mb.PauseQueue("a", config.PausePublish), "failed to pause queue")
+	assert.Equal(t, config.PausePublish, config.GetRunningConfig().Broker.Queues["a"].PausedDirection(), "paused state not persisted")
+	_, err := mb.Publish("message", "a")
+	assert.ErrorIs(t, err, queue.ErrPublishPaused, "published to a paused queue")
+	assert.Error(t, mb.PauseQueue("nonexistent", config.PauseBoth), "paused a non existent queue")
+
+	assert.NoError(t, mb.ResumeQueue("a", config.PauseBoth), "failed to resume queue")
+	paused, err := mb.QueuePaused("a")
+	assert.NoError(t, err)
+	assert.Empty(t, paused)
+	assert.Empty(t, config.GetRunningConfig().Broker.Queues["a"].PausedDirection(), "resumed state not persisted")
+}
+
+func TestBrokerPurge(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("a"), "failed to add queue")
+	_, err := mb.Publish("message", "a")
+	assert.NoError(t, err, "failed to publish")
+
+	purged, err := mb.PurgeQueue("a", 0)
+	assert.NoError(t, err, "failed to purge queue")
+	assert.Equal(t, 1, purged)
+	assert.True(t, mb.QueueExists("a"), "purging should keep the queue")
+	assert.Equal(t, int64(1), mb.Stats()["a"].Purged, "mismatched purged count")
+	_, err = mb.PurgeQueue("nonexistent", 0)
+	assert.Error(t, err, "purged a non existent queue")
+}
+
+func TestBrokerUpdateQueue(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddQueue("a", config.QueueConfig{MaxLength: 2}), "failed to add queue")
+	_, err := mb.Publish("message", "a")
+	assert.NoError(t, err, "failed to publish")
+	_, err = mb.Publish("message", "a")
+	assert.NoError(t, err, "failed to publish")
+
+	cfg, err := mb.QueueConfig("a")
+	assert.NoError(t, err, "failed to get queue config")
+	cfg.MaxLength = 1
+	cfg.DeadLetterQueue = "a-dlq"
+	assert.ErrorIs(t, mb.UpdateQueue("a", cfg), queue.ErrLimitBelowContents, "shrunk the queue below its length")
+	assert.False(t, mb.QueueExists("a-dlq"), "a failed update should remove the dead-letter queue it created")
+	assert.NotContains(t, config.GetRunningConfig().Broker.Queues, "a-dlq")
+	assert.Empty(t, config.GetRunningConfig().Broker.Queues["a"].DeadLetterQueue, "a failed update should not be saved")
+	cfg.MaxLength = 5
+	assert.NoError(t, mb.UpdateQueue("a", cfg), "failed to update queue")
+	assert.Equal(t, int64(5), config.GetRunningConfig().Broker.Queues["a"].MaxLength, "running config not updated")
+	assert.True(t, mb.QueueExists("a-dlq"), "new dead-letter queue was not created")
+	dlq, ok := mb.DeadLetterQueue("a")
+	assert.True(t, ok)
+	assert.Equal(t, "a-dlq", dlq)
+
+	cfg.Type = config.QueueTypePriority
+	assert.Error(t, mb.UpdateQueue("a", cfg), "changed the queue type")
+	cfg.Type = config.QueueTypeSharded
+	assert.Error(t, mb.UpdateQueue("a", cfg), "made a queue sharded")
+	cfg.Type = ""
+	cfg.MaxLength = 1
+	assert.NoError(t, mb.ForceUpdateQueue("a", cfg), "failed to force an update")
+	_, err = mb.Consume("a")
+	assert.NoError(t, err, "messages should be kept by a forced update")
+	assert.Error(t, mb.UpdateQueue("nonexistent", cfg), "updated a non existent queue")
+
+	assert.NoError(t, mb.PauseQueue("a", config.PauseConsume), "failed to pause queue")
+	cfg.Paused = nil
+	assert.NoError(t, mb.ForceUpdateQueue("a", cfg), "failed to update queue")
+	paused, err := mb.QueuePaused("a")
+	assert.NoError(t, err)
+	assert.Equal(t, config.PauseConsume, paused, "an update without a pause should keep the queue paused")
+	assert.Equal(t, config.PauseConsume, config.GetRunningConfig().Broker.Queues["a"].PausedDirection(), "pause not kept in the running config")
+	resume := ""
+	cfg.Paused = &resume
+	assert.NoError(t, mb.ForceUpdateQueue("a", cfg), "failed to update queue")
+	paused, err = mb.QueuePaused("a")
+	assert.NoError(t, err)
+	assert.Empty(t, paused, "an update with an empty pause should resume the queue")
+}
+
+func TestBrokerTyped(t *testing.T) {
+
+	setDefaults()
+
+	type event struct {
+		Name string
+		At   time.Time
+	}
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddQueue("a", config.QueueConfig{}), "failed to add queue")
+	assert.NoError(t, mb.AddQueue("b", config.QueueConfig{}), "failed to add queue")
+	events := NewTyped[event](mb, nil)
+
+	now := time.Now()
+	ids, err := events.Publish(event{Name: "created", At: now}, "a", "b")
+	assert.NoError(t, err, "failed to publish typed value")
+	assert.Len(t, ids, 2)
+	_, err = events.Publish(event{}, "nonexistent")
+	assert.Error(t, err, "published to a non existent queue")
+
+	e, err := events.Consume("a")
+	assert.NoError(t, err, "failed to consume typed value")
+	assert.Equal(t, event{Name: "created", At: now}, e)
+	e, l, err := events.ConsumeLease("b", nil)
+	assert.NoError(t, err, "failed to lease typed value")
+	assert.Equal(t, "created", e.Name)
+	assert.NoError(t, mb.Ack("b", l.Receipt), "failed to ack typed lease")
+
+	_, err = events.Consume("a")
+	assert.ErrorIs(t, err, queue.ErrQueueEmpty)
+	_, err = mb.Publish("raw", "a")
+	assert.NoError(t, err, "failed to publish")
+	_, err = events.Consume("a")
+	assert.ErrorIs(t, err, queue.ErrUnexpectedType, "consumed a string as an event")
+}
+
+func TestBrokerLifecycle(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	_, err := mb.QueueState("a")
+	assert.Error(t, err, "got the state of a non existent queue")
+	assert.NoError(t, mb.AddDefaultQueue("a"), "failed to add queue")
+	state, err := mb.QueueState("a")
+	assert.NoError(t, err, "failed to get queue state")
+	assert.Equal(t, QueueActive, state)
+
+	// a queue which is not active refuses operations, and is left out of the queue list
+	mb.setState(mb.queues["a"], QueueDraining)
+	_, err = mb.Publish("message", "a")
+	assert.ErrorIs(t, err, ErrQueueUnavailable, "published to a draining queue")
+	_, err = mb.Consume("a")
+	assert.ErrorIs(t, err, ErrQueueUnavailable, "consumed from a draining queue")
+	assert.ErrorIs(t, mb.RemoveQueue("a"), ErrQueueUnavailable, "removed a draining queue twice")
+	assert.Error(t, mb.AddDefaultQueue("a"), "added a queue by the name of a draining one")
+	assert.True(t, mb.QueueExists("a"), "draining queue should still exist")
+	assert.Empty(t, mb.Queues(), "draining queue should not be listed")
+	mb.setState(mb.queues["a"], QueueActive)
+
+	// removing a queue stops the consumers waiting on it rather than waiting for them to time out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	consumed := make(chan error, 1)
+	go func() {
+		_, err := mb.ConsumeContext(ctx, "a")
+		consumed <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	assert.NoError(t, mb.RemoveQueue("a"), "failed to remove queue")
+	select {
+	case err = <-consumed:
+		assert.Error(t, err, "consumed from a removed queue")
+		assert.NotErrorIs(t, err, context.DeadlineExceeded, "consumer should not wait for the removed queue")
+	case <-ctx.Done():
+		t.Fatal("consumer still waits on a removed queue")
+	}
+
+	assert.False(t, mb.QueueExists("a"), "removed queue still exists")
+	_, err = mb.Publish("message", "a")
+	assert.Error(t, err, "published to a removed queue")
+	_, err = mb.QueueState("a")
+	assert.Error(t, err, "got the state of a removed queue")
+	assert.NoError(t, mb.AddDefaultQueue("a"), "failed to add a queue by the name of a removed one")
+}
+
+func TestBrokerConcurrency(t *testing.T) {
+
+	setDefaults()
+
+	const (
+		workers    = 8
+		iterations = 200
+	)
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddDefaultQueue("shared"), "failed to add queue")
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				name := fmt.Sprintf("q%d", (w+i)%4)
+				_ = mb.AddQueue(name, config.QueueConfig{DeadLetterQueue: "dlq"})
+				_, _ = mb.Publish("message", name, "shared")
+				_, _ = mb.Consume(name)
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				_, _ = mb.ConsumeContext(ctx, "shared")
+				cancel()
+				_, _ = mb.Broadcast("broadcast")
+				_ = mb.Queues()
+				_ = mb.Stats()
+				_, _ = mb.QueueState(name)
+				_ = mb.RemoveQueue(name)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	queues := mb.Queues()
+	assert.Contains(t, queues, "shared")
+	assert.Len(t, mb.Stats(), len(queues), "stats out of sync with the queues")
+	running := config.GetRunningConfig().Broker.Queues
+	for w := 0; w < 4; w++ {
+		name := fmt.Sprintf("q%d", w)
+		_, configured := running[name]
+		assert.Equal(t, mb.QueueExists(name), configured, "running config out of sync with queue `%s`", name)
+	}
+	for _, queueName := range queues {
+		state, err := mb.QueueState(queueName)
+		assert.NoError(t, err)
+		assert.Equal(t, QueueActive, state, "queue `%s` left behind in a transitional state", queueName)
+		assert.NoError(t, mb.RemoveQueue(queueName), "failed to remove queue `%s`", queueName)
+	}
+}
+
+func TestBrokerUnsent(t *testing.T) {
+
+	setDefaults()
+	SetMaxUnsent(3)
+	defer SetMaxUnsent(0)
+
+	mb := New(testLogger())
+	assert.NoError(t, mb.AddQueue("a", config.QueueConfig{MaxLength: 1}), "failed to add queue")
+	_, err := mb.Publish("m1", "a")
+	assert.NoError(t, err, "failed to publish")
+	_, err = mb.Publish("m2", "a")
+	assert.ErrorIs(t, err, queue.ErrQueueFull, "published into a full queue")
+	unsent, err := mb.Unsent("a")
+	assert.NoError(t, err, "failed to list unsent messages")
+	if assert.Len(t, unsent.Messages, 1, "failed publish was not spooled") {
+		assert.Equal(t, "m2", string(unsent.Messages[0].Value))
+		assert.ErrorIs(t, unsent.Messages[0].Err, queue.ErrQueueFull)
+		assert.Equal(t, 1, unsent.Messages[0].Attempts)
+	}
+	_, err = mb.PublishWithOptions("m", queue.MessageOptions{Headers: map[string]string{"": "empty key"}}, "a")
+	assert.ErrorIs(t, err, queue.ErrInvalidHeaders)
+	unsent, _ = mb.Unsent("a")
+	assert.Len(t, unsent.Messages, 1, "spooled a message which can never be published")
+
+	// consuming makes room, which the spooled message takes straight away
+	msg, err := mb.Consume("a")
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "m1", msg)
+	unsent, _ = mb.Unsent("a")
+	assert.Empty(t, unsent.Messages, "spooled message was not retried")
+	msg, err = mb.Consume("a")
+	assert.NoError(t, err, "failed to consume retried message")
+	assert.Equal(t, "m2", msg)
+
+	// the spool is bounded and drops its oldest messages
+	_, err = mb.Publish("m3", "a")
+	assert.NoError(t, err, "failed to publish")
+	for _, m := range []string{"m4", "m5", "m6", "m7"} {
+		_, err = mb.Publish(m, "a")
+		assert.ErrorIs(t, err, queue.ErrQueueFull)
+	}
+	unsent, _ = mb.Unsent("a")
+	assert.Len(t, unsent.Messages, 3, "spool grew past its limit")
+	assert.Equal(t, int64(1), unsent.Dropped)
+	assert.Equal(t, "m5", string(unsent.Messages[0].Value), "spool should drop its oldest messages")
+	retried, err := mb.RetryUnsent("a")
+	assert.NoError(t, err, "failed to retry unsent messages")
+	assert.Zero(t, retried, "published into a full queue")
+	unsent, _ = mb.Unsent("a")
+	assert.Equal(t, 2, unsent.Messages[0].Attempts, "failed retry was not counted")
+	assert.Equal(t, int64(5), mb.Stats()["a"].OverflowRejected, "a failed retry should not count as another rejection")
+
+	// a paused queue turns publishers away on purpose, so their messages are not kept
+	assert.NoError(t, mb.PauseQueue("a", config.PausePublish), "failed to pause queue")
+	_, err = mb.Publish("paused", "a")
+	assert.ErrorIs(t, err, queue.ErrPublishPaused)
+	unsent, _ = mb.Unsent("a")
+	assert.Len(t, unsent.Messages, 3, "spooled a message rejected by a paused queue")
+	assert.NoError(t, mb.ResumeQueue("a", config.PausePublish), "failed to resume queue")
+
+	// consuming retries the spool for as long as there is room
+	msg, _ = mb.Consume("a")
+	assert.Equal(t, "m3", msg)
+	unsent, _ = mb.Unsent("a")
+	assert.Len(t, unsent.Messages, 2, "expected one message to be retried")
+	assert.ErrorIs(t, unsent.Messages[0].Err, queue.ErrQueueFull)
+	discarded, err := mb.DiscardUnsent("a")
+	assert.NoError(t, err, "failed to discard unsent messages")
+	assert.Equal(t, 2, discarded)
+	unsent, _ = mb.Unsent("a")
+	assert.Empty(t, unsent.Messages, "unsent messages were not discarded")
+	msg, _ = mb.Consume("a")
+	assert.Equal(t, "m5", msg)
+
+	// a batch is published whole or not at all, so a failed one is not spooled
+	_, err = mb.PublishBatch("a", []queue.BatchEntry{{Value: []byte("b1")}, {Value: []byte("b2")}})
+	assert.ErrorIs(t, err, queue.ErrQueueFull)
+	unsent, _ = mb.Unsent("a")
+	assert.Empty(t, unsent.Messages, "spooled the messages of a failed batch")
+
+	// typed values are retried as they are
+	type event struct{ Name string }
+	events := NewTyped[event](mb, nil)
+	_, err = mb.Publish("filler", "a")
+	assert.NoError(t, err, "failed to publish")
+	_, err = events.Publish(event{Name: "spooled"}, "a")
+	assert.ErrorIs(t, err, queue.ErrQueueFull)
+	msg, _ = mb.Consume("a")
+	assert.Equal(t, "filler", msg)
+	e, err := events.Consume("a")
+	assert.NoError(t, err, "failed to consume retried typed value")
+	assert.Equal(t, event{Name: "spooled"}, e)
+
+	_, err = mb.Unsent("nonexistent")
+	assert.Error(t, err, "listed unsent messages of a non existent queue")
+	_, err = mb.Publish("m8", "a")
+	assert.NoError(t, err, "failed to publish")
+	_, err = mb.Publish("m9", "a")
+	assert.Error(t, err)
+	assert.NoError(t, mb.RemoveQueue("a"), "failed to remove queue")
+	assert.Zero(t, mb.unsentLen.Load(), "unsent messages of a removed queue are still counted")
+}
+
+func TestBrokerExchanges(t *testing.T) {
+
+	setDefaults()
+
+	mb := New(testLogger())
+	for _, queueName := range []string{"created", "eu", "logs"} {
+		assert.NoError(t, mb.AddDefaultQueue(queueName), "failed to add queue")
+	}
+	bindings := []config.Binding{
+		{Queue: "created", Pattern: "orders.*.created"},
+		{Queue: "eu", Pattern: "orders.eu.#"},
+		{Queue: "logs", Pattern: "logs.#"},
+	}
+	err := mb.AddExchange("events", config.ExchangeConfig{Bindings: []config.Binding{{Queue: "nonexistent", Pattern: "a"}}})
+	assert.Error(t, err, "bound a non existent queue")
+	err = mb.AddExchange("events", config.ExchangeConfig{Bindings: []config.Binding{{Queue: "created", Pattern: "orders..created"}}})
+	assert.Error(t, err, "bound with an invalid pattern")
+	assert.NoError(t, mb.AddExchange("events", config.ExchangeConfig{Bindings: bindings}), "failed to add exchange")
+	assert.Error(t, mb.AddExchange("events", config.ExchangeConfig{}), "added a duplicate exchange")
+	assert.Equal(t, bindings, config.GetRunningConfig().Broker.Exchanges["events"].Bindings, "exchange missing from the running config")
+
+	for key, expected := range map[string][]string{
+		"orders.eu.created":      {"created", "eu"},
+		"orders.us.created":      {"created"},
+		"orders.eu":              {"eu"},
+		"orders.eu.created.late": {"eu"},
+		"orders.created":         {},
+		"logs":                   {"logs"},
+		"logs.app.error":         {"logs"},
+		"logsx":                  {},
+	} {
+		queueNames, err := mb.Route("events", key)
+		assert.NoError(t, err, "failed to route")
+		assert.Equal(t, expected, queueNames, "mismatched queues for routing key `%s`", key)
+	}
+
+	ids, err := mb.PublishToExchange("events", "orders.eu.created", []byte("order"), queue.MessageOptions{})
+	assert.NoError(t, err, "failed to publish to exchange")
+	assert.Len(t, ids, 2)
+	for _, queueName := range []string{"created", "eu"} {
+		msg, err := mb.Consume(queueName)
+		assert.NoError(t, err, "failed to consume")
+		assert.Equal(t, "order", msg)
+	}
+	ids, err = mb.PublishToExchange("events", "unrouted", []byte("lost"), queue.MessageOptions{})
+	assert.NoError(t, err, "a message which matches no binding is not an error")
+	assert.Empty(t, ids)
+	_, err = mb.PublishToExchange("nonexistent", "logs", []byte("lost"), queue.MessageOptions{})
+	assert.Error(t, err, "published to a non existent exchange")
+
+	assert.NoError(t, mb.Bind("events", "logs", "orders.#"), "failed to bind")
+	assert.NoError(t, mb.Bind("events", "logs", "orders.#"), "binding twice should do nothing")
+	queueNames, _ := mb.Route("events", "orders.us.created")
+	assert.Equal(t, []string{"created", "logs"}, queueNames)
+	assert.Len(t, config.GetRunningConfig().Broker.Exchanges["events"].Bindings, 4, "binding not saved")
+	assert.Error(t, mb.Bind("events", "nonexistent", "a"), "bound a non existent queue")
+	assert.Error(t, mb.Bind("events", "logs", "a.b#"), "bound with an invalid pattern")
+	assert.Error(t, mb.Bind("nonexistent", "logs", "a"), "bound to a non existent exchange")
+	assert.NoError(t, mb.Unbind("events", "logs", "orders.#"), "failed to unbind")
+	assert.Error(t, mb.Unbind("events", "logs", "orders.#"), "unbound twice")
+
+	// removing a queue drops its bindings
+	assert.NoError(t, mb.RemoveQueue("eu"), "failed to remove queue")
+	cfg, err := mb.Exchange("events")
+	assert.NoError(t, err, "failed to get exchange")
+	assert.Equal(t, []config.Binding{bindings[0], bindings[2]}, cfg.Bindings, "bindings of a removed queue remained")
+	assert.Equal(t, cfg, config.GetRunningConfig().Broker.Exchanges["events"], "running config out of sync")
+
+	err = mb.AddExchanges(config.ExchangeMap{
+		"a": {Bindings: []config.Binding{{Queue: "logs", Pattern: "#"}}},
+		"b": {Bindings: []config.Binding{{Queue: "eu", Pattern: "#"}}},
+	})
+	assert.Error(t, err, "added an exchange bound to a removed queue")
+	assert.True(t, mb.ExchangeExists("a"), "valid exchange was not added")
+	assert.Len(t, mb.Exchanges(), 2)
+
+	assert.NoError(t, mb.RemoveExchange("events"), "failed to remove exchange")
+	assert.Error(t, mb.RemoveExchange("events"), "removed an exchange twice")
+	assert.False(t, mb.ExchangeExists("events"))
+	assert.NotContains(t, config.GetRunningConfig().Broker.Exchanges, "events", "exchange remained in the running config")
+}
@@ -0,0 +1,308 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"yambol/config"
+	"yambol/pkg/queue"
+)
+
+// exchange routes messages into the queues bound to it. Its bindings are guarded by the broker's lock.
+type exchange struct {
+	bindings []binding
+}
+
+type binding struct {
+	config.Binding
+	words []string
+}
+
+func newBinding(b config.Binding) binding {
+	return binding{Binding: b, words: strings.Split(b.Pattern, ".")}
+}
+
+func newExchange(cfg config.ExchangeConfig) *exchange {
+	ex := &exchange{bindings: make([]binding, len(cfg.Bindings))}
+	for i, b := range cfg.Bindings {
+		ex.bindings[i] = newBinding(b)
+	}
+	return ex
+}
+
+func (ex *exchange) config() config.ExchangeConfig {
+	cfg := config.ExchangeConfig{Bindings: make([]config.Binding, len(ex.bindings))}
+	for i, b := range ex.bindings {
+		cfg.Bindings[i] = b.Binding
+	}
+	return cfg
+}
+
+func (ex *exchange) index(b config.Binding) int {
+	for i := range ex.bindings {
+		if ex.bindings[i].Binding == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchPattern tells whether the words of a routing key match the words of a binding pattern,
+// where `*` matches exactly one word and `#` matches zero or more words
+func matchPattern(pattern, key []string) bool {
+	for ; len(pattern) > 0; pattern, key = pattern[1:], key[1:] {
+		switch pattern[0] {
+		case "#":
+			for i := 0; i <= len(key); i++ {
+				if matchPattern(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+	}
+	return len(key) == 0
+}
+
+// AddExchange creates an exchange. Every queue it is bound to must exist.
+func (mb *MessageBroker) AddExchange(exchangeName string, cfg config.ExchangeConfig) error {
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+	return mb.addExchange(exchangeName, cfg)
+}
+
+// addExchange needs the management lock
+func (mb *MessageBroker) addExchange(exchangeName string, cfg config.ExchangeConfig) error {
+	mb.logger.Info("Trying to add exchange `%s`: %s", exchangeName, cfg)
+
+	if mb.ExchangeExists(exchangeName) {
+		mb.logger.Error("failed to add exchange `%s` as it already exists", exchangeName)
+		return fmt.Errorf("exchange %s already exists", exchangeName)
+	}
+	if err := cfg.Validate(); err != nil {
+		mb.logger.Error("failed to add exchange `%s`: %v", exchangeName, err)
+		return err
+	}
+	for _, b := range cfg.Bindings {
+		if !mb.QueueExists(b.Queue) {
+			err := fmt.Errorf("queue '%s' not found", b.Queue)
+			mb.logger.Error("failed to add exchange `%s`: %v", exchangeName, err)
+			return err
+		}
+	}
+	ex := newExchange(cfg)
+	mb.mx.Lock()
+	mb.exchanges[exchangeName] = ex
+	mb.mx.Unlock()
+	config.CreateExchange(exchangeName, ex.config())
+	mb.logger.Info("Exchange `%s` added", exchangeName)
+	return nil
+}
+
+// AddExchanges adds several exchanges at once, once the queues they are bound to have been added
+func (mb *MessageBroker) AddExchanges(exchanges config.ExchangeMap) error {
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+
+	names := make([]string, 0, len(exchanges))
+	for exchangeName := range exchanges {
+		names = append(names, exchangeName)
+	}
+	sort.Strings(names)
+
+	errors := make(map[string]error)
+	for _, exchangeName := range names {
+		if err := mb.addExchange(exchangeName, exchanges[exchangeName]); err != nil {
+			errors[exchangeName] = err
+		}
+	}
+	return mb.formatMultipleErrors("one or more exchanges could not be added:", errors)
+}
+
+func (mb *MessageBroker) RemoveExchange(exchangeName string) error {
+	mb.logger.Info("Trying to remove exchange `%s`", exchangeName)
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+
+	mb.mx.Lock()
+	if _, ok := mb.exchanges[exchangeName]; !ok {
+		mb.mx.Unlock()
+		err := fmt.Errorf("exchange '%s' not found", exchangeName)
+		mb.logger.Error(err.Error())
+		return err
+	}
+	delete(mb.exchanges, exchangeName)
+	mb.mx.Unlock()
+	config.DeleteExchange(exchangeName)
+	mb.logger.Info("Exchange `%s` removed", exchangeName)
+	return nil
+}
+
+func (mb *MessageBroker) ExchangeExists(exchangeName string) bool {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	_, ok := mb.exchanges[exchangeName]
+	return ok
+}
+
+// Exchange returns the bindings of an exchange
+func (mb *MessageBroker) Exchange(exchangeName string) (config.ExchangeConfig, error) {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	ex, ok := mb.exchanges[exchangeName]
+	if !ok {
+		return config.ExchangeConfig{}, fmt.Errorf("exchange '%s' not found", exchangeName)
+	}
+	return ex.config(), nil
+}
+
+// Exchanges returns the bindings of every exchange
+func (mb *MessageBroker) Exchanges() config.ExchangeMap {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	exchanges := make(config.ExchangeMap, len(mb.exchanges))
+	for exchangeName, ex := range mb.exchanges {
+		exchanges[exchangeName] = ex.config()
+	}
+	return exchanges
+}
+
+// Bind routes the messages of an exchange whose routing key matches pattern into a queue.
+// Binding a queue with a pattern it is already bound with does nothing.
+func (mb *MessageBroker) Bind(exchangeName, queueName, pattern string) error {
+	b := config.Binding{Queue: queueName, Pattern: pattern}
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	return mb.updateBindings(exchangeName, func(ex *exchange) error {
+		if !mb.QueueExists(queueName) {
+			return fmt.Errorf("queue '%s' not found", queueName)
+		}
+		if ex.index(b) < 0 {
+			mb.mx.Lock()
+			ex.bindings = append(ex.bindings, newBinding(b))
+			mb.mx.Unlock()
+		}
+		return nil
+	})
+}
+
+// Unbind undoes Bind
+func (mb *MessageBroker) Unbind(exchangeName, queueName, pattern string) error {
+	b := config.Binding{Queue: queueName, Pattern: pattern}
+	return mb.updateBindings(exchangeName, func(ex *exchange) error {
+		i := ex.index(b)
+		if i < 0 {
+			return fmt.Errorf("queue '%s' is not bound to exchange '%s' with pattern '%s'", queueName, exchangeName, pattern)
+		}
+		mb.mx.Lock()
+		ex.bindings = append(ex.bindings[:i:i], ex.bindings[i+1:]...)
+		mb.mx.Unlock()
+		return nil
+	})
+}
+
+// updateBindings applies a change to the bindings of an exchange and saves them to the config. The change
+// runs with the management lock held, which keeps the bindings from changing under it, and needs the broker's
+// lock only to write them.
+func (mb *MessageBroker) updateBindings(exchangeName string, update func(*exchange) error) error {
+	mb.mgmt.Lock()
+	defer mb.mgmt.Unlock()
+
+	mb.mx.RLock()
+	ex, ok := mb.exchanges[exchangeName]
+	mb.mx.RUnlock()
+	if !ok {
+		err := fmt.Errorf("exchange '%s' not found", exchangeName)
+		mb.logger.Error(err.Error())
+		return err
+	}
+	if err := update(ex); err != nil {
+		mb.logger.Error("failed to update the bindings of exchange `%s`: %v", exchangeName, err)
+		return err
+	}
+	mb.mx.RLock()
+	cfg := ex.config()
+	mb.mx.RUnlock()
+	config.UpdateExchange(exchangeName, cfg)
+	mb.logger.Info("Exchange `%s` bindings updated", exchangeName)
+	return nil
+}
+
+// unbindQueue drops every binding to a queue which is being removed. Needs the management lock.
+func (mb *MessageBroker) unbindQueue(queueName string) {
+	updated := make(map[string]config.ExchangeConfig)
+	mb.mx.Lock()
+	for exchangeName, ex := range mb.exchanges {
+		kept := ex.bindings[:0]
+		for _, b := range ex.bindings {
+			if b.Queue != queueName {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) != len(ex.bindings) {
+			for i := len(kept); i < len(ex.bindings); i++ {
+				ex.bindings[i] = binding{}
+			}
+			ex.bindings = kept
+			updated[exchangeName] = ex.config()
+		}
+	}
+	mb.mx.Unlock()
+	for exchangeName, cfg := range updated {
+		config.UpdateExchange(exchangeName, cfg)
+	}
+}
+
+// Route returns the queues an exchange routes a message with the given routing key into, sorted by name.
+// A queue bound with several matching patterns is only listed once.
+func (mb *MessageBroker) Route(exchangeName, routingKey string) ([]string, error) {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	ex, ok := mb.exchanges[exchangeName]
+	if !ok {
+		return nil, fmt.Errorf("exchange '%s' not found", exchangeName)
+	}
+	key := strings.Split(routingKey, ".")
+	matched := make(map[string]bool)
+	queueNames := make([]string, 0)
+	for _, b := range ex.bindings {
+		if !matched[b.Queue] && matchPattern(b.words, key) {
+			matched[b.Queue] = true
+			queueNames = append(queueNames, b.Queue)
+		}
+	}
+	sort.Strings(queueNames)
+	return queueNames, nil
+}
+
+// PublishToExchange publishes a message into every queue an exchange routes its routing key to.
+// A message which matches no binding is dropped, and gets no IDs.
+func (mb *MessageBroker) PublishToExchange(exchangeName, routingKey string, message []byte, opts queue.MessageOptions) (MessageIDs, error) {
+	return mb.PublishToExchangeContext(context.Background(), exchangeName, routingKey, message, opts)
+}
+
+// PublishToExchangeContext is like PublishToExchange, but stops waiting for room in queues with the block
+// overflow policy once ctx is done
+func (mb *MessageBroker) PublishToExchangeContext(ctx context.Context, exchangeName, routingKey string, message []byte, opts queue.MessageOptions) (MessageIDs, error) {
+	queueNames, err := mb.Route(exchangeName, routingKey)
+	if err != nil {
+		mb.logger.Error(err.Error())
+		return nil, err
+	}
+	if len(queueNames) == 0 {
+		mb.logger.Debug("Message with routing key `%s` matches no binding of exchange `%s`", routingKey, exchangeName)
+		return MessageIDs{}, nil
+	}
+	return mb.PublishBytesContext(ctx, message, opts, queueNames...)
+}
@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"fmt"
+	"sync"
+
+	"yambol/pkg/queue"
+)
+
+// QueueState is where a queue is in its lifecycle. A queue is creating while AddQueue sets it up,
+// active while it takes traffic, draining while RemoveQueue waits for the operations in flight on it
+// to finish, and deleting while it is torn down. Only active queues accept operations.
+type QueueState string
+
+const (
+	QueueCreating QueueState = "creating"
+	QueueActive   QueueState = "active"
+	QueueDraining QueueState = "draining"
+	QueueDeleting QueueState = "deleting"
+)
+
+var ErrQueueUnavailable = fmt.Errorf("queue is not active")
+
+// managedQueue is a broker's queue along with its lifecycle state, which is guarded by the broker's lock
+type managedQueue struct {
+	q     *queue.Queue // nil while creating
+	state QueueState
+	ops   sync.WaitGroup // operations in flight on the queue
+}
+
+// acquire looks up an active queue and counts an operation on it as in flight until release is called,
+// so RemoveQueue can wait for it
+func (mb *MessageBroker) acquire(queueName string) (q *queue.Queue, release func(), err error) {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	mq, ok := mb.queues[queueName]
+	if !ok {
+		return nil, nil, fmt.Errorf("queue '%s' not found", queueName)
+	}
+	if mq.state != QueueActive {
+		return nil, nil, fmt.Errorf("%w: queue '%s' is %s", ErrQueueUnavailable, queueName, mq.state)
+	}
+	mq.ops.Add(1)
+	return mq.q, mq.ops.Done, nil
+}
+
+func (mb *MessageBroker) setState(mq *managedQueue, state QueueState) {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	mq.state = state
+}
+
+// QueueState returns where a queue is in its lifecycle
+func (mb *MessageBroker) QueueState(queueName string) (QueueState, error) {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	mq, ok := mb.queues[queueName]
+	if !ok {
+		return "", fmt.Errorf("queue '%s' not found", queueName)
+	}
+	return mq.state, nil
+}
@@ -2,6 +2,7 @@ package broker
 
 import (
 	"yambol/config"
+	"yambol/pkg/util"
 )
 
 var (
@@ -9,6 +10,8 @@ var (
 	defaultMaxLen       = int64(1024 * 1024 * 1024)
 	defaultMaxSizeBytes = int64(1024 * 1024 * 1024) // 1GB
 	defaultTTLSeconds   = int64(0)
+	reapIntervalSeconds = int64(5)
+	maxUnsent           = int64(1024)
 )
 
 func setLTE0(value, default_ int64, target *int64) int64 {
@@ -39,6 +42,24 @@ func SetDefaultTTL(value int64) {
 	config.SetDefaultTTL(value)
 }
 
+// SetReapInterval sets how often, in seconds, brokers sweep their queues for expired messages
+func SetReapInterval(value int64) {
+	config.SetReapInterval(setLTE0(value, 5, &reapIntervalSeconds))
+}
+
+// SetMaxUnsent sets how many messages which failed to publish a broker keeps per queue for retrying
+func SetMaxUnsent(value int64) {
+	config.SetMaxUnsent(setLTE0(value, 1024, &maxUnsent))
+}
+
+func GetMaxUnsent() int64 {
+	return maxUnsent
+}
+
+func GetReapInterval() int64 {
+	return reapIntervalSeconds
+}
+
 func GetDefaultMinLen() int64 {
 	return defaultMinLen
 }
@@ -76,9 +97,9 @@ func determineMaxSizeBytes(value int64) int64 {
 	return defaultMaxSizeBytes
 }
 
-func determineTTL(value int64) int64 {
+func determineTTL(value util.Duration) util.Duration {
 	if value > 0 {
 		return value
 	}
-	return defaultTTLSeconds
+	return util.Duration(util.Seconds(defaultTTLSeconds))
 }
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"time"
+
+	"yambol/pkg/queue"
+	"yambol/pkg/util"
+)
+
+// reaper periodically sweeps a broker's queues for expired messages
+type reaper struct {
+	quit chan struct{}
+	done chan struct{}
+}
+
+func (r *reaper) stop() {
+	close(r.quit)
+	<-r.done
+}
+
+// StartReaper sweeps every queue for expired messages on the given interval, and retries their unsent messages,
+// until the broker is closed.
+// A non-positive interval uses the configured reap interval. Does nothing if the reaper is already running.
+func (mb *MessageBroker) StartReaper(interval time.Duration) {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	if mb.reaper != nil {
+		return
+	}
+	if interval <= 0 {
+		interval = util.Seconds(GetReapInterval())
+	}
+	r := &reaper{
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	mb.reaper = r
+	mb.logger.Info("Reaping expired messages every %s", interval)
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.quit:
+				mb.logger.Info("Reaper stopped")
+				return
+			case <-ticker.C:
+				mb.Reap()
+				mb.retryAllUnsent()
+			}
+		}
+	}()
+}
+
+// Reap sweeps every queue for expired messages once. Returns how many were removed from each queue
+// which had any.
+func (mb *MessageBroker) Reap() map[string]int {
+	reaped := make(map[string]int)
+	for queueName, q := range mb.snapshot() {
+		if n := q.Reap(); n > 0 {
+			reaped[queueName] = n
+			mb.logger.Debug("Reaped %d expired messages from queue `%s`", n, queueName)
+		}
+	}
+	return reaped
+}
+
+// snapshot copies the queue map, so it can be walked while queues are added or removed
+func (mb *MessageBroker) snapshot() map[string]*queue.Queue {
+	mb.mx.RLock()
+	defer mb.mx.RUnlock()
+	queues := make(map[string]*queue.Queue, len(mb.queues))
+	for queueName, mq := range mb.queues {
+		if mq.q != nil {
+			queues[queueName] = mq.q
+		}
+	}
+	return queues
+}
@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"yambol/pkg/queue"
+)
+
+// Typed publishes and consumes values of type T on a broker's queues without serializing them, for services
+// which embed the broker in-process. It goes through the broker's own API, so queues behave as usual.
+// See queue.Typed for how values are stored and sized.
+type Typed[T any] struct {
+	mb   *MessageBroker
+	size func(T) int64
+}
+
+// NewTyped wraps a broker. A nil size counts values which are neither strings nor byte slices as zero bytes.
+func NewTyped[T any](mb *MessageBroker, size func(T) int64) *Typed[T] {
+	return &Typed[T]{mb: mb, size: size}
+}
+
+// Broker returns the underlying broker, for everything Typed does not cover
+func (t *Typed[T]) Broker() *MessageBroker {
+	return t.mb
+}
+
+func (t *Typed[T]) Publish(value T, queueNames ...string) (MessageIDs, error) {
+	return t.PublishWithOptions(value, queue.MessageOptions{}, queueNames...)
+}
+
+func (t *Typed[T]) PublishWithTTL(value T, ttl *time.Duration, queueNames ...string) (MessageIDs, error) {
+	return t.PublishWithOptions(value, queue.MessageOptions{TTL: ttl}, queueNames...)
+}
+
+func (t *Typed[T]) PublishWithOptions(value T, opts queue.MessageOptions, queueNames ...string) (MessageIDs, error) {
+	return t.PublishContext(context.Background(), value, opts, queueNames...)
+}
+
+// PublishContext is like PublishWithOptions, but stops waiting for room in queues with the block overflow policy once ctx is done
+func (t *Typed[T]) PublishContext(ctx context.Context, value T, opts queue.MessageOptions, queueNames ...string) (MessageIDs, error) {
+	entry := queue.TypedEntry(value, opts, t.size)
+	return t.mb.PublishBytesContext(ctx, entry.Value, entry.Options, queueNames...)
+}
+
+func (t *Typed[T]) Broadcast(value T) (MessageIDs, error) {
+	return t.Publish(value, t.mb.Queues()...)
+}
+
+// PublishBatch publishes several values to one queue at once. Either all of them are published, or none are.
+func (t *Typed[T]) PublishBatch(queueName string, values ...T) ([]int, error) {
+	entries := make([]queue.BatchEntry, len(values))
+	for i, value := range values {
+		entries[i] = queue.TypedEntry(value, queue.MessageOptions{}, t.size)
+	}
+	return t.mb.PublishBatch(queueName, entries)
+}
+
+// Consume takes the next value from the queue. A message which does not hold a T is still consumed,
+// and reported with queue.ErrUnexpectedType.
+func (t *Typed[T]) Consume(queueName string) (T, error) {
+	msg, err := t.mb.ConsumeMessage(queueName)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return queue.ValueOf[T](msg)
+}
+
+// ConsumeContext is like Consume, but waits for a message until ctx is done
+func (t *Typed[T]) ConsumeContext(ctx context.Context, queueName string) (T, error) {
+	msg, err := t.mb.ConsumeMessageContext(ctx, queueName)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return queue.ValueOf[T](msg)
+}
+
+// ConsumeLease takes the next value under a lease, which must then be acked or nacked with the lease's receipt
+func (t *Typed[T]) ConsumeLease(queueName string, visibility *time.Duration) (T, queue.Lease, error) {
+	l, err := t.mb.ConsumeLease(queueName, visibility)
+	if err != nil {
+		var zero T
+		return zero, l, err
+	}
+	value, err := queue.ValueOf[T](l.Message)
+	return value, l, err
+}
@@ -0,0 +1,213 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"yambol/pkg/queue"
+)
+
+// UnsentMessage is a message which could not be published into a queue because the queue was full,
+// and waits in the queue's unsent spool to be retried
+type UnsentMessage struct {
+	// ID tells the messages of a spool apart, and is not the ID the message gets once it is published
+	ID       int
+	Value    []byte
+	Options  queue.MessageOptions
+	Err      error
+	FailedAt time.Time
+	// Attempts counts the publishes which failed, including the original one
+	Attempts int
+}
+
+// UnsentSpool lists the unsent messages of a queue, oldest first
+type UnsentSpool struct {
+	Messages []UnsentMessage
+	// Dropped counts the messages which were dropped from the spool to make room, since the queue was created
+	Dropped int64
+}
+
+// spool holds the unsent messages of a queue. Once it holds the max unsent messages, the oldest ones are
+// dropped to make room for new ones. Its messages are guarded by the broker's unsent lock, while retrying
+// guards against retrying the same spool twice at once, which would publish messages out of order.
+type spool struct {
+	messages []UnsentMessage
+	nextID   int
+	dropped  int64
+	retrying sync.Mutex
+}
+
+// retryable tells whether a failed publish is worth retrying once the queue has room. Messages rejected
+// for what they are would fail again, and a queue paused for publishing turns publishers away on purpose.
+func retryable(err error) bool {
+	return errors.Is(err, queue.ErrQueueFull) || errors.Is(err, queue.ErrQueueTooLarge)
+}
+
+func (sp *spool) index(id int) int {
+	for i := range sp.messages {
+		if sp.messages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (sp *spool) remove(i int) {
+	copy(sp.messages[i:], sp.messages[i+1:])
+	sp.messages[len(sp.messages)-1] = UnsentMessage{} // release the value for the GC
+	sp.messages = sp.messages[:len(sp.messages)-1]
+}
+
+// spoolUnsent keeps a message which failed to be published into a queue, if the failure may go away
+func (mb *MessageBroker) spoolUnsent(queueName string, value []byte, opts queue.MessageOptions, err error) {
+	if !retryable(err) {
+		return
+	}
+	mb.unsentMx.Lock()
+	defer mb.unsentMx.Unlock()
+	sp, ok := mb.unsent[queueName]
+	if !ok {
+		return
+	}
+	for int64(len(sp.messages)) >= GetMaxUnsent() {
+		sp.remove(0)
+		sp.dropped++
+		mb.unsentLen.Add(-1)
+		mb.logger.Warn("Unsent spool of queue `%s` is full, dropped its oldest message", queueName)
+	}
+	sp.nextID++
+	sp.messages = append(sp.messages, UnsentMessage{
+		ID:       sp.nextID,
+		Value:    value,
+		Options:  opts,
+		Err:      err,
+		FailedAt: time.Now(),
+		Attempts: 1,
+	})
+	mb.unsentLen.Add(1)
+}
+
+func (mb *MessageBroker) spoolOf(queueName string) (*spool, error) {
+	mb.unsentMx.Lock()
+	defer mb.unsentMx.Unlock()
+	sp, ok := mb.unsent[queueName]
+	if !ok {
+		return nil, fmt.Errorf("queue '%s' not found", queueName)
+	}
+	return sp, nil
+}
+
+// Unsent lists the messages which failed to be published into a queue and wait to be retried
+func (mb *MessageBroker) Unsent(queueName string) (UnsentSpool, error) {
+	sp, err := mb.spoolOf(queueName)
+	if err != nil {
+		return UnsentSpool{}, err
+	}
+	mb.unsentMx.Lock()
+	defer mb.unsentMx.Unlock()
+	messages := make([]UnsentMessage, len(sp.messages))
+	copy(messages, sp.messages)
+	return UnsentSpool{Messages: messages, Dropped: sp.dropped}, nil
+}
+
+// RetryUnsent publishes the unsent messages of a queue, oldest first, until one of them fails again.
+// Returns how many were published. Unsent messages are also retried on their own whenever the broker
+// frees up room in the queue, and on every sweep of the reaper.
+func (mb *MessageBroker) RetryUnsent(queueName string) (int, error) {
+	q, release, err := mb.acquire(queueName)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return mb.retryUnsent(queueName, q, true), nil
+}
+
+// DiscardUnsent throws away the unsent messages of a queue. Returns how many were discarded.
+func (mb *MessageBroker) DiscardUnsent(queueName string) (int, error) {
+	sp, err := mb.spoolOf(queueName)
+	if err != nil {
+		return 0, err
+	}
+	sp.retrying.Lock()
+	defer sp.retrying.Unlock()
+	mb.unsentMx.Lock()
+	defer mb.unsentMx.Unlock()
+	discarded := len(sp.messages)
+	sp.messages = nil
+	mb.unsentLen.Add(int64(-discarded))
+	mb.logger.Info("Discarded %d unsent messages of queue `%s`", discarded, queueName)
+	return discarded, nil
+}
+
+// retryUnsent publishes the unsent messages of a queue, which the caller has acquired, without waiting for room.
+// A message which is turned away again only counted as rejected by the queue once, when it was spooled.
+// Unless wait is set, it gives up straight away if the spool is already being retried. This is cheap when
+// no queue has unsent messages, so it can be called whenever room may have been freed up.
+func (mb *MessageBroker) retryUnsent(queueName string, q *queue.Queue, wait bool) int {
+	if mb.unsentLen.Load() == 0 {
+		return 0
+	}
+	sp, err := mb.spoolOf(queueName)
+	if err != nil {
+		return 0
+	}
+	if wait {
+		sp.retrying.Lock()
+	} else if !sp.retrying.TryLock() {
+		return 0
+	}
+	defer sp.retrying.Unlock()
+
+	retried := 0
+	for {
+		mb.unsentMx.Lock()
+		if len(sp.messages) == 0 {
+			mb.unsentMx.Unlock()
+			break
+		}
+		msg := sp.messages[0]
+		mb.unsentMx.Unlock()
+
+		_, pushErr := q.RetryPushBytes(msg.Value, msg.Options)
+
+		mb.unsentMx.Lock()
+		// new messages may have pushed the one being retried out of the spool meanwhile
+		i := sp.index(msg.ID)
+		if pushErr != nil {
+			if i >= 0 {
+				sp.messages[i].Err = pushErr
+				sp.messages[i].Attempts++
+			}
+			mb.unsentMx.Unlock()
+			break
+		}
+		if i >= 0 {
+			sp.remove(i)
+			mb.unsentLen.Add(-1)
+		}
+		mb.unsentMx.Unlock()
+		retried++
+	}
+	if retried > 0 {
+		mb.logger.Info("Published %d unsent messages into queue `%s`", retried, queueName)
+	}
+	return retried
+}
+
+// retryAllUnsent retries the unsent messages of every queue, for room which was freed up by something
+// the broker does not see, such as messages which expired
+func (mb *MessageBroker) retryAllUnsent() {
+	if mb.unsentLen.Load() == 0 {
+		return
+	}
+	for _, queueName := range mb.Queues() {
+		q, release, err := mb.acquire(queueName)
+		if err != nil {
+			continue
+		}
+		mb.retryUnsent(queueName, q, false)
+		release()
+	}
+}
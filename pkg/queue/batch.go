@@ -0,0 +1,131 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// BatchEntry is one message of a batch push
+type BatchEntry struct {
+	Value   []byte
+	Options MessageOptions
+}
+
+// PushBatchBytes pushes several messages with their own options at once. Either all of them are pushed,
+// or none are. Entries whose deduplication key was seen recently, including earlier in the same batch,
+// are not pushed again and get the original message's ID instead. The overflow policy applies to the batch
+// as a whole, so under drop-newest either all of the new messages are discarded, with -1 as their IDs, or none are.
+func (q *Queue) PushBatchBytes(entries []BatchEntry) ([]int, error) {
+	return q.PushBatchBytesContext(context.Background(), entries)
+}
+
+// PushBatchBytesContext is like PushBatchBytes, but under the block overflow policy it gives up waiting for room once ctx is done
+func (q *Queue) PushBatchBytesContext(ctx context.Context, entries []BatchEntry) ([]int, error) {
+	for _, entry := range entries {
+		if err := entry.Options.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	var ids []int
+	err := q.awaitRoom(ctx, func() error {
+		var err error
+		ids, err = q.pushBatch(entries)
+		return err
+	})
+	if err != nil && !errors.Is(err, errDropped) {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (q *Queue) pushBatch(entries []BatchEntry) ([]int, error) {
+	if q.publishPaused {
+		return nil, ErrPublishPaused
+	}
+	now := time.Now()
+	ids := make([]int, len(entries))
+	fresh := make([]bool, len(entries))
+	sameAs := make(map[int]int)       // index of a duplicate entry -> index of the earlier entry with its key
+	batchKeys := make(map[string]int) // dedup key -> index of the entry which pushes it
+	n, size := 0, int64(0)
+	for i, entry := range entries {
+		if key := entry.Options.DedupKey; key != "" {
+			if id, ok := q.dedup.lookup(key, now); ok {
+				ids[i] = id
+				continue
+			}
+			if first, ok := batchKeys[key]; ok {
+				sameAs[i] = first
+				continue
+			}
+			batchKeys[key] = i
+		}
+		fresh[i] = true
+		n++
+		size += entry.Options.payloadSize(entry.Value)
+	}
+	if err := q.makeRoom(n, size); err != nil {
+		if !errors.Is(err, errDropped) {
+			return nil, err
+		}
+		for i := range entries {
+			if fresh[i] {
+				ids[i] = -1
+			} else if first, ok := sameAs[i]; ok {
+				ids[i] = ids[first]
+			}
+		}
+		return ids, err
+	}
+
+	q.promote()
+	for i, entry := range entries {
+		if !fresh[i] {
+			if first, ok := sameAs[i]; ok {
+				ids[i] = ids[first]
+			}
+			q.stats.DedupHit()
+			continue
+		}
+		item_ := q.factory.newItem(entry.Value, entry.Options)
+		q.append(item_)
+		if entry.Options.DedupKey != "" {
+			q.dedup.remember(entry.Options.DedupKey, item_.uid, now)
+		}
+		ids[i] = item_.uid
+	}
+	return ids, nil
+}
+
+// PopN dequeues up to n messages at once, in the order Pop would return them.
+// Fails with ErrQueueEmpty only if there was not a single message.
+func (q *Queue) PopN(n int) ([]Message, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.popN(n)
+}
+
+// PopNContext is like PopN, but blocks until at least one message is available or ctx is done
+func (q *Queue) PopNContext(ctx context.Context, n int) (messages []Message, err error) {
+	err = q.await(ctx, func() error {
+		messages, err = q.popN(n)
+		return err
+	})
+	return
+}
+
+func (q *Queue) popN(n int) ([]Message, error) {
+	messages := make([]Message, 0, n)
+	for len(messages) < n {
+		msg, err := q.popMessage()
+		if err != nil {
+			break
+		}
+		messages = append(messages, msg)
+	}
+	if len(messages) == 0 {
+		return nil, ErrQueueEmpty
+	}
+	return messages, nil
+}
@@ -0,0 +1,81 @@
+package queue
+
+import "time"
+
+// Where a message currently is, as reported by MessageInfo.State
+const (
+	MessageVisible   = "visible"
+	MessageScheduled = "scheduled"
+	MessageInFlight  = "in_flight"
+)
+
+// MessageInfo describes a message waiting in a queue, as seen without consuming it
+type MessageInfo struct {
+	ID          int
+	State       string
+	Value       []byte
+	ContentType string
+	Headers     map[string]string
+	Priority    int
+	Group       string
+	TTL         time.Duration
+	EnqueuedAt  time.Time
+	Age         time.Duration
+	Deliveries  int64
+	DeadLetter  *DeadLetter
+}
+
+func (i *item) info(now time.Time, state string) MessageInfo {
+	return MessageInfo{
+		ID:          i.uid,
+		State:       state,
+		Value:       i.value,
+		ContentType: i.contentType,
+		Headers:     copyHeaders(i.headers),
+		Priority:    i.priority,
+		Group:       i.group,
+		TTL:         i.ttl,
+		EnqueuedAt:  i.enqueued,
+		Age:         now.Sub(i.enqueued),
+		Deliveries:  i.deliveries,
+		DeadLetter:  i.deadLetter,
+	}
+}
+
+// Browse lists up to limit of the next messages to be consumed, in the order they would be consumed.
+// Nothing is dequeued and no stats are recorded. Expired messages which are yet to be dropped are skipped,
+// and messages whose lease has run out are made visible again first, so they are listed.
+func (q *Queue) Browse(limit int) []MessageInfo {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.expireLeases()
+	q.promote()
+	return q.browse(0, limit)
+}
+
+// BrowseAfter is like Browse, but starts after the message with the given ID, which is how to page through
+// a queue. Fails with ErrCursorNotFound if that message is no longer visible in the queue.
+func (q *Queue) BrowseAfter(after int, limit int) ([]MessageInfo, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.expireLeases()
+	q.promote()
+	i := q.indexOf(after)
+	if i < 0 {
+		return nil, ErrCursorNotFound
+	}
+	return q.browse(i+1, limit), nil
+}
+
+func (q *Queue) browse(from, limit int) []MessageInfo {
+	now := time.Now()
+	infos := make([]MessageInfo, 0)
+	for i := from; i < q.len() && len(infos) < limit; i++ {
+		item_ := q.items.at(i)
+		if item_.Expired() {
+			continue
+		}
+		infos = append(infos, item_.info(now, MessageVisible))
+	}
+	return infos
+}
@@ -0,0 +1,73 @@
+package queue
+
+import "time"
+
+const (
+	DeadLetterExpired       = "expired"
+	DeadLetterMaxDeliveries = "max_deliveries"
+	DeadLetterOverflow      = "overflow"
+)
+
+// DeadLetter describes why a message ended up in a dead-letter queue and where it came from.
+// A message moved along a chain of dead-letter queues keeps the details of its first move.
+type DeadLetter struct {
+	Reason     string    `json:"reason"`
+	Queue      string    `json:"queue"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+type deadLetterTarget struct {
+	origin string
+	queue  *Queue
+}
+
+// SetDeadLetterQueue routes expired messages, and messages which run out of deliveries, to dlq.
+// The origin is recorded on each dead-lettered message as its original queue. A nil dlq disables dead-lettering.
+// The caller must make sure dead-letter queues never form a cycle.
+func (q *Queue) SetDeadLetterQueue(origin string, dlq *Queue) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if dlq == nil {
+		q.deadLetters = nil
+		return
+	}
+	q.deadLetters = &deadLetterTarget{origin: origin, queue: dlq}
+}
+
+// discard removes an item which will never be delivered from this queue,
+// handing it to the dead-letter queue if there is one and it has room.
+func (q *Queue) discard(item_ item, reason string) {
+	q.release(item_)
+	if q.deadLetters != nil && q.deadLetters.queue.pushDeadLetter(item_, reason, q.deadLetters.origin) {
+		q.stats.DeadLetter(item_.TimeInQueue())
+		return
+	}
+	q.stats.Drop(item_.TimeInQueue())
+}
+
+func (q *Queue) pushDeadLetter(item_ item, reason, origin string) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.fits(1, item_.size()) != nil {
+		return false
+	}
+	dead := q.factory.newItem(item_.value, MessageOptions{
+		Priority:    item_.priority,
+		ContentType: item_.contentType,
+		Headers:     item_.headers,
+		Group:       item_.group,
+		object:      item_.object,
+		objectSize:  item_.objectSize,
+	})
+	dead.deadLetter = item_.deadLetter
+	if dead.deadLetter == nil {
+		dead.deadLetter = &DeadLetter{
+			Reason:     reason,
+			Queue:      origin,
+			EnqueuedAt: item_.enqueued,
+		}
+	}
+	q.append(dead)
+	return true
+}
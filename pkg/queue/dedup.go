@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultDedupWindow is used for queues which do not configure their own deduplication window
+const DefaultDedupWindow = time.Minute * 5
+
+// MaxDedupKeyLength is how long a deduplication key may be, in bytes
+const MaxDedupKeyLength = 256
+
+func dedupWindowOrDefault(window time.Duration) time.Duration {
+	if window <= 0 {
+		return DefaultDedupWindow
+	}
+	return window
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// dedup remembers which message each recent deduplication key was pushed as.
+// Every key is remembered for the same window, so keys expire in the order they were added.
+type dedup struct {
+	window  time.Duration
+	ids     map[string]int
+	entries []dedupEntry // oldest first
+}
+
+func newDedup(window time.Duration) dedup {
+	return dedup{
+		window: dedupWindowOrDefault(window),
+		ids:    make(map[string]int),
+	}
+}
+
+func (d *dedup) lookup(key string, now time.Time) (int, bool) {
+	d.prune(now)
+	id, ok := d.ids[key]
+	return id, ok
+}
+
+func (d *dedup) remember(key string, id int, now time.Time) {
+	d.ids[key] = id
+	d.entries = append(d.entries, dedupEntry{key: key, expires: now.Add(d.window)})
+}
+
+func (d *dedup) prune(now time.Time) {
+	n := 0
+	for n < len(d.entries) && !now.Before(d.entries[n].expires) {
+		delete(d.ids, d.entries[n].key)
+		n++
+	}
+	if n > 0 {
+		d.entries = d.entries[n:]
+	}
+}
+
+func validateDedupKey(key string) error {
+	if len(key) > MaxDedupKeyLength {
+		return fmt.Errorf("deduplication key is %d bytes long, at most %d are allowed", len(key), MaxDedupKeyLength)
+	}
+	return nil
+}
@@ -4,3 +4,13 @@ import "fmt"
 
 var ErrQueueFull = fmt.Errorf("queue is full")
 var ErrQueueEmpty = fmt.Errorf("queue is empty")
+var ErrQueueTooLarge = fmt.Errorf("queue is too large")
+var ErrLeaseNotFound = fmt.Errorf("lease not found or expired")
+var ErrCursorNotFound = fmt.Errorf("cursor message not found")
+var ErrInvalidHeaders = fmt.Errorf("invalid message headers")
+var ErrMessageNotFound = fmt.Errorf("message not found")
+var ErrConsumePaused = fmt.Errorf("queue is paused for consuming")
+var ErrPublishPaused = fmt.Errorf("queue is paused for publishing")
+var ErrInvalidPauseDirection = fmt.Errorf("invalid pause direction")
+var ErrLimitBelowContents = fmt.Errorf("new limit is below what the queue holds")
+var ErrUnexpectedType = fmt.Errorf("message does not hold a value of the expected type")
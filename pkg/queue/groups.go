@@ -0,0 +1,49 @@
+package queue
+
+import "fmt"
+
+// MaxGroupKeyLength is how long a message group key may be, in bytes
+const MaxGroupKeyLength = 256
+
+func validateGroupKey(key string) error {
+	if len(key) > MaxGroupKeyLength {
+		return fmt.Errorf("message group key is %d bytes long, at most %d are allowed", len(key), MaxGroupKeyLength)
+	}
+	return nil
+}
+
+// groupLocked tells whether a message of the group is in flight, in which case no other message
+// of the group may be handed out until it is acked, nacked or its lease expires
+func (q *Queue) groupLocked(group string) bool {
+	if group == "" {
+		return false
+	}
+	_, locked := q.groups[group]
+	return locked
+}
+
+// lockGroup records the leased item as its group's one message in flight
+func (q *Queue) lockGroup(item_ item, receipt string) {
+	if item_.group == "" {
+		return
+	}
+	q.groups[item_.group] = receipt
+	q.stats.SetActiveGroups(int64(len(q.groups)))
+}
+
+// unlockGroup frees the group of an item whose lease is gone, which may make the group's next message available
+func (q *Queue) unlockGroup(item_ item) {
+	if item_.group == "" {
+		return
+	}
+	delete(q.groups, item_.group)
+	q.stats.SetActiveGroups(int64(len(q.groups)))
+	q.notify()
+}
+
+// ActiveGroups returns how many message groups currently have a message in flight
+func (q *Queue) ActiveGroups() int {
+	q.mx.RLock()
+	defer q.mx.RUnlock()
+	return len(q.groups)
+}
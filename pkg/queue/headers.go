@@ -0,0 +1,57 @@
+package queue
+
+import "fmt"
+
+const (
+	// MaxHeaders is how many headers a single message may carry
+	MaxHeaders = 64
+	// MaxHeadersSize is how many bytes the keys and values of a message's headers may add up to
+	MaxHeadersSize = 8 * 1024
+)
+
+// Validate checks the options before anything is pushed
+func (o MessageOptions) Validate() error {
+	if err := validateHeaders(o.Headers); err != nil {
+		return err
+	}
+	if err := validateDedupKey(o.DedupKey); err != nil {
+		return err
+	}
+	return validateGroupKey(o.Group)
+}
+
+// validateHeaders checks message headers against the limits above
+func validateHeaders(headers map[string]string) error {
+	if len(headers) > MaxHeaders {
+		return fmt.Errorf("%w: %d headers, at most %d are allowed", ErrInvalidHeaders, len(headers), MaxHeaders)
+	}
+	for key := range headers {
+		if key == "" {
+			return fmt.Errorf("%w: header keys may not be empty", ErrInvalidHeaders)
+		}
+	}
+	if size := headersSize(headers); size > MaxHeadersSize {
+		return fmt.Errorf("%w: %d bytes of headers, at most %d are allowed", ErrInvalidHeaders, size, MaxHeadersSize)
+	}
+	return nil
+}
+
+func headersSize(headers map[string]string) int64 {
+	var size int64
+	for key, value := range headers {
+		size += int64(len(key) + len(value))
+	}
+	return size
+}
+
+// copyHeaders keeps the queue's copy of the headers safe from changes by the producer or consumers
+func copyHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(headers))
+	for key, value := range headers {
+		c[key] = value
+	}
+	return c
+}
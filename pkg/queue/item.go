@@ -1,22 +1,93 @@
 package queue
 
 import (
-	"math/rand"
-	"sync"
 	"sync/atomic"
 	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// MessageOptions tune how a single message is pushed. The zero value uses the queue's defaults.
+type MessageOptions struct {
+	// TTL overrides the queue's default TTL when set
+	TTL *time.Duration
+	// Priority only matters for priority queues, where higher priorities are popped first
+	Priority int
+	// Delay keeps the message invisible for a while after it is pushed
+	Delay time.Duration
+	// DeliverAt keeps the message invisible until the given time. It takes precedence over Delay.
+	DeliverAt time.Time
+	// ContentType is an optional MIME type which is handed back to consumers along with the message
+	ContentType string
+	// Headers are arbitrary key/value attributes which are handed back to consumers along with the message
+	Headers map[string]string
+	// DedupKey makes a push which repeats the key of a recent one a no-op, which returns the original message's ID
+	DedupKey string
+	// Group puts the message in a message group. Only one message of a group is in flight at a time,
+	// and the messages of a group are handed out in the order the queue would pop them.
+	Group string
+
+	// object is the value of a push through Typed, which is kept as it is instead of as bytes.
+	// It counts objectSize bytes against the queue's byte budget.
+	object     any
+	objectSize int64
+}
+
+// payloadSize is how many bytes a message with the given value counts against the queue's byte budget
+func (o MessageOptions) payloadSize(value []byte) int64 {
+	return int64(len(value)) + headersSize(o.Headers) + o.objectSize
+}
+
+// visibleAt resolves when a message pushed at now should become visible
+func (o MessageOptions) visibleAt(now time.Time) time.Time {
+	if !o.DeliverAt.IsZero() {
+		if o.DeliverAt.After(now) {
+			return o.DeliverAt
+		}
+		return now
+	}
+	if o.Delay > 0 {
+		return now.Add(o.Delay)
+	}
+	return now
+}
+
+// Message is a consumed item along with what the queue knows about it
+type Message struct {
+	Value       []byte
+	ContentType string
+	Headers     map[string]string
+	Priority    int
+	Group       string
+	DeadLetter  *DeadLetter
+	object      any
 }
 
 type item struct {
-	uid   int
-	value string
-	ts    time.Time
-	ttl   time.Duration
-	tiq   *time.Duration
+	uid         int
+	value       []byte
+	contentType string
+	headers     map[string]string
+	enqueued    time.Time // when the item was pushed
+	ts          time.Time // when the item became visible, which is what its TTL and time in queue count from
+	ttl         time.Duration
+	tiq         *time.Duration
+	priority    int
+	group       string
+	deliveries  int64
+	deadLetter  *DeadLetter
+	object      any
+	objectSize  int64
+}
+
+func (i *item) message() Message {
+	return Message{
+		Value:       i.value,
+		ContentType: i.contentType,
+		Headers:     copyHeaders(i.headers),
+		Priority:    i.priority,
+		Group:       i.group,
+		DeadLetter:  i.deadLetter,
+		object:      i.object,
+	}
 }
 
 func (i *item) dequeue() {
@@ -25,6 +96,11 @@ func (i *item) dequeue() {
 	//i.tiq = time.Since(i.ts)
 }
 
+// requeue undoes dequeue, so the time in queue keeps counting from the original enqueue time
+func (i *item) requeue() {
+	i.tiq = nil
+}
+
 func (i *item) TimeInQueue() time.Duration {
 	if i.tiq == nil {
 		return time.Since(i.ts)
@@ -32,61 +108,57 @@ func (i *item) TimeInQueue() time.Duration {
 	return *i.tiq
 }
 
+// size is the number of payload and header bytes the item counts against the queue's byte budget
+func (i *item) size() int64 {
+	return int64(len(i.value)) + headersSize(i.headers) + i.objectSize
+}
+
 func (i *item) String() string {
-	return i.value
+	return string(i.value)
 }
 
 func (i *item) Expired() bool {
 	return i.ttl != 0 && i.TimeInQueue() >= i.ttl
 }
 
+// itemFactory stamps new items with the queue's defaults and with IDs from a per-queue sequence,
+// so IDs are unique within the queue and sort in publish order
 type itemFactory struct {
-	uidMap     map[int]struct{}
+	lastUid    int64
 	defaultTTL time.Duration
-	mx         *sync.RWMutex
 }
 
 func newItemFactory(defaultTTL time.Duration) itemFactory {
 	return itemFactory{
-		uidMap:     make(map[int]struct{}),
-		mx:         &sync.RWMutex{},
 		defaultTTL: defaultTTL,
 	}
 }
 
 func (f *itemFactory) generateUid() int {
-	f.mx.Lock()
-	defer f.mx.Unlock()
-	for {
-		uid := rand.Int()
-		if _, ok := f.uidMap[uid]; !ok {
-			f.uidMap[uid] = struct{}{}
-			return uid
-		}
-	}
-}
-
-func (f *itemFactory) removeUid(uid int) {
-	f.mx.Lock()
-	defer f.mx.Unlock()
-	delete(f.uidMap, uid)
+	return int(atomic.AddInt64(&f.lastUid, 1))
 }
 
-func (f *itemFactory) clear() {
-	f.mx.Lock()
-	defer f.mx.Unlock()
-	f.uidMap = make(map[int]struct{})
-}
-
-func (f *itemFactory) newItem(val string, ttl time.Duration) item {
+func (f *itemFactory) newItem(val []byte, opts MessageOptions) item {
+	ttl := f.defaultTTL
+	if opts.TTL != nil {
+		ttl = *opts.TTL
+	}
+	now := time.Now()
 	return item{
-		uid:   f.generateUid(),
-		value: val,
-		ts:    time.Now(),
-		ttl:   ttl,
+		uid:         f.generateUid(),
+		value:       val,
+		contentType: opts.ContentType,
+		headers:     copyHeaders(opts.Headers),
+		enqueued:    now,
+		ts:          opts.visibleAt(now),
+		ttl:         ttl,
+		priority:    opts.Priority,
+		group:       opts.Group,
+		object:      opts.object,
+		objectSize:  opts.objectSize,
 	}
 }
 
-func (f *itemFactory) newDefaultItem(val string) item {
-	return f.newItem(val, f.defaultTTL)
+func (f *itemFactory) newDefaultItem(val []byte) item {
+	return f.newItem(val, MessageOptions{})
 }
@@ -16,7 +16,7 @@ const (
 func TestItemExpiry(t *testing.T) {
 	i := item{
 		uid:   1,
-		value: "test",
+		value: []byte("test"),
 		ts:    time.Now(),
 		ttl:   testItemDefaultTTL,
 		tiq:   new(time.Duration), // avoid nil pointer dereference
@@ -33,7 +33,7 @@ func TestItemExpiry(t *testing.T) {
 func TestItemDequeue(t *testing.T) {
 	i := item{
 		uid:   1,
-		value: "test",
+		value: []byte("test"),
 		ts:    time.Now(),
 		ttl:   testItemDefaultTTL,
 	}
@@ -47,17 +47,15 @@ func TestItemDequeue(t *testing.T) {
 
 func TestItemFactory(t *testing.T) {
 	f := newItemFactory(testItemDefaultTTL)
-	i := f.newDefaultItem("test")
-	assert.Contains(t, f.uidMap, i.uid, "could not find item UID in factory UID Map")
+	i := f.newDefaultItem([]byte("test"))
 	assert.Equal(t, testItemDefaultTTL, i.ttl, "item ttl not set correctly by factory")
-	f.removeUid(i.uid)
-	assert.NotContains(t, f.uidMap, i.uid, "found item UID in factory UID Map after delete")
 
 	n := 5
+	last := i.uid
 	for range util.Range(n) {
-		f.newDefaultItem("")
+		next := f.newDefaultItem([]byte("")).uid
+		assert.Greater(t, next, last, "item UIDs should increase in creation order")
+		last = next
 	}
-	assert.Len(t, f.uidMap, n, "incorrect uidMap length after populating")
-	f.clear()
-	assert.Len(t, f.uidMap, 0, "incorrect uidMap length after clear")
+	assert.Equal(t, i.uid+n, last, "item UIDs should be sequential")
 }
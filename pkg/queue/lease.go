@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultVisibilityTimeout is used for queues which do not configure their own visibility timeout
+const DefaultVisibilityTimeout = time.Second * 30
+
+func visibilityOrDefault(visibility time.Duration) time.Duration {
+	if visibility <= 0 {
+		return DefaultVisibilityTimeout
+	}
+	return visibility
+}
+
+// Lease is a message handed out under a visibility timeout.
+// Until Deadline, the message is hidden from other consumers. It is removed for good by an Ack,
+// made visible again by a Nack, or made visible again automatically once the deadline passes.
+// A lease which runs out is noticed the next time the queue is used, be it to consume, to count or browse
+// its messages, or by Refresh. The broker refreshes its queues before reporting stats, and its reaper
+// sweeps them as well.
+type Lease struct {
+	Message
+	Receipt    string
+	Deadline   time.Time
+	Deliveries int64
+}
+
+type lease struct {
+	item     item
+	deadline time.Time
+}
+
+func (l *lease) expired(now time.Time) bool {
+	return !now.Before(l.deadline)
+}
+
+// newReceipt makes an unguessable receipt, so only the consumer which holds a lease can ack or nack it
+func newReceipt() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate a lease receipt: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// PopLease dequeues the oldest message under a lease instead of removing it.
+// A nil visibility uses the queue's visibility timeout.
+func (q *Queue) PopLease(visibility *time.Duration) (Lease, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.popLease(visibility)
+}
+
+func (q *Queue) popLease(visibility *time.Duration) (Lease, error) {
+	item_, err := q.next()
+	if err != nil {
+		return Lease{}, err
+	}
+	item_.deliveries++
+	timeout := q.visibility
+	if visibility != nil && *visibility > 0 {
+		timeout = *visibility
+	}
+	l := &lease{
+		item:     item_,
+		deadline: time.Now().Add(timeout),
+	}
+	receipt := newReceipt()
+	for _, exists := q.leases[receipt]; exists; _, exists = q.leases[receipt] {
+		receipt = newReceipt()
+	}
+	q.leases[receipt] = l
+	q.lockGroup(item_, receipt)
+	q.stats.SetInFlight(int64(len(q.leases)))
+	return Lease{
+		Message:    item_.message(),
+		Receipt:    receipt,
+		Deadline:   l.deadline,
+		Deliveries: item_.deliveries,
+	}, nil
+}
+
+// Ack removes a leased message from the queue for good
+func (q *Queue) Ack(receipt string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	l, err := q.takeLease(receipt)
+	if err != nil {
+		return err
+	}
+	q.release(l.item)
+	q.stats.Process(l.item.TimeInQueue())
+	return nil
+}
+
+// Nack gives up a lease and puts the message back at the front of the queue,
+// unless it has used up its deliveries, in which case it is dead-lettered.
+func (q *Queue) Nack(receipt string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	l, err := q.takeLease(receipt)
+	if err != nil {
+		return err
+	}
+	q.retry(l.item)
+	return nil
+}
+
+func (q *Queue) InFlight() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.expireLeases()
+	return len(q.leases)
+}
+
+func (q *Queue) takeLease(receipt string) (*lease, error) {
+	q.expireLeases()
+	l, ok := q.leases[receipt]
+	if !ok {
+		return nil, ErrLeaseNotFound
+	}
+	delete(q.leases, receipt)
+	q.unlockGroup(l.item)
+	q.stats.SetInFlight(int64(len(q.leases)))
+	return l, nil
+}
+
+func (q *Queue) retry(item_ item) {
+	if q.maxDelivery > 0 && item_.deliveries >= q.maxDelivery {
+		q.discard(item_, DeadLetterMaxDeliveries)
+		return
+	}
+	q.requeue(item_)
+}
+
+func (q *Queue) requeue(item_ item) {
+	item_.requeue()
+	q.items.pushFront(item_)
+	q.stats.Requeue()
+	q.notify()
+}
+
+// expireLeases makes every message whose lease has run out visible again, in their original order
+func (q *Queue) expireLeases() {
+	if len(q.leases) == 0 {
+		return
+	}
+	now := time.Now()
+	expired := make([]string, 0)
+	for receipt, l := range q.leases {
+		if l.expired(now) {
+			expired = append(expired, receipt)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	// newest first, since each one is pushed to the front
+	sort.Slice(expired, func(i, j int) bool {
+		return q.leases[expired[i]].item.enqueued.After(q.leases[expired[j]].item.enqueued)
+	})
+	for _, receipt := range expired {
+		q.unlockGroup(q.leases[receipt].item)
+		q.retry(q.leases[receipt].item)
+		delete(q.leases, receipt)
+	}
+	q.stats.SetInFlight(int64(len(q.leases)))
+}
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Get describes the message with the given ID, whether it is visible, scheduled or in flight.
+// Like Browse, it neither dequeues the message nor records any stats.
+func (q *Queue) Get(id int) (MessageInfo, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.expireLeases()
+	q.promote()
+
+	now := time.Now()
+	if i := q.indexOf(id); i >= 0 {
+		if item_ := q.items.at(i); !item_.Expired() {
+			return item_.info(now, MessageVisible), nil
+		}
+		return MessageInfo{}, ErrMessageNotFound
+	}
+	for i := range q.scheduled {
+		if q.scheduled[i].uid == id {
+			return q.scheduled[i].info(now, MessageScheduled), nil
+		}
+	}
+	for _, l := range q.leases {
+		if l.item.uid == id {
+			return l.item.info(now, MessageInFlight), nil
+		}
+	}
+	return MessageInfo{}, ErrMessageNotFound
+}
+
+// Delete removes the message with the given ID for good, wherever it is in the queue.
+// Deleting an in flight message revokes its lease, so it can no longer be acked or nacked.
+func (q *Queue) Delete(id int) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.expireLeases()
+	q.promote()
+
+	if i := q.indexOf(id); i >= 0 {
+		q.release(q.items.remove(i))
+		return nil
+	}
+	for i := range q.scheduled {
+		if q.scheduled[i].uid == id {
+			q.release(heap.Remove(&q.scheduled, i).(item))
+			q.stats.SetScheduled(int64(len(q.scheduled)))
+			return nil
+		}
+	}
+	for receipt, l := range q.leases {
+		if l.item.uid == id {
+			delete(q.leases, receipt)
+			q.unlockGroup(l.item)
+			q.stats.SetInFlight(int64(len(q.leases)))
+			q.release(l.item)
+			return nil
+		}
+	}
+	return ErrMessageNotFound
+}
+
+// indexOf finds the position of a visible item in pop order, or returns -1
+func (q *Queue) indexOf(id int) int {
+	for i := 0; i < q.len(); i++ {
+		if q.items.at(i).uid == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"yambol/config"
+)
+
+// DefaultPublishTimeout is how long a publish waits for room under the block overflow policy,
+// for queues which do not configure their own publish timeout
+const DefaultPublishTimeout = time.Second * 5
+
+// errDropped tells a push that its messages were discarded under the drop-newest policy
+var errDropped = errors.New("dropped by overflow policy")
+
+func publishTimeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultPublishTimeout
+	}
+	return timeout
+}
+
+func isFull(err error) bool {
+	return errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTooLarge)
+}
+
+// makeRoom checks whether n more items, with a combined payload of size bytes, can be pushed,
+// and applies the queue's overflow policy if they cannot
+func (q *Queue) makeRoom(n int, size int64) error {
+	err := q.fits(n, size)
+	if err == nil {
+		return nil
+	}
+	switch q.overflow {
+	case config.OverflowDropOldest:
+		return q.evict(n, size, err)
+	case config.OverflowDropNewest:
+		return errDropped
+	default:
+		return err
+	}
+}
+
+// evict drops the oldest visible items of the lowest priority until n more items of size bytes fit,
+// so a full priority queue gives up its least urgent messages first. Nothing is evicted if even an empty
+// queue would not fit them, in which case full is returned.
+func (q *Queue) evict(n int, size int64, full error) error {
+	q.promote()
+	excessLen := q.len64() + int64(len(q.leases)+len(q.scheduled)+n) - q.maxLen
+	excessSize := q.sizeBytes + size - q.maxSizeBytes
+	if excessLen > q.len64() {
+		return full
+	}
+	var victims []item
+	for q.len() > 0 && (excessLen > 0 || excessSize > 0) {
+		victim := q.items.remove(q.items.oldest())
+		victims = append(victims, victim)
+		excessLen--
+		excessSize -= victim.size()
+	}
+	if excessLen > 0 || excessSize > 0 {
+		// put them back where they were, newest victim first
+		for i := len(victims) - 1; i >= 0; i-- {
+			q.items.pushFront(victims[i])
+		}
+		return full
+	}
+	for _, victim := range victims {
+		victim.dequeue()
+		q.discard(victim, DeadLetterOverflow)
+		q.stats.OverflowDropOldest()
+	}
+	return nil
+}
+
+// awaitRoom calls push, which runs with the lock held, and counts what the overflow policy did about it.
+// Under the block policy, it keeps calling push for as long as the queue is full, sleeping in between
+// until something leaves the queue, ctx is done or the publish timeout passes.
+func (q *Queue) awaitRoom(ctx context.Context, push func() error) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.overflow == config.OverflowBlock {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.publishTimeout)
+		defer cancel()
+	}
+	blocked := false
+	for {
+		err := push()
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, errDropped):
+			q.stats.OverflowDropNewest()
+			return err
+		case !isFull(err):
+			return err
+		case q.overflow != config.OverflowBlock || ctx.Err() != nil:
+			q.stats.OverflowReject()
+			return err
+		}
+		if !blocked {
+			blocked = true
+			q.stats.OverflowBlock()
+		}
+
+		room := q.room
+		q.roomWaiters++
+		q.mx.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+		}
+		q.mx.Lock()
+		q.roomWaiters--
+	}
+}
+
+// notifyRoom wakes every publish blocked in awaitRoom. Needs to be called whenever an item leaves the queue for good.
+func (q *Queue) notifyRoom() {
+	if q.roomWaiters == 0 {
+		return
+	}
+	close(q.room)
+	q.room = make(chan struct{})
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"fmt"
+
+	"yambol/config"
+)
+
+// pauseDirections resolves a pause direction into which of consuming and publishing it covers
+func pauseDirections(direction string) (consume, publish bool, err error) {
+	switch direction {
+	case config.PauseConsume:
+		return true, false, nil
+	case config.PausePublish:
+		return false, true, nil
+	case config.PauseBoth:
+		return true, true, nil
+	default:
+		return false, false, fmt.Errorf("%w `%s`", ErrInvalidPauseDirection, direction)
+	}
+}
+
+func pausedState(consume, publish bool) string {
+	switch {
+	case consume && publish:
+		return config.PauseBoth
+	case consume:
+		return config.PauseConsume
+	case publish:
+		return config.PausePublish
+	default:
+		return ""
+	}
+}
+
+// Pause stops consuming from the queue, publishing into it, or both, depending on direction.
+// Blocked consumers and publishers give up straight away. Dead letters are still accepted by a queue
+// with publishing paused, since they are not published.
+func (q *Queue) Pause(direction string) error {
+	consume, publish, err := pauseDirections(direction)
+	if err != nil {
+		return err
+	}
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.consumePaused = q.consumePaused || consume
+	q.publishPaused = q.publishPaused || publish
+	q.notify()
+	q.notifyRoom()
+	return nil
+}
+
+// Resume undoes Pause for the given direction
+func (q *Queue) Resume(direction string) error {
+	consume, publish, err := pauseDirections(direction)
+	if err != nil {
+		return err
+	}
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.consumePaused = q.consumePaused && !consume
+	q.publishPaused = q.publishPaused && !publish
+	q.notify()
+	return nil
+}
+
+// Paused returns which directions of the queue are paused, as one of the config.Pause values, or an empty string
+func (q *Queue) Paused() string {
+	q.mx.RLock()
+	defer q.mx.RUnlock()
+	return pausedState(q.consumePaused, q.publishPaused)
+}
@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Purge throws away the visible and scheduled messages which were pushed at least olderThan ago,
+// or all of them if olderThan is not positive. Messages in flight are left to their consumers.
+// Purged messages are neither dropped nor dead-lettered, and are counted as purged instead.
+// Returns how many messages were purged.
+func (q *Queue) Purge(olderThan time.Duration) int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.promote()
+	now := time.Now()
+	old := func(item_ *item) bool {
+		return olderThan <= 0 || now.Sub(item_.enqueued) >= olderThan
+	}
+
+	purged := q.sweep(old, q.release)
+
+	kept := q.scheduled[:0]
+	for _, item_ := range q.scheduled {
+		if !old(&item_) {
+			kept = append(kept, item_)
+			continue
+		}
+		q.release(item_)
+		purged++
+	}
+	for i := len(kept); i < len(q.scheduled); i++ {
+		q.scheduled[i] = item{} // release the value for the GC
+	}
+	q.scheduled = kept
+	heap.Init(&q.scheduled)
+	q.stats.SetScheduled(int64(len(q.scheduled)))
+
+	q.stats.Purge(int64(purged))
+	return purged
+}
@@ -1,7 +1,11 @@
 package queue
 
 import (
+	"container/heap"
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 	"yambol/config"
 
@@ -9,108 +13,243 @@ import (
 )
 
 type Queue struct {
-	mx           *sync.RWMutex
-	minLen       int64
-	maxLen       int64
-	maxSizeBytes int64
-	items        []item
-	factory      itemFactory
-	stats        *telemetry.QueueStats
+	mx             *sync.RWMutex
+	minLen         int64
+	maxLen         int64
+	maxSizeBytes   int64
+	sizeBytes      int64
+	items          store
+	scheduled      schedule
+	leases         map[string]*lease
+	groups         map[string]string // message group -> receipt of its message in flight
+	ready          chan struct{}
+	readyClosed    atomic.Bool // set once a push holding only the read lock closes ready
+	waiters        int
+	room           chan struct{}
+	roomWaiters    int
+	overflow       string
+	publishTimeout time.Duration
+	consumePaused  bool
+	publishPaused  bool
+	visibility     time.Duration
+	maxDelivery    int64
+	deadLetters    *deadLetterTarget
+	dedup          dedup
+	factory        itemFactory
+	inbox          *inbox // only set for sharded queues
+	stats          *telemetry.QueueStats
 }
 
 func New(cfg config.QueueConfig, stats *telemetry.QueueStats) *Queue {
 	if cfg.MinLength <= 0 {
 		cfg.MinLength = 1
 	}
+	// an unknown direction pauses nothing, since the config is validated before queues are created
+	consumePaused, publishPaused, _ := pauseDirections(cfg.PausedDirection())
+	var inbox_ *inbox
+	if cfg.Type == config.QueueTypeSharded {
+		inbox_ = newInbox(cfg.Shards)
+	}
 	return &Queue{
-		stats:        stats,
-		mx:           &sync.RWMutex{},
-		minLen:       cfg.MinLength,
-		maxLen:       cfg.MaxLength,
-		maxSizeBytes: cfg.MaxSizeBytes,
-		items:        make([]item, 0, cfg.MinLength),
-		factory:      newItemFactory(cfg.TTLDuration()),
+		stats:          stats,
+		mx:             &sync.RWMutex{},
+		minLen:         cfg.MinLength,
+		maxLen:         cfg.MaxLength,
+		maxSizeBytes:   cfg.MaxSizeBytes,
+		items:          newStore(cfg.Type, int(cfg.MinLength)),
+		leases:         make(map[string]*lease),
+		groups:         make(map[string]string),
+		ready:          make(chan struct{}),
+		room:           make(chan struct{}),
+		overflow:       cfg.Overflow,
+		publishTimeout: publishTimeoutOrDefault(cfg.PublishTimeoutDuration()),
+		consumePaused:  consumePaused,
+		publishPaused:  publishPaused,
+		visibility:     visibilityOrDefault(cfg.VisibilityTimeoutDuration()),
+		maxDelivery:    cfg.MaxDeliveries,
+		dedup:          newDedup(cfg.DedupWindowDuration()),
+		factory:        newItemFactory(cfg.TTLDuration()),
+		inbox:          inbox_,
 	}
 }
 
 func (q *Queue) len() int {
-	return len(q.items)
+	return q.items.len()
 }
 
 func (q *Queue) len64() int64 {
-	return int64(len(q.items))
+	return int64(q.items.len())
 }
 
+// Len returns how many messages are visible to consumers. Leased and scheduled messages are not counted.
 func (q *Queue) Len() int {
-	q.mx.RLock()
-	defer q.mx.RUnlock()
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.expireLeases()
+	q.promote()
 	return q.len()
 }
 
-func (q *Queue) PushBatch(values ...string) ([]int, error) {
+// Refresh makes messages whose lease has run out, and scheduled messages which are due, visible without
+// consuming anything, so the queue's stats count them. Every other operation on the queue does so as well.
+func (q *Queue) Refresh() {
 	q.mx.Lock()
 	defer q.mx.Unlock()
+	q.expireLeases()
+	q.promote()
+}
 
-	if int64(q.len()+len(values)) >= q.maxLen {
-		return nil, ErrQueueFull
+func (q *Queue) SizeBytes() int64 {
+	q.mx.RLock()
+	defer q.mx.RUnlock()
+	return q.sizeBytes + q.inbox.pendingBytes()
+}
+
+// fits checks whether n more items, with a combined payload of size bytes, can be pushed.
+// Leased and scheduled items count against both limits as well.
+func (q *Queue) fits(n int, size int64) error {
+	if q.len64()+int64(len(q.leases)+len(q.scheduled)+n)+q.inbox.pendingLen() > q.maxLen {
+		return ErrQueueFull
+	}
+	if q.sizeBytes+size+q.inbox.pendingBytes() > q.maxSizeBytes {
+		return ErrQueueTooLarge
 	}
+	return nil
+}
 
-	uids := make([]int, len(values))
+func (q *Queue) PushBatch(values ...string) ([]int, error) {
+	entries := make([]BatchEntry, len(values))
 	for i, value := range values {
-		item_ := q.factory.newDefaultItem(value)
-		q.items = append(q.items, item_)
-		uids[i] = item_.uid
+		entries[i] = BatchEntry{Value: []byte(value)}
 	}
-	return uids, nil
+	return q.PushBatchBytes(entries)
 }
 
 func (q *Queue) PushWithTTL(value string, ttl *time.Duration) (int, error) {
-	if ttl == nil {
-		return q.Push(value)
-	}
-	q.mx.Lock()
-	defer q.mx.Unlock()
+	return q.PushWithOptions(value, MessageOptions{TTL: ttl})
+}
 
-	if q.len64() >= q.maxLen {
-		return -1, ErrQueueFull
-	}
+func (q *Queue) Push(value string) (int, error) {
+	return q.PushWithOptions(value, MessageOptions{})
+}
 
-	item_ := q.factory.newItem(value, *ttl)
-	q.items = append(q.items, item_)
-	return item_.uid, nil
+func (q *Queue) PushWithOptions(value string, opts MessageOptions) (int, error) {
+	return q.PushBytes([]byte(value), opts)
 }
 
-func (q *Queue) Push(value string) (int, error) {
+// PushBytes pushes a binary payload. The queue keeps a reference to value, so it must not be modified afterwards.
+// If the queue is full, what happens depends on its overflow policy. A message discarded under the drop-newest
+// policy is not an error, but gets -1 as its ID.
+func (q *Queue) PushBytes(value []byte, opts MessageOptions) (int, error) {
+	return q.PushBytesContext(context.Background(), value, opts)
+}
+
+// PushBytesContext is like PushBytes, but under the block overflow policy it gives up waiting for room once ctx is done
+func (q *Queue) PushBytesContext(ctx context.Context, value []byte, opts MessageOptions) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return -1, err
+	}
+	if q.inbox != nil {
+		if id, ok := q.pushShared(value, opts); ok {
+			return id, nil
+		}
+	}
+	id := -1
+	err := q.awaitRoom(ctx, func() (err error) {
+		id, err = q.push(value, opts)
+		return err
+	})
+	if err != nil && !errors.Is(err, errDropped) {
+		return -1, err
+	}
+	return id, nil
+}
+
+// RetryPushBytes pushes a message which the queue turned away for lack of room before, without waiting for room.
+// If there is still no room, the message is turned away again, but does not count as another rejection.
+func (q *Queue) RetryPushBytes(value []byte, opts MessageOptions) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return -1, err
+	}
 	q.mx.Lock()
 	defer q.mx.Unlock()
 
-	if q.len64() >= q.maxLen {
-		return -1, ErrQueueFull
+	id, err := q.push(value, opts)
+	if errors.Is(err, errDropped) {
+		q.stats.OverflowDropNewest()
+		return -1, nil
+	}
+	return id, err
+}
+
+// push runs with the lock held
+func (q *Queue) push(value []byte, opts MessageOptions) (int, error) {
+	if q.publishPaused {
+		return -1, ErrPublishPaused
+	}
+	now := time.Now()
+	if opts.DedupKey != "" {
+		if uid, ok := q.dedup.lookup(opts.DedupKey, now); ok {
+			q.stats.DedupHit()
+			return uid, nil
+		}
+	}
+	if err := q.makeRoom(1, opts.payloadSize(value)); err != nil {
+		return -1, err
 	}
 
-	item_ := q.factory.newDefaultItem(value)
-	q.items = append(q.items, item_)
+	q.promote()
+	item_ := q.factory.newItem(value, opts)
+	q.append(item_)
+	if opts.DedupKey != "" {
+		q.dedup.remember(opts.DedupKey, item_.uid, now)
+	}
 	return item_.uid, nil
 }
 
 func (q *Queue) Pop() (string, error) {
+	msg, err := q.PopMessage()
+	return string(msg.Value), err
+}
+
+// PopMessage is like Pop, but also returns what the queue knows about the message
+func (q *Queue) PopMessage() (Message, error) {
 	q.mx.Lock()
 	defer q.mx.Unlock()
+	return q.popMessage()
+}
 
-	if q.len() == 0 {
-		return "", ErrQueueEmpty
-	}
-	item_ := q.pop()
-	for item_.Expired() {
-		q.factory.removeUid(item_.uid)
-		q.stats.Drop(item_.TimeInQueue())
-		if q.len() == 0 {
-			return "", ErrQueueEmpty
-		}
-		item_ = q.pop()
+func (q *Queue) popMessage() (Message, error) {
+	item_, err := q.next()
+	if err != nil {
+		return Message{}, err
 	}
+	q.release(item_)
 	q.stats.Process(item_.TimeInQueue())
-	return item_.value, nil
+	return item_.message(), nil
+}
+
+// next dequeues the oldest live item whose message group has nothing in flight, dropping any expired
+// items on the way. The caller decides whether the item is processed or leased.
+func (q *Queue) next() (item, error) {
+	if q.consumePaused {
+		return item{}, ErrConsumePaused
+	}
+	q.expireLeases()
+	q.promote()
+	for i := 0; i < q.len(); {
+		candidate := q.items.at(i)
+		if candidate.Expired() {
+			q.discard(q.take(i), DeadLetterExpired)
+			continue
+		}
+		if q.groupLocked(candidate.group) {
+			i++
+			continue
+		}
+		return q.take(i), nil
+	}
+	return item{}, ErrQueueEmpty
 }
 
 func (q *Queue) peek() *item {
@@ -119,50 +258,73 @@ func (q *Queue) peek() *item {
 	if q.len() == 0 {
 		return nil
 	}
-	return &q.items[0]
+	return q.items.at(0)
 }
 
+// Drain dequeues every message Pop would hand out, in the same order, dropping expired ones on the way.
+// Like Pop, it dequeues nothing from a queue paused for consuming, and leaves the messages of groups
+// with a message in flight where they are.
 func (q *Queue) Drain() []string {
 	q.mx.Lock()
 	defer q.mx.Unlock()
 
-	if q.len() == 0 {
+	if q.consumePaused {
 		return []string{}
 	}
+	q.expireLeases()
+	q.promote()
 
-	values := make([]string, 0, len(q.items))
-	for _, item_ := range q.items {
-		item_.dequeue()
+	values := make([]string, 0, q.len())
+	available := func(item_ *item) bool {
+		return item_.Expired() || !q.groupLocked(item_.group)
+	}
+	q.sweep(available, func(item_ item) {
 		if item_.Expired() {
-			q.stats.Drop(item_.TimeInQueue())
-		} else {
-			q.stats.Process(item_.TimeInQueue())
-			values = append(values, item_.value)
+			q.discard(item_, DeadLetterExpired)
+			return
 		}
-	}
-	q.clear()
+		q.release(item_)
+		q.stats.Process(item_.TimeInQueue())
+		values = append(values, string(item_.value))
+	})
 	return values
 }
 
-func (q *Queue) resize() {
-	if int64(cap(q.items)) > q.minLen && len(q.items) < cap(q.items)/2 {
-		newItems := make([]item, len(q.items), len(q.items)*2)
-		copy(newItems, q.items)
-		q.items = newItems
+// append adds a new item, which is either visible straight away or waits in the schedule until it is due
+func (q *Queue) append(item_ item) {
+	if item_.ts.After(time.Now()) {
+		heap.Push(&q.scheduled, item_)
+		q.stats.SetScheduled(int64(len(q.scheduled)))
+	} else {
+		q.items.push(item_)
+		q.notify()
 	}
+	q.setSize(q.sizeBytes + item_.size())
 }
 
 func (q *Queue) pop() item {
-	item_ := q.items[0]
+	item_ := q.items.pop()
 	item_.dequeue()
-	if q.len() != 0 {
-		q.items = q.items[1:]
-		q.resize()
+	return item_
+}
+
+// take dequeues the i-th item in pop order
+func (q *Queue) take(i int) item {
+	if i == 0 {
+		return q.pop()
 	}
+	item_ := q.items.remove(i)
+	item_.dequeue()
 	return item_
 }
 
-func (q *Queue) clear() {
-	q.items = make([]item, 0, q.minLen)
-	q.factory.clear()
+// release gives the item's bytes back to the queue's budget once it leaves the queue for good
+func (q *Queue) release(item_ item) {
+	q.setSize(q.sizeBytes - item_.size())
+	q.notifyRoom()
+}
+
+func (q *Queue) setSize(size int64) {
+	q.sizeBytes = size
+	q.stats.SetSize(size)
 }
@@ -1,8 +1,10 @@
 package queue
 
 import (
+	"context"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 	"yambol/config"
@@ -17,7 +19,7 @@ import (
 const (
 	testQueueDefaultMinLen  = 10
 	testQueueDefaultMaxLen  = 10000
-	testQueueDefaultMaxSize = 1024 * 10 // 10KB
+	testQueueDefaultMaxSize = 1024 * 64 // 64KB
 	testQueueDefaultTTL     = 1
 )
 
@@ -28,7 +30,7 @@ func queueSetUp() (*Queue, *telemetry.QueueStats) {
 			MinLength:    testQueueDefaultMinLen,
 			MaxLength:    testQueueDefaultMaxLen,
 			MaxSizeBytes: testQueueDefaultMaxSize,
-			TTL:          testQueueDefaultTTL,
+			TTL:          util.Duration(util.Seconds(testQueueDefaultTTL)),
 		}, qs), qs
 }
 
@@ -59,7 +61,7 @@ func TestQueueBasicDefaultOps(t *testing.T) {
 		assert.NoError(t, err, "failed to pop", i)
 		assert.Equal(t, strconv.Itoa(i), val, "mismatched value popped", i, val)
 	}
-	assert.Empty(t, q.items, "queue not empty")
+	assert.Zero(t, q.items.len(), "queue not empty")
 	assert.Equal(t, int64(testQueueDefaultMaxLen), qs.Processed, "mismatched number of processed items")
 	_, err = q.Pop()
 	assert.Error(t, err, "popped from empty queue")
@@ -110,3 +112,1075 @@ func TestQueueExpiration(t *testing.T) {
 	assert.Equal(t, int64(0), qs.Processed, "mismatched number of processed items")
 	assert.Equal(t, int64(1), qs.Dropped, "mismatched number of dropped items")
 }
+
+func TestQueueSizeBudget(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{
+		MinLength:    testQueueDefaultMinLen,
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: 10,
+	}, qs)
+
+	_, err := q.Push("12345")
+	assert.NoError(t, err, "failed to push within budget")
+	_, err = q.Push("1234")
+	assert.NoError(t, err, "failed to push within budget")
+	assert.Equal(t, int64(9), q.SizeBytes(), "mismatched queue size")
+	assert.Equal(t, int64(9), qs.SizeBytes, "mismatched reported queue size")
+
+	_, err = q.Push("12")
+	assert.ErrorIs(t, err, ErrQueueTooLarge, "managed to push over the byte budget")
+	_, err = q.PushBatch("1", "2")
+	assert.ErrorIs(t, err, ErrQueueTooLarge, "managed to push batch over the byte budget")
+	_, err = q.Push("1")
+	assert.NoError(t, err, "failed to push exactly up to the budget")
+
+	_, err = q.Pop()
+	assert.NoError(t, err, "failed to pop")
+	assert.Equal(t, int64(5), q.SizeBytes(), "size not released on pop")
+
+	ttl := time.Nanosecond
+	_, err = q.PushWithTTL("123", &ttl)
+	assert.NoError(t, err, "failed to push with ttl")
+	q.Drain()
+	assert.Equal(t, int64(0), q.SizeBytes(), "size not released on drain")
+	assert.Equal(t, int64(0), qs.SizeBytes, "reported size not released on drain")
+
+	_, err = q.PushWithTTL("123", &ttl)
+	assert.NoError(t, err, "failed to push with ttl")
+	time.Sleep(time.Millisecond)
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty, "popped expired item")
+	assert.Equal(t, int64(0), q.SizeBytes(), "size not released on expiry")
+}
+
+func benchmarkQueueSteadyState(b *testing.B, backlog int) {
+	q := New(config.QueueConfig{
+		MinLength:    testQueueDefaultMinLen,
+		MaxLength:    int64(backlog) + 1,
+		MaxSizeBytes: int64(backlog+1) * 16,
+	}, &telemetry.QueueStats{})
+	for i := 0; i < backlog; i++ {
+		_, _ = q.Push("0123456789abcdef")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = q.Push("0123456789abcdef")
+		_, _ = q.Pop()
+	}
+}
+
+func BenchmarkQueueSteadyStateEmpty(b *testing.B) {
+	benchmarkQueueSteadyState(b, 0)
+}
+
+func BenchmarkQueueSteadyStateBacklog(b *testing.B) {
+	benchmarkQueueSteadyState(b, 1024)
+}
+
+func BenchmarkQueueBurst(b *testing.B) {
+	q := New(config.QueueConfig{
+		MinLength:    testQueueDefaultMinLen,
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: testQueueDefaultMaxLen * 16,
+	}, &telemetry.QueueStats{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			_, _ = q.Push("0123456789abcdef")
+		}
+		for j := 0; j < 1000; j++ {
+			_, _ = q.Pop()
+		}
+	}
+}
+
+func TestQueueLeases(t *testing.T) {
+	q, qs := queueSetUp()
+	_, err := q.PushBatch("a", "b")
+	assert.NoError(t, err, "failed to push batch")
+
+	visibility := time.Millisecond * 50
+	la, err := q.PopLease(&visibility)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "a", string(la.Value))
+	lb, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "b", string(lb.Value))
+	assert.True(t, lb.Deadline.After(la.Deadline), "default visibility should outlast the custom one")
+	assert.NotEqual(t, la.Receipt, lb.Receipt, "receipts should be unique")
+
+	assert.Zero(t, q.Len(), "leased items should be invisible")
+	assert.Equal(t, 2, q.InFlight(), "mismatched in-flight count")
+	assert.Equal(t, int64(2), q.SizeBytes(), "leased items should still count against the byte budget")
+
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty, "popped while everything is leased")
+
+	assert.NoError(t, q.Ack(lb.Receipt), "failed to ack")
+	assert.ErrorIs(t, q.Ack(lb.Receipt), ErrLeaseNotFound, "acked twice")
+	assert.Equal(t, int64(1), qs.Processed, "ack should count as processed")
+	assert.Equal(t, int64(1), q.SizeBytes(), "ack should release the bytes")
+
+	time.Sleep(visibility)
+	assert.ErrorIs(t, q.Ack(la.Receipt), ErrLeaseNotFound, "acked an expired lease")
+	assert.Equal(t, 1, q.Len(), "expired lease should be visible again")
+	assert.Equal(t, int64(1), qs.Requeued, "expired lease should count as requeued")
+
+	_, err = q.Push("c")
+	assert.NoError(t, err, "failed to push")
+	la, err = q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease redelivered item")
+	assert.Equal(t, "a", string(la.Value), "redelivered item should keep its place in line")
+
+	assert.NoError(t, q.Nack(la.Receipt), "failed to nack")
+	val, err := q.Pop()
+	assert.NoError(t, err, "failed to pop nacked item")
+	assert.Equal(t, "a", val, "nacked item should be first in line")
+
+	// leases which ran out show up in what the queue reports without anything being consumed
+	visibility = time.Millisecond
+	_, err = q.PopLease(&visibility)
+	assert.NoError(t, err, "failed to lease")
+	time.Sleep(visibility * 2)
+	infos := q.Browse(10)
+	if assert.Len(t, infos, 1, "browse should list messages whose lease ran out") {
+		assert.Equal(t, "c", string(infos[0].Value))
+	}
+	_, err = q.PopLease(&visibility)
+	assert.NoError(t, err, "failed to lease")
+	time.Sleep(visibility * 2)
+	assert.Zero(t, q.InFlight(), "leases which ran out should not count as in flight")
+	_, err = q.PopLease(&visibility)
+	assert.NoError(t, err, "failed to lease")
+	time.Sleep(visibility * 2)
+	q.Refresh()
+	assert.Zero(t, qs.InFlight, "refresh should count leases which ran out as no longer in flight")
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	q, qs := queueSetUp()
+	dlq, dlqs := queueSetUp()
+	q.maxDelivery = 2
+	q.SetDeadLetterQueue("source", dlq)
+
+	ttl := time.Nanosecond
+	_, err := q.PushWithTTL("expired", &ttl)
+	assert.NoError(t, err, "failed to push with ttl")
+	_, err = q.Push("rejected")
+	assert.NoError(t, err, "failed to push")
+	time.Sleep(time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		l, err := q.PopLease(nil)
+		assert.NoError(t, err, "failed to lease", i)
+		assert.Equal(t, "rejected", string(l.Value), "expired item should not be leased")
+		assert.Equal(t, int64(i+1), l.Deliveries, "mismatched delivery count")
+		assert.NoError(t, q.Nack(l.Receipt), "failed to nack", i)
+	}
+	assert.Zero(t, q.Len(), "item should not be requeued after its last delivery")
+	assert.Zero(t, q.SizeBytes(), "dead-lettered items should release their bytes")
+	assert.Equal(t, int64(2), qs.DeadLettered, "mismatched dead-lettered count")
+	assert.Equal(t, int64(0), qs.Dropped, "dead-lettered items should not count as dropped")
+
+	msg, err := dlq.PopMessage()
+	assert.NoError(t, err, "failed to pop from dead-letter queue")
+	assert.Equal(t, "expired", string(msg.Value))
+	assert.Equal(t, DeadLetterExpired, msg.DeadLetter.Reason)
+	assert.Equal(t, "source", msg.DeadLetter.Queue)
+	assert.False(t, msg.DeadLetter.EnqueuedAt.IsZero(), "original enqueue time not recorded")
+
+	msg, err = dlq.PopMessage()
+	assert.NoError(t, err, "failed to pop from dead-letter queue")
+	assert.Equal(t, "rejected", string(msg.Value))
+	assert.Equal(t, DeadLetterMaxDeliveries, msg.DeadLetter.Reason)
+	assert.Equal(t, int64(2), dlqs.Processed, "mismatched dead-letter queue processed count")
+
+	dlq.maxSizeBytes = 0
+	_, err = q.PushWithTTL("expired", &ttl)
+	assert.NoError(t, err, "failed to push with ttl")
+	time.Sleep(time.Millisecond)
+	q.Drain()
+	assert.Equal(t, int64(1), qs.Dropped, "item should be dropped when the dead-letter queue is full")
+}
+
+func TestQueuePriority(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{
+		MinLength:    testQueueDefaultMinLen,
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: testQueueDefaultMaxSize,
+		Type:         config.QueueTypePriority,
+	}, qs)
+
+	ttl := time.Nanosecond
+	_, err := q.PushWithOptions("low", MessageOptions{Priority: 1})
+	assert.NoError(t, err, "failed to push")
+	_, err = q.PushWithOptions("high-expired", MessageOptions{Priority: 9, TTL: &ttl})
+	assert.NoError(t, err, "failed to push")
+	_, err = q.PushWithOptions("high-1", MessageOptions{Priority: 5})
+	assert.NoError(t, err, "failed to push")
+	_, err = q.PushWithOptions("high-2", MessageOptions{Priority: 5})
+	assert.NoError(t, err, "failed to push")
+	time.Sleep(time.Millisecond)
+
+	l, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "high-1", string(l.Value), "expected highest live priority first")
+	assert.Equal(t, 5, l.Priority)
+	assert.Equal(t, int64(1), qs.Dropped, "expired item should be dropped")
+	assert.NoError(t, q.Nack(l.Receipt), "failed to nack")
+
+	for _, expected := range []string{"high-1", "high-2", "low"} {
+		val, err := q.Pop()
+		assert.NoError(t, err, "failed to pop")
+		assert.Equal(t, expected, val, "mismatched pop order")
+	}
+	assert.Equal(t, int64(3), qs.Processed, "mismatched number of processed items")
+}
+
+func TestQueueScheduled(t *testing.T) {
+	q, qs := queueSetUp()
+
+	delay := time.Millisecond * 30
+	ttl := time.Millisecond * 20
+	_, err := q.PushWithOptions("delayed", MessageOptions{Delay: delay, TTL: &ttl})
+	assert.NoError(t, err, "failed to push delayed")
+	_, err = q.PushWithOptions("scheduled", MessageOptions{DeliverAt: time.Now().Add(delay / 2)})
+	assert.NoError(t, err, "failed to push scheduled")
+	_, err = q.PushWithOptions("overdue", MessageOptions{DeliverAt: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err, "failed to push with a delivery time in the past")
+
+	assert.Equal(t, 1, q.Len(), "scheduled items should not be visible")
+	assert.Equal(t, 2, q.Scheduled(), "mismatched scheduled count")
+	assert.Equal(t, int64(2), qs.Scheduled, "mismatched reported scheduled count")
+	assert.Equal(t, int64(len("delayed")+len("scheduled")+len("overdue")), q.SizeBytes(), "scheduled items should count against the budget")
+
+	val, err := q.Pop()
+	assert.NoError(t, err, "failed to pop")
+	assert.Equal(t, "overdue", val)
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty, "popped an item before it was due")
+	assert.Empty(t, q.Drain(), "drained an item before it was due")
+
+	time.Sleep(delay)
+	assert.Zero(t, q.Scheduled(), "all items should be due")
+	assert.Equal(t, int64(0), qs.Scheduled, "mismatched reported scheduled count")
+	assert.Equal(t, []string{"scheduled", "delayed"}, q.Drain(), "due items should become visible in the order they were due")
+	assert.Equal(t, int64(0), qs.Dropped, "ttl should count from when the item became visible")
+}
+
+func TestQueuePopContext(t *testing.T) {
+	q, _ := queueSetUp()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, err := q.PopContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "expected to time out on an empty queue")
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_, _ = q.Push("pushed")
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	val, err := q.PopContext(ctx)
+	assert.NoError(t, err, "failed to wait for a pushed item")
+	assert.Equal(t, "pushed", val)
+
+	_, err = q.PushWithOptions("scheduled", MessageOptions{Delay: time.Millisecond * 10})
+	assert.NoError(t, err, "failed to push scheduled item")
+	val, err = q.PopContext(ctx)
+	assert.NoError(t, err, "failed to wait for a scheduled item")
+	assert.Equal(t, "scheduled", val)
+
+	_, err = q.Push("leased")
+	assert.NoError(t, err, "failed to push")
+	visibility := time.Millisecond * 10
+	_, err = q.PopLeaseContext(ctx, &visibility)
+	assert.NoError(t, err, "failed to lease")
+	msg, err := q.PopMessageContext(ctx)
+	assert.NoError(t, err, "failed to wait for an expired lease")
+	assert.Equal(t, "leased", string(msg.Value))
+	assert.NoError(t, ctx.Err(), "should not have waited for the whole timeout")
+}
+
+func TestQueueBrowse(t *testing.T) {
+	q, qs := queueSetUp()
+
+	values := []string{"a", "b", "c", "d", "e"}
+	for _, v := range values {
+		_, err := q.Push(v)
+		assert.NoError(t, err, "failed to push", v)
+	}
+
+	infos := q.Browse(3)
+	assert.Len(t, infos, 3, "mismatched page size")
+	for i, info := range infos {
+		assert.Equal(t, values[i], string(info.Value), "browse should list messages in consume order")
+	}
+	assert.Equal(t, len(values), q.Len(), "browsing should not dequeue")
+	assert.Equal(t, int64(0), qs.Processed, "browsing should not count as processing")
+
+	next, err := q.BrowseAfter(infos[len(infos)-1].ID, 3)
+	assert.NoError(t, err, "failed to browse after cursor")
+	assert.Len(t, next, 2, "mismatched last page size")
+	assert.Equal(t, "d", string(next[0].Value))
+	assert.Equal(t, "e", string(next[1].Value))
+
+	val, err := q.Pop()
+	assert.NoError(t, err, "failed to pop")
+	assert.Equal(t, "a", val)
+	_, err = q.BrowseAfter(infos[0].ID, 3)
+	assert.ErrorIs(t, err, ErrCursorNotFound, "browsed after a consumed message")
+
+	l, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "c", string(q.Browse(1)[0].Value), "leased messages should not be listed")
+	assert.NoError(t, q.Nack(l.Receipt), "failed to nack")
+	info := q.Browse(1)[0]
+	assert.Equal(t, "b", string(info.Value), "nacked message should be listed first")
+	assert.Equal(t, int64(1), info.Deliveries, "mismatched deliveries")
+}
+
+func TestQueueBytes(t *testing.T) {
+	q, _ := queueSetUp()
+
+	payload := []byte{0x00, 0xff, 0xfe, 'y', 0x80}
+	_, err := q.PushBytes(payload, MessageOptions{ContentType: "application/octet-stream"})
+	assert.NoError(t, err, "failed to push bytes")
+	assert.Equal(t, int64(len(payload)), q.SizeBytes(), "binary payload should count against the budget")
+
+	info := q.Browse(1)[0]
+	assert.Equal(t, payload, info.Value, "mismatched browsed payload")
+	assert.Equal(t, "application/octet-stream", info.ContentType, "mismatched browsed content type")
+
+	msg, err := q.PopMessage()
+	assert.NoError(t, err, "failed to pop bytes")
+	assert.Equal(t, payload, msg.Value, "payload was not returned as is")
+	assert.Equal(t, "application/octet-stream", msg.ContentType, "mismatched content type")
+
+	_, err = q.Push("text")
+	assert.NoError(t, err, "failed to push text")
+	msg, err = q.PopMessage()
+	assert.NoError(t, err, "failed to pop text")
+	assert.Equal(t, []byte("text"), msg.Value)
+	assert.Empty(t, msg.ContentType, "content type should be optional")
+}
+
+func TestQueueHeaders(t *testing.T) {
+	q, _ := queueSetUp()
+
+	headers := map[string]string{"correlation-id": "abc", "tenant": "t1"}
+	_, err := q.PushWithOptions("value", MessageOptions{Headers: headers})
+	assert.NoError(t, err, "failed to push with headers")
+	assert.Equal(t, int64(len("value")+len("correlation-idabctenantt1")), q.SizeBytes(), "headers should count against the budget")
+
+	headers["tenant"] = "changed"
+	assert.Equal(t, "t1", q.Browse(1)[0].Headers["tenant"], "queue should keep its own copy of the headers")
+
+	msg, err := q.PopMessage()
+	assert.NoError(t, err, "failed to pop")
+	assert.Equal(t, map[string]string{"correlation-id": "abc", "tenant": "t1"}, msg.Headers, "mismatched headers")
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxHeaders; i++ {
+		tooMany[strconv.Itoa(i)] = ""
+	}
+	_, err = q.PushWithOptions("value", MessageOptions{Headers: tooMany})
+	assert.ErrorIs(t, err, ErrInvalidHeaders, "pushed too many headers")
+
+	_, err = q.PushWithOptions("value", MessageOptions{Headers: map[string]string{"big": strings.Repeat("x", MaxHeadersSize)}})
+	assert.ErrorIs(t, err, ErrInvalidHeaders, "pushed headers which are too large")
+
+	_, err = q.PushWithOptions("value", MessageOptions{Headers: map[string]string{"": "x"}})
+	assert.ErrorIs(t, err, ErrInvalidHeaders, "pushed a header without a key")
+	assert.Zero(t, q.Len(), "invalid messages should not be pushed")
+}
+
+func TestQueueGetDelete(t *testing.T) {
+	q, qs := queueSetUp()
+
+	ids := make([]int, 0)
+	for _, v := range []string{"a", "b", "c"} {
+		id, err := q.Push(v)
+		assert.NoError(t, err, "failed to push", v)
+		ids = append(ids, id)
+	}
+	scheduled, err := q.PushWithOptions("later", MessageOptions{Delay: time.Hour})
+	assert.NoError(t, err, "failed to push scheduled")
+	ids = append(ids, scheduled)
+	for i := 1; i < len(ids); i++ {
+		assert.Greater(t, ids[i], ids[i-1], "IDs should increase in publish order")
+	}
+
+	l, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+
+	for id, state := range map[int]string{ids[0]: MessageInFlight, ids[1]: MessageVisible, scheduled: MessageScheduled} {
+		info, err := q.Get(id)
+		assert.NoError(t, err, "failed to get message", id)
+		assert.Equal(t, id, info.ID)
+		assert.Equal(t, state, info.State, "mismatched state of message", id)
+	}
+	_, err = q.Get(-1)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	assert.NoError(t, q.Delete(ids[1]), "failed to delete visible message")
+	assert.NoError(t, q.Delete(scheduled), "failed to delete scheduled message")
+	assert.NoError(t, q.Delete(ids[0]), "failed to delete in flight message")
+	assert.ErrorIs(t, q.Delete(ids[1]), ErrMessageNotFound, "deleted a message twice")
+	assert.ErrorIs(t, q.Ack(l.Receipt), ErrLeaseNotFound, "acked a deleted message")
+
+	assert.Equal(t, int64(len("c")), q.SizeBytes(), "deleted messages should release their bytes")
+	assert.Equal(t, int64(0), qs.Scheduled, "mismatched reported scheduled count")
+	assert.Equal(t, int64(0), qs.InFlight, "mismatched reported in flight count")
+	assert.Equal(t, []string{"c"}, q.Drain())
+
+	id, err := q.Push("d")
+	assert.NoError(t, err, "failed to push after drain")
+	assert.Greater(t, id, scheduled, "IDs should not be reused")
+}
+
+func TestQueueDedup(t *testing.T) {
+	q, qs := queueSetUp()
+	q.dedup.window = time.Millisecond * 20
+
+	first, err := q.PushWithOptions("value", MessageOptions{DedupKey: "key"})
+	assert.NoError(t, err, "failed to push with a dedup key")
+	again, err := q.PushWithOptions("retried value", MessageOptions{DedupKey: "key"})
+	assert.NoError(t, err, "failed to push a duplicate")
+	assert.Equal(t, first, again, "duplicate should return the original ID")
+	assert.Equal(t, 1, q.Len(), "duplicate should not be enqueued")
+	assert.Equal(t, int64(1), qs.DedupHits, "mismatched dedup hits")
+
+	_, err = q.Pop()
+	assert.NoError(t, err, "failed to pop")
+	again, err = q.PushWithOptions("value", MessageOptions{DedupKey: "key"})
+	assert.NoError(t, err, "failed to push a duplicate of a consumed message")
+	assert.Equal(t, first, again, "keys should be remembered after the message is consumed")
+	assert.Zero(t, q.Len(), "duplicate of a consumed message should not be enqueued")
+
+	other, err := q.PushWithOptions("value", MessageOptions{DedupKey: "other"})
+	assert.NoError(t, err, "failed to push with another dedup key")
+	assert.NotEqual(t, first, other, "different keys should not be deduplicated")
+
+	time.Sleep(q.dedup.window)
+	again, err = q.PushWithOptions("value", MessageOptions{DedupKey: "key"})
+	assert.NoError(t, err, "failed to push after the dedup window")
+	assert.Greater(t, again, other, "keys should be forgotten after the dedup window")
+	assert.Equal(t, int64(2), qs.DedupHits, "mismatched dedup hits")
+	assert.Len(t, q.dedup.ids, 1, "expired keys should be pruned")
+
+	_, err = q.PushWithOptions("value", MessageOptions{DedupKey: strings.Repeat("k", MaxDedupKeyLength+1)})
+	assert.Error(t, err, "pushed with a dedup key which is too long")
+}
+
+func TestQueueReap(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{MinLength: 1, MaxLength: 4, MaxSizeBytes: testQueueDefaultMaxSize}, qs)
+
+	ttl := time.Millisecond * 10
+	for _, v := range []string{"a", "expiring-1", "b", "expiring-2"} {
+		opts := MessageOptions{}
+		if strings.HasPrefix(v, "expiring") {
+			opts.TTL = &ttl
+		}
+		_, err := q.PushWithOptions(v, opts)
+		assert.NoError(t, err, "failed to push", v)
+	}
+	assert.Zero(t, q.Reap(), "reaped messages before they expired")
+
+	time.Sleep(ttl)
+	_, err := q.Push("c")
+	assert.ErrorIs(t, err, ErrQueueFull, "expired messages should take up room until they are reaped")
+
+	assert.Equal(t, 2, q.Reap(), "mismatched reaped count")
+	assert.Equal(t, int64(2), qs.Dropped, "reaped messages should be counted as dropped")
+	assert.Zero(t, qs.Processed, "reaped messages should not be counted as processed")
+	assert.Equal(t, int64(len("ab")), q.SizeBytes(), "reaped messages should release their bytes")
+
+	_, err = q.Push("c")
+	assert.NoError(t, err, "reaping should make room")
+	assert.Equal(t, []string{"a", "b", "c"}, q.Drain(), "reaping should keep the order of live messages")
+}
+
+func TestQueueReapPriority(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{
+		MinLength:    testQueueDefaultMinLen,
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: testQueueDefaultMaxSize,
+		Type:         config.QueueTypePriority,
+	}, qs)
+
+	ttl := time.Millisecond * 10
+	_, err := q.PushWithOptions("urgent", MessageOptions{Priority: 9})
+	assert.NoError(t, err, "failed to push")
+	for _, v := range []string{"low-1", "expiring-1", "low-2", "expiring-2"} {
+		opts := MessageOptions{Priority: 1}
+		if strings.HasPrefix(v, "expiring") {
+			opts.TTL = &ttl
+		}
+		_, err = q.PushWithOptions(v, opts)
+		assert.NoError(t, err, "failed to push", v)
+	}
+	_, err = q.PushWithOptions("expiring-3", MessageOptions{Priority: 5, TTL: &ttl})
+	assert.NoError(t, err, "failed to push")
+
+	time.Sleep(ttl)
+	assert.Equal(t, 3, q.Reap(), "expired messages below the highest priority should be reaped")
+	assert.Equal(t, int64(3), qs.Dropped, "reaped messages should be counted as dropped")
+	assert.Equal(t, []string{"urgent", "low-1", "low-2"}, q.Drain(), "reaping should keep the order of live messages")
+}
+
+func TestQueueBatch(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{MinLength: 1, MaxLength: 4, MaxSizeBytes: testQueueDefaultMaxSize}, qs)
+
+	ids, err := q.PushBatchBytes([]BatchEntry{
+		{Value: []byte("a"), Options: MessageOptions{DedupKey: "a"}},
+		{Value: []byte("b"), Options: MessageOptions{Priority: 1}},
+		{Value: []byte("a again"), Options: MessageOptions{DedupKey: "a"}},
+	})
+	assert.NoError(t, err, "failed to push batch")
+	assert.Equal(t, []int{ids[0], ids[1], ids[0]}, ids, "duplicate within the batch should get the original ID")
+	assert.Equal(t, 2, q.Len(), "duplicate within the batch was enqueued")
+	assert.Equal(t, int64(1), qs.DedupHits, "mismatched dedup hits")
+
+	_, err = q.PushBatchBytes([]BatchEntry{{Value: []byte("c")}, {Value: []byte("d")}, {Value: []byte("e")}})
+	assert.ErrorIs(t, err, ErrQueueFull, "pushed a batch past the max length")
+	assert.Equal(t, 2, q.Len(), "a failed batch should not push anything")
+	_, err = q.PushBatchBytes([]BatchEntry{{Value: []byte("c")}, {Options: MessageOptions{Headers: map[string]string{"": "x"}}}})
+	assert.ErrorIs(t, err, ErrInvalidHeaders, "pushed a batch with invalid headers")
+	assert.Equal(t, 2, q.Len(), "a failed batch should not push anything")
+
+	messages, err := q.PopN(5)
+	assert.NoError(t, err, "failed to pop batch")
+	if assert.Len(t, messages, 2, "should pop every message available, up to n") {
+		assert.Equal(t, "a", string(messages[0].Value), "batch should keep the queue's order")
+		assert.Equal(t, "b", string(messages[1].Value), "batch should keep the queue's order")
+	}
+	_, err = q.PopN(5)
+	assert.ErrorIs(t, err, ErrQueueEmpty, "popped a batch from an empty queue")
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_, _ = q.PushBatch("x", "y")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	messages, err = q.PopNContext(ctx, 1)
+	assert.NoError(t, err, "failed to wait for a batch")
+	assert.Len(t, messages, 1, "popped more than n messages")
+}
+
+func TestQueueOverflow(t *testing.T) {
+	newQueue := func(policy string) (*Queue, *telemetry.QueueStats) {
+		qs := &telemetry.QueueStats{}
+		return New(config.QueueConfig{MinLength: 1, MaxLength: 2, MaxSizeBytes: testQueueDefaultMaxSize, Overflow: policy}, qs), qs
+	}
+
+	q, qs := newQueue(config.OverflowReject)
+	_, err := q.PushBatch("1", "2")
+	assert.NoError(t, err, "failed to fill queue")
+	_, err = q.Push("3")
+	assert.ErrorIs(t, err, ErrQueueFull, "reject policy should fail to push to a full queue")
+	assert.Equal(t, int64(1), qs.OverflowRejected, "mismatched rejected count")
+
+	q, qs = newQueue(config.OverflowDropOldest)
+	_, err = q.PushBatch("1", "2")
+	assert.NoError(t, err, "failed to fill queue")
+	_, err = q.Push("3")
+	assert.NoError(t, err, "drop-oldest policy should make room")
+	_, err = q.PushBatch("4", "5")
+	assert.NoError(t, err, "drop-oldest policy should make room for a batch")
+	assert.Equal(t, []string{"4", "5"}, q.Drain(), "oldest messages should have been dropped")
+	assert.Equal(t, int64(3), qs.OverflowDroppedOldest, "mismatched dropped oldest count")
+	assert.Equal(t, int64(3), qs.Dropped, "evicted messages should count as dropped")
+	_, err = q.PushBatch("1", "2", "3")
+	assert.ErrorIs(t, err, ErrQueueFull, "nothing should be evicted for a batch which can never fit")
+
+	q = New(config.QueueConfig{
+		MinLength:    1,
+		MaxLength:    3,
+		MaxSizeBytes: testQueueDefaultMaxSize,
+		Type:         config.QueueTypePriority,
+		Overflow:     config.OverflowDropOldest,
+	}, &telemetry.QueueStats{})
+	_, err = q.PushWithOptions("urgent", MessageOptions{Priority: 9})
+	assert.NoError(t, err, "failed to push")
+	_, err = q.PushBatch("low-1", "low-2")
+	assert.NoError(t, err, "failed to fill queue")
+	_, err = q.PushWithOptions("normal", MessageOptions{Priority: 5})
+	assert.NoError(t, err, "drop-oldest policy should make room in a priority queue")
+	_, err = q.PushWithOptions(strings.Repeat("x", testQueueDefaultMaxSize+1), MessageOptions{Priority: 1})
+	assert.ErrorIs(t, err, ErrQueueFull, "nothing should be evicted for a message which can never fit")
+	assert.Equal(t, []string{"urgent", "normal", "low-2"}, q.Drain(), "the oldest message of the lowest priority should have been dropped")
+
+	q, qs = newQueue(config.OverflowDropNewest)
+	_, err = q.PushBatch("1", "2")
+	assert.NoError(t, err, "failed to fill queue")
+	id, err := q.Push("3")
+	assert.NoError(t, err, "drop-newest policy should not fail")
+	assert.Equal(t, -1, id, "a dropped message should not get an ID")
+	ids, err := q.PushBatch("4")
+	assert.NoError(t, err, "drop-newest policy should not fail a batch")
+	assert.Equal(t, []int{-1}, ids, "dropped messages should not get IDs")
+	assert.Equal(t, []string{"1", "2"}, q.Drain(), "newest messages should have been dropped")
+	assert.Equal(t, int64(2), qs.OverflowDroppedNewest, "mismatched dropped newest count")
+
+	q, qs = newQueue(config.OverflowBlock)
+	q.publishTimeout = time.Millisecond * 20
+	_, err = q.PushBatch("1", "2")
+	assert.NoError(t, err, "failed to fill queue")
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		_, _ = q.Pop()
+	}()
+	_, err = q.Push("3")
+	assert.NoError(t, err, "block policy should wait for room")
+	_, err = q.Push("4")
+	assert.ErrorIs(t, err, ErrQueueFull, "block policy should give up after the publish timeout")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.PushBytesContext(ctx, []byte("5"), MessageOptions{})
+	assert.ErrorIs(t, err, ErrQueueFull, "block policy should give up once the context is done")
+	assert.Equal(t, int64(2), qs.OverflowBlocked, "mismatched blocked count")
+	assert.Equal(t, int64(2), qs.OverflowRejected, "mismatched rejected count")
+	assert.Equal(t, []string{"2", "3"}, q.Drain())
+}
+
+func TestQueueGroups(t *testing.T) {
+	q, qs := queueSetUp()
+
+	ids := make(map[string]int)
+	for _, msg := range []struct{ value, group string }{
+		{"a1", "a"}, {"a2", "a"}, {"b1", "b"}, {"none", ""}, {"b2", "b"},
+	} {
+		id, err := q.PushWithOptions(msg.value, MessageOptions{Group: msg.group})
+		assert.NoError(t, err, "failed to push to a group")
+		ids[msg.value] = id
+	}
+
+	a1, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "a1", string(a1.Value))
+	assert.Equal(t, "a", a1.Group)
+	b1, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "b1", string(b1.Value), "the next message of a group in flight should be skipped")
+	assert.Equal(t, 2, q.ActiveGroups(), "mismatched active groups")
+	assert.Equal(t, int64(2), qs.ActiveGroups, "mismatched reported active groups")
+
+	val, err := q.Pop()
+	assert.NoError(t, err, "failed to pop")
+	assert.Equal(t, "none", val, "messages without a group should not be held back")
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty, "handed out a message of a group in flight")
+
+	assert.NoError(t, q.Nack(a1.Receipt), "failed to nack")
+	again, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "a1", string(again.Value), "a nacked message should stay first in its group")
+	assert.NoError(t, q.Ack(again.Receipt), "failed to ack")
+	assert.NoError(t, q.Ack(b1.Receipt), "failed to ack")
+	assert.Zero(t, q.ActiveGroups(), "groups should be free once their messages are acked")
+
+	a2, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "a2", string(a2.Value))
+	_, err = q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	_, err = q.PushWithOptions("a3", MessageOptions{Group: "a"})
+	assert.NoError(t, err, "failed to push to a group")
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_ = q.Ack(a2.Receipt)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a3, err := q.PopLeaseContext(ctx, nil)
+	assert.NoError(t, err, "failed to wait for the group to be free")
+	assert.Equal(t, "a3", string(a3.Value))
+	assert.NoError(t, q.Ack(a3.Receipt), "failed to ack")
+	assert.NoError(t, q.Delete(ids["b2"]), "failed to delete leased message")
+	assert.Zero(t, q.ActiveGroups(), "deleting a leased message should free its group")
+
+	for _, msg := range []struct{ value, group string }{{"c1", "c"}, {"c2", "c"}, {"d1", "d"}, {"d2", "d"}} {
+		_, err = q.PushWithOptions(msg.value, MessageOptions{Group: msg.group})
+		assert.NoError(t, err, "failed to push to a group")
+	}
+	c1, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, []string{"d1", "d2"}, q.Drain(), "drained the messages of a group in flight")
+	assert.NoError(t, q.Ack(c1.Receipt), "failed to ack")
+	assert.Equal(t, []string{"c2"}, q.Drain(), "the messages of a free group should be drained")
+
+	_, err = q.PushWithOptions("x", MessageOptions{Group: strings.Repeat("g", MaxGroupKeyLength+1)})
+	assert.Error(t, err, "pushed with a group key which is too long")
+}
+
+func TestQueuePause(t *testing.T) {
+	q, _ := queueSetUp()
+	_, err := q.Push("value")
+	assert.NoError(t, err, "failed to push")
+
+	assert.ErrorIs(t, q.Pause("sideways"), ErrInvalidPauseDirection, "paused an unknown direction")
+	assert.NoError(t, q.Pause(config.PauseConsume), "failed to pause consuming")
+	assert.Equal(t, config.PauseConsume, q.Paused())
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrConsumePaused, "popped from a queue paused for consuming")
+	_, err = q.PopLease(nil)
+	assert.ErrorIs(t, err, ErrConsumePaused, "leased from a queue paused for consuming")
+	assert.Empty(t, q.Drain(), "drained a queue paused for consuming")
+	_, err = q.Push("another")
+	assert.NoError(t, err, "publishing should not be paused")
+
+	assert.NoError(t, q.Pause(config.PausePublish), "failed to pause publishing")
+	assert.Equal(t, config.PauseBoth, q.Paused(), "pausing should add up")
+	_, err = q.Push("rejected")
+	assert.ErrorIs(t, err, ErrPublishPaused, "pushed to a queue paused for publishing")
+	_, err = q.PushBatch("rejected")
+	assert.ErrorIs(t, err, ErrPublishPaused, "pushed a batch to a queue paused for publishing")
+
+	assert.NoError(t, q.Resume(config.PauseConsume), "failed to resume consuming")
+	assert.Equal(t, config.PausePublish, q.Paused())
+	val, err := q.Pop()
+	assert.NoError(t, err, "failed to pop after resuming")
+	assert.Equal(t, "value", val)
+
+	assert.NoError(t, q.Resume(config.PauseBoth), "failed to resume")
+	assert.Empty(t, q.Paused())
+
+	assert.NoError(t, q.Pause(config.PauseConsume), "failed to pause consuming")
+	assert.NoError(t, q.Resume(config.PauseConsume), "failed to resume consuming")
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_ = q.Pause(config.PauseConsume)
+	}()
+	_, _ = q.Pop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = q.PopContext(ctx)
+	assert.ErrorIs(t, err, ErrConsumePaused, "a blocked consumer should give up when the queue is paused")
+
+	direction := config.PausePublish
+	paused := New(config.QueueConfig{MinLength: 1, MaxLength: 10, MaxSizeBytes: 100, Paused: &direction}, &telemetry.QueueStats{})
+	assert.Equal(t, config.PausePublish, paused.Paused(), "queue should start paused as configured")
+}
+
+func TestQueuePurge(t *testing.T) {
+	q, qs := queueSetUp()
+
+	_, err := q.PushBatch("old1", "old2")
+	assert.NoError(t, err, "failed to push")
+	_, err = q.PushWithOptions("old scheduled", MessageOptions{Delay: time.Hour})
+	assert.NoError(t, err, "failed to push scheduled")
+	time.Sleep(time.Millisecond * 20)
+	_, err = q.PushBatch("new1", "new2")
+	assert.NoError(t, err, "failed to push")
+	_, err = q.PushWithOptions("new scheduled", MessageOptions{Delay: time.Hour})
+	assert.NoError(t, err, "failed to push scheduled")
+
+	assert.Equal(t, 3, q.Purge(time.Millisecond*15), "mismatched number of purged old messages")
+	assert.Equal(t, 2, q.Len(), "new messages should be kept")
+	assert.Equal(t, 1, q.Scheduled(), "new scheduled messages should be kept")
+	assert.Equal(t, int64(3), qs.Purged, "mismatched purged count")
+	assert.Zero(t, qs.Dropped, "purged messages should not count as dropped")
+
+	lease, err := q.PopLease(nil)
+	assert.NoError(t, err, "failed to lease")
+	assert.Equal(t, "new1", string(lease.Value), "order should be kept after purging")
+	assert.Equal(t, 2, q.Purge(0), "mismatched number of purged messages")
+	assert.Zero(t, q.Len(), "visible messages left after purging")
+	assert.Zero(t, q.Scheduled(), "scheduled messages left after purging")
+	assert.Equal(t, int64(len(lease.Value)), q.SizeBytes(), "only the message in flight should take up room")
+	assert.NoError(t, q.Ack(lease.Receipt), "messages in flight should not be purged")
+	assert.Zero(t, q.SizeBytes(), "size not released on purge")
+}
+
+func TestQueuePurgePriority(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{
+		MinLength:    testQueueDefaultMinLen,
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: testQueueDefaultMaxSize,
+		Type:         config.QueueTypePriority,
+	}, qs)
+
+	push := func(value string, priority int) {
+		_, err := q.PushWithOptions(value, MessageOptions{Priority: priority})
+		assert.NoError(t, err, "failed to push", value)
+	}
+	push("old-p1", 1)
+	push("old-p5", 5)
+	push("old-p1 again", 1)
+	time.Sleep(time.Millisecond * 20)
+	push("new-p9", 9)
+	push("new-p1", 1)
+
+	assert.Equal(t, 3, q.Purge(time.Millisecond*15), "old messages below the highest priority should be purged")
+	assert.Equal(t, int64(3), qs.Purged, "mismatched purged count")
+	assert.Equal(t, []string{"new-p9", "new-p1"}, q.Drain(), "new messages should be kept in order")
+}
+
+func TestQueueReconfigure(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	cfg := config.QueueConfig{MinLength: 1, MaxLength: 3, MaxSizeBytes: testQueueDefaultMaxSize}
+	q := New(cfg, qs)
+
+	_, err := q.PushBatch("1", "2", "3")
+	assert.NoError(t, err, "failed to fill queue")
+	_, err = q.Push("4")
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	cfg.MaxLength = 4
+	cfg.TTL = util.Duration(time.Second)
+	pauseConsume, resume := config.PauseConsume, ""
+	cfg.Paused = &pauseConsume
+	assert.NoError(t, q.Reconfigure(cfg, false), "failed to raise the max length")
+	_, err = q.Push("4")
+	assert.NoError(t, err, "failed to push after raising the max length")
+	assert.Equal(t, config.PauseConsume, q.Paused(), "pause state should be reconfigured")
+	assert.Equal(t, time.Second, q.factory.defaultTTL, "default ttl should be reconfigured")
+
+	cfg.MaxLength = 2
+	cfg.Paused = &resume
+	assert.ErrorIs(t, q.Reconfigure(cfg, false), ErrLimitBelowContents, "shrunk the max length below the queue's length")
+	cfg.MaxLength = 4
+	cfg.MaxSizeBytes = 2
+	assert.ErrorIs(t, q.Reconfigure(cfg, false), ErrLimitBelowContents, "shrunk the max size below the queue's size")
+	assert.Equal(t, config.PauseConsume, q.Paused(), "a rejected change should not be applied")
+
+	cfg.MaxLength = 2
+	cfg.MaxSizeBytes = testQueueDefaultMaxSize
+	assert.NoError(t, q.Reconfigure(cfg, true), "failed to force a shrink")
+	assert.Equal(t, 4, q.Len(), "a forced shrink should keep the messages")
+	_, err = q.Push("5")
+	assert.ErrorIs(t, err, ErrQueueFull, "pushed to a queue over its limit")
+	assert.Empty(t, q.Paused(), "an empty pause should resume the queue")
+
+	assert.NoError(t, q.Pause(config.PausePublish), "failed to pause publishing")
+	cfg.Paused = nil
+	assert.NoError(t, q.Reconfigure(cfg, true), "failed to reconfigure")
+	assert.Equal(t, config.PausePublish, q.Paused(), "a config without a pause should keep the queue's")
+}
+
+func TestQueueSharded(t *testing.T) {
+	qs := &telemetry.QueueStats{}
+	q := New(config.QueueConfig{
+		Type:         config.QueueTypeSharded,
+		Shards:       4,
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: testQueueDefaultMaxSize,
+	}, qs)
+
+	const producers, perProducer = 8, 200
+	size := int64(0)
+	done := make(chan struct{})
+	for p := 0; p < producers; p++ {
+		for i := 0; i < perProducer; i++ {
+			size += int64(len(strconv.Itoa(p) + "-" + strconv.Itoa(i)))
+		}
+		go func(p int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < perProducer; i++ {
+				_, err := q.Push(strconv.Itoa(p) + "-" + strconv.Itoa(i))
+				assert.NoError(t, err, "failed to push to sharded queue")
+			}
+		}(p)
+	}
+	for p := 0; p < producers; p++ {
+		<-done
+	}
+	assert.Equal(t, size, q.SizeBytes(), "pending pushes should count against the byte budget")
+	assert.Equal(t, producers*perProducer, q.Len(), "mismatched length")
+	assert.Equal(t, size, qs.SizeBytes, "collected pushes should be counted in the stats")
+
+	infos := q.Browse(producers * perProducer)
+	assert.Len(t, infos, producers*perProducer, "browse should see every pending push")
+	for i := 1; i < len(infos); i++ {
+		assert.Greater(t, infos[i].ID, infos[i-1].ID, "messages should be in publish order")
+	}
+	next := make([]int, producers)
+	for n := 0; n < producers*perProducer; n++ {
+		msg, err := q.PopMessage()
+		if !assert.NoError(t, err, "failed to pop from sharded queue") {
+			return
+		}
+		parts := strings.SplitN(string(msg.Value), "-", 2)
+		p, _ := strconv.Atoi(parts[0])
+		assert.Equal(t, strconv.Itoa(next[p]), parts[1], "messages of one producer should stay in order")
+		next[p]++
+	}
+	_, err := q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty)
+	assert.Zero(t, q.SizeBytes(), "popped messages should release their bytes")
+
+	// blocked consumers are woken by producers which only hold the read lock
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	consumed := make(chan int)
+	for c := 0; c < producers; c++ {
+		go func() {
+			n := 0
+			for ; n < perProducer; n++ {
+				if _, err := q.PopContext(ctx); err != nil {
+					break
+				}
+			}
+			consumed <- n
+		}()
+	}
+	for p := 0; p < producers; p++ {
+		go func() {
+			for i := 0; i < perProducer; i++ {
+				_, _ = q.Push("x")
+			}
+		}()
+	}
+	total := 0
+	for c := 0; c < producers; c++ {
+		total += <-consumed
+	}
+	assert.Equal(t, producers*perProducer, total, "every message should be consumed exactly once")
+}
+
+func TestQueueShardedSemantics(t *testing.T) {
+	q := New(config.QueueConfig{
+		Type:         config.QueueTypeSharded,
+		MaxLength:    3,
+		MaxSizeBytes: testQueueDefaultMaxSize,
+		TTL:          util.Duration(time.Millisecond * 20),
+	}, &telemetry.QueueStats{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	popped := make(chan string)
+	go func() {
+		val, err := q.PopContext(ctx)
+		assert.NoError(t, err, "blocked pop failed")
+		popped <- val
+	}()
+	time.Sleep(time.Millisecond * 10)
+	_, err := q.Push("wake")
+	assert.NoError(t, err, "failed to push")
+	assert.Equal(t, "wake", <-popped, "a push should wake a blocked consumer")
+
+	_, err = q.PushBatch("1", "2")
+	assert.NoError(t, err, "failed to push batch")
+	_, err = q.Push("3")
+	assert.NoError(t, err, "failed to push")
+	_, err = q.Push("4")
+	assert.ErrorIs(t, err, ErrQueueFull, "pushed past the max length")
+
+	time.Sleep(time.Millisecond * 20)
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty, "expired messages should not be popped")
+
+	delay := time.Hour
+	_, err = q.PushWithOptions("later", MessageOptions{Delay: delay})
+	assert.NoError(t, err, "failed to push a delayed message")
+	assert.Equal(t, 1, q.Scheduled(), "a delayed message should be scheduled")
+	first, err := q.PushWithOptions("once", MessageOptions{DedupKey: "k"})
+	assert.NoError(t, err, "failed to push with a dedup key")
+	again, err := q.PushWithOptions("once", MessageOptions{DedupKey: "k"})
+	assert.NoError(t, err, "failed to push a duplicate")
+	assert.Equal(t, first, again, "duplicates should be recognised by a sharded queue")
+	assert.Equal(t, 1, q.Len())
+}
+
+func BenchmarkQueueMPMC(b *testing.B) {
+	for _, queueType := range []string{config.QueueTypeFIFO, config.QueueTypeSharded} {
+		b.Run(queueType, func(b *testing.B) {
+			q := New(config.QueueConfig{
+				Type:         queueType,
+				MinLength:    testQueueDefaultMinLen,
+				MaxLength:    testQueueDefaultMaxLen,
+				MaxSizeBytes: testQueueDefaultMaxLen * 16,
+			}, &telemetry.QueueStats{})
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%2 == 0 {
+						_, _ = q.Push("0123456789abcdef")
+					} else {
+						_, _ = q.Pop()
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestQueueTyped(t *testing.T) {
+	type order struct {
+		ID    int
+		Items []string
+	}
+	qs := &telemetry.QueueStats{}
+	q := NewTyped[order](config.QueueConfig{
+		MaxLength:    testQueueDefaultMaxLen,
+		MaxSizeBytes: 100,
+	}, qs, func(o order) int64 { return int64(40 * len(o.Items)) })
+
+	_, err := q.Push(order{ID: 1, Items: []string{"a"}})
+	assert.NoError(t, err, "failed to push typed value")
+	_, err = q.PushBatch(order{ID: 2}, order{ID: 3, Items: []string{"b"}})
+	assert.NoError(t, err, "failed to push typed batch")
+	assert.Equal(t, int64(80), q.Queue().SizeBytes(), "values should count their reported size")
+	_, err = q.Push(order{ID: 4, Items: []string{"c"}})
+	assert.ErrorIs(t, err, ErrQueueTooLarge, "pushed past the byte budget")
+
+	o, err := q.Pop()
+	assert.NoError(t, err, "failed to pop typed value")
+	assert.Equal(t, order{ID: 1, Items: []string{"a"}}, o)
+	orders, err := q.PopN(5)
+	assert.NoError(t, err, "failed to pop typed batch")
+	assert.Equal(t, []order{{ID: 2}, {ID: 3, Items: []string{"b"}}}, orders)
+	assert.Equal(t, int64(3), qs.Processed, "typed pops should count as processed")
+	assert.Zero(t, q.Queue().SizeBytes(), "popped values should release their size")
+
+	ttl := time.Millisecond
+	_, err = q.PushWithTTL(order{ID: 5}, &ttl)
+	assert.NoError(t, err, "failed to push typed value with a ttl")
+	time.Sleep(ttl * 2)
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrQueueEmpty, "expired typed value was popped")
+
+	_, err = q.Queue().Push("not an order")
+	assert.NoError(t, err, "failed to push")
+	_, err = q.Pop()
+	assert.ErrorIs(t, err, ErrUnexpectedType, "popped a string as an order")
+
+	texts := AsTyped[string](q.Queue(), nil)
+	_, err = texts.Push("plain")
+	assert.NoError(t, err, "failed to push typed string")
+	val, err := q.Queue().Pop()
+	assert.NoError(t, err, "failed to pop typed string as a string")
+	assert.Equal(t, "plain", val, "typed strings should be regular payloads")
+
+	values := AsTyped[any](q.Queue(), nil)
+	for _, value := range []any{"text", []byte("bytes"), order{ID: 6, Items: []string{"d"}}} {
+		_, err = values.Push(value)
+		assert.NoError(t, err, "failed to push %T as any", value)
+		v, err := values.Pop()
+		assert.NoError(t, err, "failed to pop %T as any", value)
+		assert.Equal(t, value, v, "%T should pop as it was pushed", value)
+	}
+	_, err = values.Push("counted")
+	assert.NoError(t, err, "failed to push a string as any")
+	assert.Equal(t, int64(len("counted")), q.Queue().SizeBytes(), "strings pushed as any should count their bytes")
+	val, err = q.Queue().Pop()
+	assert.NoError(t, err, "failed to pop a string pushed as any")
+	assert.Equal(t, "counted", val, "strings pushed as any should be regular payloads")
+	_, err = q.Queue().Push("raw")
+	assert.NoError(t, err, "failed to push")
+	v, err := values.Pop()
+	assert.NoError(t, err, "failed to pop a payload as any")
+	assert.Equal(t, "raw", v, "payloads should pop as strings through any")
+}
@@ -0,0 +1,34 @@
+package queue
+
+import "time"
+
+// Reap removes every expired message from the queue, dropping or dead-lettering them as a consume would,
+// and forgets expired deduplication keys. Returns how many messages were removed.
+// Unlike Pop, it does not wait for a consumer to come along, so expired messages stop taking up room.
+func (q *Queue) Reap() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.expireLeases()
+	q.promote()
+	q.dedup.prune(time.Now())
+
+	return q.sweep((*item).Expired, func(item_ item) {
+		q.discard(item_, DeadLetterExpired)
+	})
+}
+
+// sweep takes every visible item which matches out of the store, in pop order, and hands it to take.
+// The items which are left keep their order. Returns how many items were taken.
+func (q *Queue) sweep(match func(*item) bool, take func(item)) int {
+	taken := 0
+	for i := 0; i < q.len(); {
+		if !match(q.items.at(i)) {
+			i++
+			continue
+		}
+		take(q.take(i))
+		taken++
+	}
+	return taken
+}
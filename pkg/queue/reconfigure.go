@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"fmt"
+
+	"yambol/config"
+)
+
+// Reconfigure applies new limits and defaults to the live queue without touching its messages.
+// New defaults, such as the TTL, only apply to messages pushed from now on. Limits below what the queue
+// currently holds are rejected with ErrLimitBelowContents, unless force is set, in which case the queue
+// keeps its messages and refuses new ones until it is back under its limits.
+// The queue type and min length cannot be changed, so they are ignored, and the pause is only changed if it is set.
+func (q *Queue) Reconfigure(cfg config.QueueConfig, force bool) error {
+	consumePaused, publishPaused, err := pauseDirections(cfg.PausedDirection())
+	if cfg.PausedDirection() != "" && err != nil {
+		return err
+	}
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.promote()
+	if !force {
+		if count := q.len64() + int64(len(q.leases)+len(q.scheduled)); count > cfg.MaxLength {
+			return fmt.Errorf("%w: the queue holds %d messages, more than a max length of %d", ErrLimitBelowContents, count, cfg.MaxLength)
+		}
+		if q.sizeBytes > cfg.MaxSizeBytes {
+			return fmt.Errorf("%w: the queue holds %d bytes, more than a max size of %d", ErrLimitBelowContents, q.sizeBytes, cfg.MaxSizeBytes)
+		}
+	}
+
+	q.maxLen = cfg.MaxLength
+	q.maxSizeBytes = cfg.MaxSizeBytes
+	q.factory.defaultTTL = cfg.TTLDuration()
+	q.visibility = visibilityOrDefault(cfg.VisibilityTimeoutDuration())
+	q.maxDelivery = cfg.MaxDeliveries
+	q.dedup.window = dedupWindowOrDefault(cfg.DedupWindowDuration())
+	q.overflow = cfg.Overflow
+	q.publishTimeout = publishTimeoutOrDefault(cfg.PublishTimeoutDuration())
+	if cfg.Paused != nil {
+		q.consumePaused = consumePaused
+		q.publishPaused = publishPaused
+	}
+	// raised limits may make room for blocked publishers, and a pause change affects blocked consumers
+	q.notifyRoom()
+	q.notify()
+	return nil
+}
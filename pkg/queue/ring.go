@@ -0,0 +1,128 @@
+package queue
+
+// ring is a growable circular buffer of items.
+// It doubles when full and halves when mostly empty, but never drops below its floor capacity.
+type ring struct {
+	buf   []item
+	head  int
+	size  int
+	floor int
+}
+
+func newRing(floor int) ring {
+	if floor <= 0 {
+		floor = 1
+	}
+	return ring{
+		buf:   make([]item, floor),
+		floor: floor,
+	}
+}
+
+func (r *ring) len() int {
+	return r.size
+}
+
+func (r *ring) cap() int {
+	return len(r.buf)
+}
+
+// index maps a logical position (0 being the oldest item) to a position in buf
+func (r *ring) index(i int) int {
+	i += r.head
+	if i >= len(r.buf) {
+		i -= len(r.buf)
+	}
+	return i
+}
+
+// at returns a pointer to the i-th oldest item. The pointer is only valid until the next push or pop.
+func (r *ring) at(i int) *item {
+	return &r.buf[r.index(i)]
+}
+
+func (r *ring) push(item_ item) {
+	if r.size == len(r.buf) {
+		r.resize(len(r.buf) * 2)
+	}
+	r.buf[r.index(r.size)] = item_
+	r.size++
+}
+
+// pushFront puts the item before all others, so it is the next one to be popped
+func (r *ring) pushFront(item_ item) {
+	if r.size == len(r.buf) {
+		r.resize(len(r.buf) * 2)
+	}
+	r.head--
+	if r.head < 0 {
+		r.head += len(r.buf)
+	}
+	r.buf[r.head] = item_
+	r.size++
+}
+
+func (r *ring) pop() item {
+	item_ := r.buf[r.head]
+	r.buf[r.head] = item{} // release the value for the GC
+	r.head = r.index(1)
+	r.size--
+	if r.size == 0 {
+		r.head = 0
+	}
+	if half := len(r.buf) / 2; half >= r.floor && r.size < len(r.buf)/4 {
+		r.resize(half)
+	}
+	return item_
+}
+
+// remove takes out the i-th oldest item, closing the gap by shifting whichever side of it is shorter
+func (r *ring) remove(i int) item {
+	item_ := *r.at(i)
+	if i < r.size/2 {
+		for j := i; j > 0; j-- {
+			*r.at(j) = *r.at(j - 1)
+		}
+		*r.at(0) = item{}
+		r.head = r.index(1)
+	} else {
+		for j := i; j < r.size-1; j++ {
+			*r.at(j) = *r.at(j + 1)
+		}
+		*r.at(r.size - 1) = item{}
+	}
+	r.size--
+	if r.size == 0 {
+		r.head = 0
+	}
+	return item_
+}
+
+// oldest is the item which was pushed first, which is also the next one to be popped
+func (r *ring) oldest() int {
+	return 0
+}
+
+func (r *ring) resize(capacity int) {
+	buf := make([]item, capacity)
+	if r.head+r.size <= len(r.buf) {
+		copy(buf, r.buf[r.head:r.head+r.size])
+	} else {
+		n := copy(buf, r.buf[r.head:])
+		copy(buf[n:], r.buf[:r.size-n])
+	}
+	r.buf = buf
+	r.head = 0
+}
+
+func (r *ring) clear() {
+	if len(r.buf) == r.floor {
+		for i := 0; i < r.size; i++ {
+			*r.at(i) = item{}
+		}
+	} else {
+		r.buf = make([]item, r.floor)
+	}
+	r.head = 0
+	r.size = 0
+}
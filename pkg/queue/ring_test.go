@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingWrapAround(t *testing.T) {
+	r := newRing(4)
+	for i := 0; i < 3; i++ {
+		r.push(item{value: []byte(strconv.Itoa(i))})
+	}
+	assert.Equal(t, "0", string(r.pop().value))
+	assert.Equal(t, "1", string(r.pop().value))
+
+	// head is now at 2, so these wrap around the end of the buffer
+	for i := 3; i < 6; i++ {
+		r.push(item{value: []byte(strconv.Itoa(i))})
+	}
+	assert.Equal(t, 4, r.cap(), "ring should not have grown")
+	assert.Equal(t, 4, r.len())
+	for i := 0; i < r.len(); i++ {
+		assert.Equal(t, strconv.Itoa(i+2), string(r.at(i).value), "mismatched value at", i)
+	}
+	for i := 2; i < 6; i++ {
+		assert.Equal(t, strconv.Itoa(i), string(r.pop().value), "mismatched popped value")
+	}
+	assert.Zero(t, r.len())
+}
+
+func TestRingGrowShrink(t *testing.T) {
+	floor := 4
+	r := newRing(floor)
+	r.push(item{value: []byte("x")})
+	r.pop()
+
+	n := 100
+	for i := 0; i < n; i++ {
+		r.push(item{value: []byte(strconv.Itoa(i))})
+	}
+	assert.Equal(t, n, r.len())
+	assert.GreaterOrEqual(t, r.cap(), n, "ring did not grow")
+
+	for i := 0; i < n; i++ {
+		assert.Equal(t, strconv.Itoa(i), string(r.pop().value), "FIFO order broken after growing")
+	}
+	assert.Equal(t, floor, r.cap(), "ring did not shrink back to its floor")
+
+	for i := 0; i < n; i++ {
+		r.push(item{value: []byte(strconv.Itoa(i))})
+	}
+	r.clear()
+	assert.Zero(t, r.len())
+	assert.Equal(t, floor, r.cap(), "clear should reset capacity to the floor")
+}
+
+func TestRingRemove(t *testing.T) {
+	r := newRing(8)
+	r.push(item{value: []byte("x")})
+	r.pop()
+	for i := 0; i < 7; i++ {
+		r.push(item{value: []byte(strconv.Itoa(i))})
+	}
+
+	assert.Equal(t, "1", string(r.remove(1).value), "removed the wrong item near the head")
+	assert.Equal(t, "5", string(r.remove(4).value), "removed the wrong item near the tail")
+	assert.Equal(t, 5, r.len())
+	for _, expected := range []string{"0", "2", "3", "4", "6"} {
+		assert.Equal(t, expected, string(r.pop().value), "order broken after removing")
+	}
+}
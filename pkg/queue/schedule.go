@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// schedule is a min-heap of items which are not visible yet, ordered by when they become visible
+type schedule []item
+
+func (s schedule) Len() int {
+	return len(s)
+}
+
+func (s schedule) Less(i, j int) bool {
+	if s[i].ts.Equal(s[j].ts) {
+		return s[i].enqueued.Before(s[j].enqueued)
+	}
+	return s[i].ts.Before(s[j].ts)
+}
+
+func (s schedule) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s *schedule) Push(x any) {
+	*s = append(*s, x.(item))
+}
+
+func (s *schedule) Pop() any {
+	old := *s
+	n := len(old)
+	item_ := old[n-1]
+	old[n-1] = item{} // release the value for the GC
+	*s = old[:n-1]
+	return item_
+}
+
+// Scheduled returns how many messages are waiting for their delivery time
+func (q *Queue) Scheduled() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.promote()
+	return len(q.scheduled)
+}
+
+// promote makes every scheduled item which is due visible, in the order they became due,
+// after collecting whatever was pushed into the inbox of a sharded queue
+func (q *Queue) promote() {
+	q.collect()
+	if len(q.scheduled) == 0 {
+		return
+	}
+	now := time.Now()
+	promoted := false
+	for len(q.scheduled) > 0 && !q.scheduled[0].ts.After(now) {
+		q.items.push(heap.Pop(&q.scheduled).(item))
+		promoted = true
+	}
+	if promoted {
+		q.stats.SetScheduled(int64(len(q.scheduled)))
+		q.notify()
+	}
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inbox takes the pushes of a sharded queue. Producers only hold the queue's read lock and take turns
+// over the shards, so they only wait for each other when they land on the same shard. Each shard stamps
+// its items with IDs under its own lock, which keeps every shard in ID order, and whoever takes the
+// queue's write lock merges the shards into the store in ID order, which is publish order, so the queue
+// stays FIFO.
+type inbox struct {
+	shards []inboxShard
+	heads  []int
+	turn   atomic.Uint64
+	len    atomic.Int64
+	bytes  atomic.Int64
+}
+
+type inboxShard struct {
+	mx    sync.Mutex
+	items []item
+	_     [32]byte // keeps neighbouring shards off each other's cache line
+}
+
+func newInbox(shards int64) *inbox {
+	if shards <= 0 {
+		shards = int64(runtime.GOMAXPROCS(0))
+	}
+	return &inbox{
+		shards: make([]inboxShard, shards),
+		heads:  make([]int, shards),
+	}
+}
+
+// reserve counts an item of size bytes against the queue's limits, given what the queue already holds.
+// Producers reserve concurrently, so one may be turned away while another is about to back out,
+// which is fine since a producer which is turned away falls back to a regular push.
+func (in *inbox) reserve(held, maxLen, heldBytes, maxBytes, size int64) bool {
+	if held+in.len.Add(1) > maxLen {
+		in.len.Add(-1)
+		return false
+	}
+	if heldBytes+in.bytes.Add(size) > maxBytes {
+		in.len.Add(-1)
+		in.bytes.Add(-size)
+		return false
+	}
+	return true
+}
+
+// push creates the item in the next shard and returns its ID
+func (in *inbox) push(factory *itemFactory, value []byte, opts MessageOptions) int {
+	shard := &in.shards[in.turn.Add(1)%uint64(len(in.shards))]
+	shard.mx.Lock()
+	defer shard.mx.Unlock()
+	item_ := factory.newItem(value, opts)
+	shard.items = append(shard.items, item_)
+	return item_.uid
+}
+
+// pendingLen returns how many items wait in the inbox, which is always zero for queues that are not sharded
+func (in *inbox) pendingLen() int64 {
+	if in == nil {
+		return 0
+	}
+	return in.len.Load()
+}
+
+func (in *inbox) pendingBytes() int64 {
+	if in == nil {
+		return 0
+	}
+	return in.bytes.Load()
+}
+
+// collect moves the items waiting in the inbox of a sharded queue into its store. Needs the write lock,
+// which keeps producers out of the shards, so the shards' own locks are not needed.
+func (q *Queue) collect() {
+	if q.inbox.pendingLen() == 0 {
+		return
+	}
+	in := q.inbox
+	for {
+		next := -1
+		for i := range in.shards {
+			if in.heads[i] == len(in.shards[i].items) {
+				continue
+			}
+			if next < 0 || in.shards[i].items[in.heads[i]].uid < in.shards[next].items[in.heads[next]].uid {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		items := in.shards[next].items
+		q.items.push(items[in.heads[next]])
+		items[in.heads[next]] = item{} // release the value for the GC
+		in.heads[next]++
+	}
+	for i := range in.shards {
+		in.shards[i].items = in.shards[i].items[:0]
+		in.heads[i] = 0
+	}
+	in.len.Store(0)
+	q.setSize(q.sizeBytes + in.bytes.Swap(0))
+}
+
+// pushShared is the fast path of a push into a sharded queue, which only holds the read lock.
+// Anything it cannot settle on its own, like a full or paused queue, deduplication or a delay,
+// is left to the regular push by returning false.
+func (q *Queue) pushShared(value []byte, opts MessageOptions) (int, bool) {
+	if opts.DedupKey != "" {
+		return -1, false
+	}
+	if now := time.Now(); opts.visibleAt(now).After(now) {
+		return -1, false
+	}
+	q.mx.RLock()
+	defer q.mx.RUnlock()
+
+	if q.publishPaused {
+		return -1, false
+	}
+	held := q.len64() + int64(len(q.leases)+len(q.scheduled))
+	if !q.inbox.reserve(held, q.maxLen, q.sizeBytes, q.maxSizeBytes, opts.payloadSize(value)) {
+		return -1, false
+	}
+	id := q.inbox.push(&q.factory, value, opts)
+	q.notifyShared()
+	return id, true
+}
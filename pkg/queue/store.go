@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"sort"
+
+	"yambol/config"
+)
+
+// store holds the visible items of a queue, in the order they will be popped
+type store interface {
+	len() int
+	// at returns a pointer to the i-th item in pop order. The pointer is only valid until the next push or pop.
+	at(i int) *item
+	push(item_ item)
+	// pushFront puts the item back where it will be the next one popped among its peers
+	pushFront(item_ item)
+	pop() item
+	// remove takes out the i-th item in pop order
+	remove(i int) item
+	// oldest returns the index in pop order of the item the drop-oldest overflow policy evicts first
+	oldest() int
+	clear()
+}
+
+func newStore(queueType string, floor int) store {
+	switch queueType {
+	case config.QueueTypePriority:
+		return newPriorityStore(floor)
+	default:
+		r := newRing(floor)
+		return &r
+	}
+}
+
+// maxIdleLevels caps how many emptied priority levels, besides the default one, keep their ring for reuse
+const maxIdleLevels = 8
+
+// priorityStore pops items with the highest priority first, and in FIFO order within the same priority.
+// Each priority level is a ring of its own. Emptied levels keep their ring, so a queue which keeps
+// draining to empty does not allocate a new one on every push, but only the default level and up to
+// maxIdleLevels others are kept.
+type priorityStore struct {
+	levels map[int]*ring
+	order  []int // priorities with at least one item, highest first
+	idle   int   // emptied levels other than the default one which still hold a ring
+	floor  int
+	size   int
+}
+
+func newPriorityStore(floor int) *priorityStore {
+	return &priorityStore{
+		levels: make(map[int]*ring),
+		order:  make([]int, 0),
+		floor:  floor,
+	}
+}
+
+func (s *priorityStore) len() int {
+	return s.size
+}
+
+func (s *priorityStore) at(i int) *item {
+	for _, priority := range s.order {
+		level := s.levels[priority]
+		if i < level.len() {
+			return level.at(i)
+		}
+		i -= level.len()
+	}
+	return nil
+}
+
+// level returns the ring of a priority, putting it in the pop order if it was empty
+func (s *priorityStore) level(priority int) *ring {
+	level, ok := s.levels[priority]
+	if ok && level.len() > 0 {
+		return level
+	}
+	if !ok {
+		// the default level is likely the busiest, so it gets the queue's floor capacity
+		floor := 1
+		if priority == 0 {
+			floor = s.floor
+		}
+		r := newRing(floor)
+		level = &r
+		s.levels[priority] = level
+	} else if priority != 0 {
+		s.idle--
+	}
+	i := sort.Search(len(s.order), func(i int) bool { return s.order[i] < priority })
+	s.order = append(s.order, 0)
+	copy(s.order[i+1:], s.order[i:])
+	s.order[i] = priority
+	return level
+}
+
+// retire takes the n-th level in pop order, which was emptied, out of the order
+func (s *priorityStore) retire(n int) {
+	priority := s.order[n]
+	s.order = append(s.order[:n], s.order[n+1:]...)
+	switch {
+	case priority == 0:
+	case s.idle < maxIdleLevels:
+		s.idle++
+	default:
+		delete(s.levels, priority)
+	}
+}
+
+func (s *priorityStore) push(item_ item) {
+	s.level(item_.priority).push(item_)
+	s.size++
+}
+
+func (s *priorityStore) pushFront(item_ item) {
+	s.level(item_.priority).pushFront(item_)
+	s.size++
+}
+
+func (s *priorityStore) pop() item {
+	level := s.levels[s.order[0]]
+	item_ := level.pop()
+	s.size--
+	if level.len() == 0 {
+		s.retire(0)
+	}
+	return item_
+}
+
+func (s *priorityStore) remove(i int) item {
+	for n, priority := range s.order {
+		level := s.levels[priority]
+		if i >= level.len() {
+			i -= level.len()
+			continue
+		}
+		item_ := level.remove(i)
+		s.size--
+		if level.len() == 0 {
+			s.retire(n)
+		}
+		return item_
+	}
+	panic("priorityStore: index out of range")
+}
+
+// oldest is the oldest item of the lowest priority, which is the first one of the last level
+func (s *priorityStore) oldest() int {
+	return s.size - s.levels[s.order[len(s.order)-1]].len()
+}
+
+func (s *priorityStore) clear() {
+	for len(s.order) > 0 {
+		s.levels[s.order[0]].clear()
+		s.retire(0)
+	}
+	s.size = 0
+}
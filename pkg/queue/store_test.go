@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityStoreOrder(t *testing.T) {
+	s := newPriorityStore(4)
+	for _, it := range []item{
+		{value: []byte("low-1"), priority: -1},
+		{value: []byte("default-1")},
+		{value: []byte("high-1"), priority: 5},
+		{value: []byte("default-2")},
+		{value: []byte("high-2"), priority: 5},
+		{value: []byte("low-2"), priority: -1},
+	} {
+		s.push(it)
+	}
+	expected := []string{"high-1", "high-2", "default-1", "default-2", "low-1", "low-2"}
+	assert.Equal(t, len(expected), s.len())
+	for i, value := range expected {
+		assert.Equal(t, value, string(s.at(i).value), "mismatched value at", i)
+	}
+	assert.Nil(t, s.at(len(expected)), "expected nothing past the end")
+
+	assert.Equal(t, "high-1", string(s.pop().value))
+	s.pushFront(item{value: []byte("high-1"), priority: 5})
+	s.pushFront(item{value: []byte("low-0"), priority: -1})
+	expected = []string{"high-1", "high-2", "default-1", "default-2", "low-0", "low-1", "low-2"}
+	for i, value := range expected {
+		assert.Equal(t, value, string(s.pop().value), "mismatched popped value", i)
+	}
+	assert.Zero(t, s.len())
+	assert.Empty(t, s.order, "empty levels should leave the pop order")
+}
+
+func TestPriorityStoreRemove(t *testing.T) {
+	s := newPriorityStore(4)
+	for _, it := range []item{
+		{value: []byte("default"), priority: 0},
+		{value: []byte("high"), priority: 5},
+		{value: []byte("low"), priority: -1},
+	} {
+		s.push(it)
+	}
+	assert.Equal(t, "high", string(s.remove(0).value))
+	assert.Equal(t, "low", string(s.remove(1).value))
+	assert.Equal(t, []int{0}, s.order, "empty levels should leave the pop order")
+	assert.Equal(t, "default", string(s.pop().value))
+	assert.Zero(t, s.len())
+}
+
+func TestPriorityStoreReusesLevels(t *testing.T) {
+	s := newPriorityStore(4)
+	for _, priority := range []int{0, 5} {
+		s.push(item{priority: priority})
+		s.pop()
+		allocs := testing.AllocsPerRun(100, func() {
+			s.push(item{priority: priority})
+			s.pop()
+		})
+		assert.Zero(t, allocs, "an emptied level should keep its ring, at priority %d", priority)
+	}
+
+	for priority := 1; priority <= maxIdleLevels+4; priority++ {
+		s.push(item{priority: priority})
+	}
+	s.clear()
+	assert.Zero(t, s.len())
+	assert.Empty(t, s.order, "cleared levels should leave the pop order")
+	assert.Len(t, s.levels, maxIdleLevels+1, "only the default level and maxIdleLevels others should be kept")
+	assert.Contains(t, s.levels, 0, "the default level should always be kept")
+
+	s.push(item{value: []byte("low"), priority: -1})
+	s.push(item{value: []byte("high"), priority: 3})
+	assert.Equal(t, "high", string(s.pop().value))
+	assert.Equal(t, "low", string(s.pop().value))
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"yambol/config"
+	"yambol/pkg/telemetry"
+)
+
+// Typed is a view of a queue which pushes and pops values of type T as they are, without serializing them,
+// for services which embed the queue in-process. Strings and byte slices are stored as regular payloads,
+// so they are interchangeable with the rest of the queue's API. A Typed of an interface type, such as
+// Typed[any], also keeps them as they are, so they pop as the type they were pushed as. Other values are
+// kept as they are, and count the bytes their size function reports against the queue's byte budget.
+// Every other behaviour, such as TTLs, limits, overflow policies and stats, is the queue's own.
+type Typed[T any] struct {
+	q    *Queue
+	size func(T) int64
+}
+
+// NewTyped creates a queue of values of type T. A nil size counts values which are neither strings
+// nor byte slices as zero bytes, so only the max length limits how many of them the queue holds.
+func NewTyped[T any](cfg config.QueueConfig, stats *telemetry.QueueStats, size func(T) int64) *Typed[T] {
+	return AsTyped(New(cfg, stats), size)
+}
+
+// AsTyped wraps an existing queue. Values pushed through it are handed out as usual by the queue itself,
+// except that values which are not strings or byte slices come out with an empty payload.
+func AsTyped[T any](q *Queue, size func(T) int64) *Typed[T] {
+	return &Typed[T]{q: q, size: size}
+}
+
+// Queue returns the underlying queue, for everything Typed does not cover
+func (t *Typed[T]) Queue() *Queue {
+	return t.q
+}
+
+func (t *Typed[T]) Len() int {
+	return t.q.Len()
+}
+
+func (t *Typed[T]) Push(value T) (int, error) {
+	return t.PushContext(context.Background(), value, MessageOptions{})
+}
+
+func (t *Typed[T]) PushWithTTL(value T, ttl *time.Duration) (int, error) {
+	return t.PushContext(context.Background(), value, MessageOptions{TTL: ttl})
+}
+
+func (t *Typed[T]) PushWithOptions(value T, opts MessageOptions) (int, error) {
+	return t.PushContext(context.Background(), value, opts)
+}
+
+// PushContext is like PushWithOptions, but under the block overflow policy it gives up waiting for room once ctx is done
+func (t *Typed[T]) PushContext(ctx context.Context, value T, opts MessageOptions) (int, error) {
+	entry := TypedEntry(value, opts, t.size)
+	return t.q.PushBytesContext(ctx, entry.Value, entry.Options)
+}
+
+// PushBatch pushes several values at once. Either all of them are pushed, or none are.
+func (t *Typed[T]) PushBatch(values ...T) ([]int, error) {
+	entries := make([]BatchEntry, len(values))
+	for i, value := range values {
+		entries[i] = TypedEntry(value, MessageOptions{}, t.size)
+	}
+	return t.q.PushBatchBytes(entries)
+}
+
+// TypedEntry turns a value into the payload and options a queue pushes, for callers which push it
+// through other APIs than Typed, such as a broker. A nil size counts the value as zero bytes.
+func TypedEntry[T any](value T, opts MessageOptions, size func(T) int64) BatchEntry {
+	switch v := any(value).(type) {
+	case string:
+		return payloadEntry(value, []byte(v), opts)
+	case []byte:
+		return payloadEntry(value, v, opts)
+	}
+	opts.object = value
+	if size != nil {
+		opts.objectSize = size(value)
+	}
+	return BatchEntry{Options: opts}
+}
+
+// payloadEntry pushes a string or byte slice as a regular payload. If T is an interface type, the value
+// is kept as well, as a payload alone does not tell whether it was pushed as a string or a byte slice.
+func payloadEntry[T any](value T, payload []byte, opts MessageOptions) BatchEntry {
+	if isInterface[T]() {
+		opts.object = value
+	}
+	return BatchEntry{Value: payload, Options: opts}
+}
+
+func isInterface[T any]() bool {
+	return reflect.TypeOf((*T)(nil)).Elem().Kind() == reflect.Interface
+}
+
+// Pop dequeues the oldest value. A message which does not hold a T, such as one pushed as bytes
+// into a queue of structs, is still dequeued, and reported with ErrUnexpectedType.
+func (t *Typed[T]) Pop() (T, error) {
+	msg, err := t.q.PopMessage()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return ValueOf[T](msg)
+}
+
+// PopContext is like Pop, but blocks until a message is available or ctx is done
+func (t *Typed[T]) PopContext(ctx context.Context) (T, error) {
+	msg, err := t.q.PopMessageContext(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return ValueOf[T](msg)
+}
+
+// PopN dequeues up to n values at once, in the order Pop would return them.
+// Fails with ErrQueueEmpty only if there was not a single message. Messages which do not hold a T
+// come out as zero values, and are reported with ErrUnexpectedType.
+func (t *Typed[T]) PopN(n int) ([]T, error) {
+	messages, err := t.q.PopN(n)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, len(messages))
+	var mismatch error
+	for i, msg := range messages {
+		if values[i], err = ValueOf[T](msg); err != nil && mismatch == nil {
+			mismatch = err
+		}
+	}
+	return values, mismatch
+}
+
+// PopLease leases the oldest value, which has to be acked or nacked on the queue with the lease's receipt
+func (t *Typed[T]) PopLease(visibility *time.Duration) (T, Lease, error) {
+	l, err := t.q.PopLease(visibility)
+	if err != nil {
+		var zero T
+		return zero, l, err
+	}
+	value, err := ValueOf[T](l.Message)
+	return value, l, err
+}
+
+// ValueOf returns the value a message holds, if it is a T. The payload of a message which was not pushed
+// as a typed value can be read as a string or a byte slice, and comes out as a string for interface types
+// a string satisfies, such as any.
+func ValueOf[T any](msg Message) (value T, err error) {
+	if msg.object == nil {
+		switch v := any(&value).(type) {
+		case *string:
+			*v = string(msg.Value)
+			return value, nil
+		case *[]byte:
+			*v = msg.Value
+			return value, nil
+		}
+		if v, ok := any(string(msg.Value)).(T); ok {
+			return v, nil
+		}
+	}
+	value, ok := msg.object.(T)
+	if !ok {
+		return value, fmt.Errorf("%w: expected %T, got %T", ErrUnexpectedType, value, msg.object)
+	}
+	return value, nil
+}
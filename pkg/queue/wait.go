@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PopContext is like Pop, but blocks until a message is available or ctx is done
+func (q *Queue) PopContext(ctx context.Context) (string, error) {
+	msg, err := q.PopMessageContext(ctx)
+	return string(msg.Value), err
+}
+
+// PopMessageContext is like PopMessage, but blocks until a message is available or ctx is done
+func (q *Queue) PopMessageContext(ctx context.Context) (msg Message, err error) {
+	err = q.await(ctx, func() error {
+		msg, err = q.popMessage()
+		return err
+	})
+	return
+}
+
+// PopLeaseContext is like PopLease, but blocks until a message is available or ctx is done
+func (q *Queue) PopLeaseContext(ctx context.Context, visibility *time.Duration) (l Lease, err error) {
+	err = q.await(ctx, func() error {
+		l, err = q.popLease(visibility)
+		return err
+	})
+	return
+}
+
+// await keeps calling take, which runs with the lock held, for as long as the queue is empty.
+// In between, it sleeps until something is pushed or requeued, the next scheduled message or lease
+// is due, or ctx is done, in which case ctx's error is returned.
+func (q *Queue) await(ctx context.Context, take func() error) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	for {
+		if err := take(); !errors.Is(err, ErrQueueEmpty) {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if q.readyClosed.Load() {
+			q.ready = make(chan struct{})
+			q.readyClosed.Store(false)
+		}
+		ready := q.ready
+		var (
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		if wake, ok := q.nextWake(); ok {
+			timer = time.NewTimer(time.Until(wake))
+			due = timer.C
+		}
+
+		q.waiters++
+		q.mx.Unlock()
+		select {
+		case <-ready:
+		case <-due:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		q.mx.Lock()
+		q.waiters--
+	}
+}
+
+// notify wakes everyone blocked in await. Needs to be called whenever an item becomes visible.
+func (q *Queue) notify() {
+	if q.waiters == 0 {
+		return
+	}
+	if q.readyClosed.CompareAndSwap(false, true) {
+		close(q.ready)
+	}
+	q.ready = make(chan struct{})
+	q.readyClosed.Store(false)
+}
+
+// notifyShared is notify for pushes into sharded queues, which only hold the read lock. The first of them
+// closes ready and the others leave it be, until the next await, which holds the write lock, replaces it.
+func (q *Queue) notifyShared() {
+	if q.waiters == 0 {
+		return
+	}
+	if q.readyClosed.CompareAndSwap(false, true) {
+		close(q.ready)
+	}
+}
+
+// nextWake returns the earliest time at which a scheduled item or a lease becomes due, if any
+func (q *Queue) nextWake() (wake time.Time, ok bool) {
+	if len(q.scheduled) > 0 {
+		wake, ok = q.scheduled[0].ts, true
+	}
+	for _, l := range q.leases {
+		if !ok || l.deadline.Before(wake) {
+			wake, ok = l.deadline, true
+		}
+	}
+	return
+}
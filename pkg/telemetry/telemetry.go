@@ -2,6 +2,7 @@ package telemetry
 
 import (
 	"encoding/json"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,10 +14,23 @@ type QueueStats struct {
 	Dropped          int64 `json:"dropped"`
 	TotalTimeInQueue int64 `json:"total_time_in_queue_ms"`
 	MaxTimeInQueue   int64 `json:"max_time_in_queue_ms"`
+	SizeBytes        int64 `json:"size_bytes"`
+	InFlight         int64 `json:"in_flight"`
+	Requeued         int64 `json:"requeued"`
+	DeadLettered     int64 `json:"dead_lettered"`
+	Scheduled        int64 `json:"scheduled"`
+	DedupHits        int64 `json:"dedup_hits"`
+	ActiveGroups     int64 `json:"active_groups"`
+	Purged           int64 `json:"purged"`
+	// overflow policy actions, taken when a publish finds the queue full
+	OverflowRejected      int64 `json:"overflow_rejected"`
+	OverflowDroppedOldest int64 `json:"overflow_dropped_oldest"`
+	OverflowDroppedNewest int64 `json:"overflow_dropped_newest"`
+	OverflowBlocked       int64 `json:"overflow_blocked"`
 }
 
 func (qs *QueueStats) allMessages() int64 {
-	return qs.Processed + qs.Dropped
+	return qs.Processed + qs.Dropped + qs.DeadLettered
 }
 
 func (qs *QueueStats) Process(timeInQueue time.Duration) {
@@ -29,6 +43,90 @@ func (qs *QueueStats) Drop(timeInQueue time.Duration) {
 	qs.update(timeInQueue)
 }
 
+// SetSize records the total payload bytes currently held by the queue
+func (qs *QueueStats) SetSize(sizeBytes int64) {
+	atomic.StoreInt64(&qs.SizeBytes, sizeBytes)
+}
+
+// SetInFlight records how many leased messages are waiting for an ack
+func (qs *QueueStats) SetInFlight(n int64) {
+	atomic.StoreInt64(&qs.InFlight, n)
+}
+
+// Requeue counts a leased message which was nacked or whose lease expired
+func (qs *QueueStats) Requeue() {
+	atomic.AddInt64(&qs.Requeued, 1)
+}
+
+// DeadLetter counts a message which was moved to a dead-letter queue instead of being dropped
+func (qs *QueueStats) DeadLetter(timeInQueue time.Duration) {
+	atomic.AddInt64(&qs.DeadLettered, 1)
+	qs.update(timeInQueue)
+}
+
+// SetScheduled records how many messages are waiting for their delivery time
+func (qs *QueueStats) SetScheduled(n int64) {
+	atomic.StoreInt64(&qs.Scheduled, n)
+}
+
+// DedupHit counts a publish which was recognized as a duplicate by its deduplication key and not enqueued again
+func (qs *QueueStats) DedupHit() {
+	atomic.AddInt64(&qs.DedupHits, 1)
+}
+
+// SetActiveGroups records how many message groups have a message in flight
+func (qs *QueueStats) SetActiveGroups(n int64) {
+	atomic.StoreInt64(&qs.ActiveGroups, n)
+}
+
+// Purge counts messages which were thrown away by purging the queue, as opposed to being dropped
+func (qs *QueueStats) Purge(n int64) {
+	atomic.AddInt64(&qs.Purged, n)
+}
+
+// OverflowReject counts a publish which failed because the queue was full, including one which timed out waiting for room
+func (qs *QueueStats) OverflowReject() {
+	atomic.AddInt64(&qs.OverflowRejected, 1)
+}
+
+// OverflowDropOldest counts a message which was evicted to make room for a newer one
+func (qs *QueueStats) OverflowDropOldest() {
+	atomic.AddInt64(&qs.OverflowDroppedOldest, 1)
+}
+
+// OverflowDropNewest counts a published message which was discarded because the queue was full
+func (qs *QueueStats) OverflowDropNewest() {
+	atomic.AddInt64(&qs.OverflowDroppedNewest, 1)
+}
+
+// OverflowBlock counts a publish which had to wait for room in the queue
+func (qs *QueueStats) OverflowBlock() {
+	atomic.AddInt64(&qs.OverflowBlocked, 1)
+}
+
+// snapshot copies the stats with atomic loads, so they can be read while queues keep updating them
+func (qs *QueueStats) snapshot() QueueStats {
+	return QueueStats{
+		Processed:        atomic.LoadInt64(&qs.Processed),
+		Dropped:          atomic.LoadInt64(&qs.Dropped),
+		TotalTimeInQueue: atomic.LoadInt64(&qs.TotalTimeInQueue),
+		MaxTimeInQueue:   atomic.LoadInt64(&qs.MaxTimeInQueue),
+		SizeBytes:        atomic.LoadInt64(&qs.SizeBytes),
+		InFlight:         atomic.LoadInt64(&qs.InFlight),
+		Requeued:         atomic.LoadInt64(&qs.Requeued),
+		DeadLettered:     atomic.LoadInt64(&qs.DeadLettered),
+		Scheduled:        atomic.LoadInt64(&qs.Scheduled),
+		DedupHits:        atomic.LoadInt64(&qs.DedupHits),
+		ActiveGroups:     atomic.LoadInt64(&qs.ActiveGroups),
+		Purged:           atomic.LoadInt64(&qs.Purged),
+
+		OverflowRejected:      atomic.LoadInt64(&qs.OverflowRejected),
+		OverflowDroppedOldest: atomic.LoadInt64(&qs.OverflowDroppedOldest),
+		OverflowDroppedNewest: atomic.LoadInt64(&qs.OverflowDroppedNewest),
+		OverflowBlocked:       atomic.LoadInt64(&qs.OverflowBlocked),
+	}
+}
+
 func (qs *QueueStats) update(timeInQueue time.Duration) {
 	tiq := timeInQueue.Milliseconds()
 	atomicx.MaxSwap64(&qs.MaxTimeInQueue, tiq)
@@ -61,6 +159,7 @@ func (qs *QueueStats) MarshalJSON() ([]byte, error) {
 }
 
 type Collector struct {
+	mx     *sync.RWMutex
 	qStats map[string]*QueueStats
 }
 
@@ -70,11 +169,14 @@ func NewCollector(queues ...string) *Collector {
 		statMap[queue] = &QueueStats{}
 	}
 	return &Collector{
+		mx:     &sync.RWMutex{},
 		qStats: statMap,
 	}
 }
 
 func (c *Collector) AddQueue(queue string) *QueueStats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
 	qs, ok := c.qStats[queue]
 	if !ok {
 		qs = new(QueueStats)
@@ -84,15 +186,19 @@ func (c *Collector) AddQueue(queue string) *QueueStats {
 }
 
 func (c *Collector) RemoveQueue(queue string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
 	if _, ok := c.qStats[queue]; ok {
 		delete(c.qStats, queue)
 	}
 }
 
 func (c *Collector) Stats() map[string]QueueStats {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
 	s := make(map[string]QueueStats)
 	for queueName, q := range c.qStats {
-		s[queueName] = *q
+		s[queueName] = q.snapshot()
 	}
 	return s
 }
@@ -26,6 +26,22 @@ func TestQueueStatsAPI(t *testing.T) {
 	assert.Equal(t, defaultTIQ.Milliseconds()*4, qs.TotalTimeInQueue) // 1 dtiq before, 3 now
 	assert.Equal(t, defaultTIQ.Milliseconds()*3, qs.MaxTimeInQueue)   // new dtiq is this 3
 
+	qs.SetSize(42)
+	assert.Equal(t, int64(42), qs.SizeBytes, "should report the set size")
+	assert.Equal(t, int64(1), qs.Processed, "setting the size should not touch counters")
+
+	qs.SetInFlight(2)
+	qs.Requeue()
+	assert.Equal(t, int64(2), qs.InFlight, "should report the set in-flight count")
+	assert.Equal(t, int64(1), qs.Requeued, "should have requeued 1 item")
+
+	qs.DeadLetter(defaultTIQ * 5)
+	assert.Equal(t, int64(1), qs.DeadLettered, "should have dead-lettered 1 item")
+	qs.SetScheduled(3)
+	assert.Equal(t, int64(3), qs.Scheduled, "should report the set scheduled count")
+	assert.Equal(t, defaultTIQ.Milliseconds()*9, qs.TotalTimeInQueue)
+	assert.Equal(t, defaultTIQ.Milliseconds()*3, qs.averageTimeInQueue(), "dead-lettered items count towards the average")
+
 }
 
 func TestQueueStatsEnrichment(t *testing.T) {
@@ -45,7 +61,7 @@ func TestQueueStatsRender(t *testing.T) {
 	qs.Process(defaultTIQ * 3)
 	qs.Drop(defaultTIQ)
 	expectedJsonMap := fmt.Sprintf(
-		`{"processed": 1, "dropped": 1, "total_time_in_queue_ms": %d, "max_time_in_queue_ms": %d, "average_time_in_queue_ms": %d}`,
+		`{"processed": 1, "dropped": 1, "total_time_in_queue_ms": %d, "max_time_in_queue_ms": %d, "size_bytes": 0, "in_flight": 0, "requeued": 0, "dead_lettered": 0, "scheduled": 0, "dedup_hits": 0, "active_groups": 0, "purged": 0, "overflow_rejected": 0, "overflow_dropped_oldest": 0, "overflow_dropped_newest": 0, "overflow_blocked": 0, "average_time_in_queue_ms": %d}`,
 		defaultTIQ.Milliseconds()*4,
 		defaultTIQ.Milliseconds()*3,
 		defaultTIQ.Milliseconds()*2,
@@ -72,3 +88,23 @@ func TestCollector(t *testing.T) {
 	assert.Contains(t, c.qStats, "test4", "failed to add new queue")
 	// TODO: collector stats reporting?
 }
+
+func TestCollectorConcurrency(t *testing.T) {
+	c := NewCollector()
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		go func(w int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < 100; i++ {
+				qName := fmt.Sprintf("test%d", (w+i)%8)
+				c.AddQueue(qName).Process(time.Millisecond)
+				_ = c.Stats()
+				c.RemoveQueue(qName)
+			}
+		}(w)
+	}
+	for w := 0; w < 4; w++ {
+		<-done
+	}
+	assert.Empty(t, c.Stats(), "queues left behind")
+}
@@ -77,6 +77,9 @@ func safeJsonRequestBodyReader(req *http.Request) (string, error) {
 	if string(body) == "" {
 		return "", nil
 	}
+	if !IsJSONRequest(req) {
+		return fmt.Sprintf("<%d bytes of %s>", len(body), req.Header.Get("Content-Type")), nil
+	}
 	var data map[string]interface{}
 	if err = json.Unmarshal(body, &data); err != nil {
 		return "", fmt.Errorf("failed to unmarshal JSON body: %v", err)
@@ -1,14 +1,96 @@
 package httpx
 
 import (
+	"encoding/json"
 	"time"
 	"yambol/config"
+	"yambol/pkg/queue"
 	"yambol/pkg/util"
 )
 
 type MessageRequest struct {
 	Message string `json:"message"`
-	TTL     int64  `json:"ttl,omitempty"`
+	// Data is a binary payload, which is base64 encoded in JSON. It takes precedence over Message.
+	Data        []byte            `json:"data,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	// DedupKey may also be sent as the Idempotency-Key header
+	DedupKey string `json:"dedup_key,omitempty"`
+	Group    string `json:"group,omitempty"`
+	// TTL is seconds or a duration string such as "250ms". TTLMilliseconds takes precedence over it when set.
+	TTL             util.Duration `json:"ttl,omitempty"`
+	TTLMilliseconds int64         `json:"ttl_ms,omitempty"`
+	Priority        int           `json:"priority,omitempty"`
+	Delay           int64         `json:"delay,omitempty"`
+	DeliverAt       *time.Time    `json:"deliver_at,omitempty"`
+}
+
+// Body is the payload to publish
+func (r *MessageRequest) Body() []byte {
+	if r.Data != nil {
+		return r.Data
+	}
+	return []byte(r.Message)
+}
+
+// TTLDuration is the message's own TTL, or zero for the queue's default
+func (r *MessageRequest) TTLDuration() time.Duration {
+	if r.TTLMilliseconds != 0 {
+		return util.Milliseconds(r.TTLMilliseconds)
+	}
+	return time.Duration(r.TTL)
+}
+
+func (r *MessageRequest) Options() queue.MessageOptions {
+	opts := queue.MessageOptions{
+		Priority:    r.Priority,
+		Delay:       util.Seconds(r.Delay),
+		ContentType: r.ContentType,
+		Headers:     r.Headers,
+		DedupKey:    r.DedupKey,
+		Group:       r.Group,
+	}
+	if ttl := r.TTLDuration(); ttl != 0 {
+		opts.TTL = &ttl
+	}
+	if r.DeliverAt != nil {
+		opts.DeliverAt = *r.DeliverAt
+	}
+	return opts
+}
+
+// BatchMessageRequest publishes several messages to a queue at once
+type BatchMessageRequest struct {
+	Messages []MessageRequest `json:"messages"`
+}
+
+// Entries converts the messages into what the queue pushes
+func (r *BatchMessageRequest) Entries() []queue.BatchEntry {
+	entries := make([]queue.BatchEntry, len(r.Messages))
+	for i := range r.Messages {
+		entries[i] = queue.BatchEntry{
+			Value:   r.Messages[i].Body(),
+			Options: r.Messages[i].Options(),
+		}
+	}
+	return entries
+}
+
+// HeaderIdempotencyKey carries a message's deduplication key when publishing
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// PauseRequest says which direction of a queue to pause or resume. An empty direction means both.
+type PauseRequest struct {
+	Direction string `json:"direction,omitempty"`
+}
+
+// PurgeRequest limits a purge to messages at least OlderThan seconds old. Zero purges every message.
+type PurgeRequest struct {
+	OlderThan int64 `json:"older_than,omitempty"`
+}
+
+type LeaseRequest struct {
+	Receipt string `json:"receipt"`
 }
 
 type QueuesPostRequest struct {
@@ -16,6 +98,30 @@ type QueuesPostRequest struct {
 	config.QueueConfig
 }
 
+// UnmarshalJSON reads the name apart from the config, since the config's own UnmarshalJSON would otherwise
+// be promoted and skip the name
+func (r *QueuesPostRequest) UnmarshalJSON(b []byte) error {
+	var named struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(b, &named); err != nil {
+		return err
+	}
+	r.Name = named.Name
+	return json.Unmarshal(b, &r.QueueConfig)
+}
+
+type ExchangesPostRequest struct {
+	Name string `json:"name"`
+	config.ExchangeConfig
+}
+
+// ExchangePublishRequest is a message published to an exchange, which routes it by its routing key
+type ExchangePublishRequest struct {
+	RoutingKey string `json:"routing_key"`
+	MessageRequest
+}
+
 func (r *QueuesPostRequest) TTLSeconds() time.Duration {
-	return util.Seconds(r.TTL)
+	return r.TTLDuration()
 }
@@ -3,7 +3,10 @@ package httpx
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 	"yambol/config"
+	"yambol/pkg/broker"
+	"yambol/pkg/queue"
 	"yambol/pkg/transport/model"
 
 	"yambol/pkg/telemetry"
@@ -57,6 +60,45 @@ func (r ConfigResponse) AsJSON() ([]byte, error) {
 	return jMarshalIndent(r.Config)
 }
 
+type QueueConfigResponse struct {
+	StatusCode int
+	Config     config.QueueConfig
+}
+
+func (r QueueConfigResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r QueueConfigResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r.Config)
+}
+
+type ExchangeConfigResponse struct {
+	StatusCode int
+	Config     config.ExchangeConfig
+}
+
+func (r ExchangeConfigResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r ExchangeConfigResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r.Config)
+}
+
+type ExchangesResponse struct {
+	StatusCode int
+	Exchanges  config.ExchangeMap
+}
+
+func (r ExchangesResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r ExchangesResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r.Exchanges)
+}
+
 type StatsResponse map[string]telemetry.QueueStats
 
 func (r StatsResponse) GetStatusCode() int {
@@ -77,9 +119,31 @@ func (r QueuesGetResponse) AsJSON() ([]byte, error) {
 	return jMarshalIndent(r)
 }
 
+// Headers which carry a message's metadata when it is consumed as a raw body instead of as JSON
+const (
+	HeaderReceipt          = "X-Yambol-Receipt"
+	HeaderDeadline         = "X-Yambol-Deadline"
+	HeaderPriority         = "X-Yambol-Priority"
+	HeaderGroup            = "X-Yambol-Group"
+	HeaderDeliveries       = "X-Yambol-Deliveries"
+	HeaderDeadLetterReason = "X-Yambol-Dead-Letter-Reason"
+	HeaderDeadLetterQueue  = "X-Yambol-Dead-Letter-Queue"
+	// HeaderMessageHeaders holds the message's own headers, query encoded, both when publishing and consuming raw bodies
+	HeaderMessageHeaders = "X-Yambol-Headers"
+)
+
 type QueueGetResponse struct {
-	StatusCode int
-	Data       string `json:"data"`
+	StatusCode  int
+	Data        string            `json:"data"`
+	Encoding    string            `json:"encoding,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Receipt     string            `json:"receipt,omitempty"`
+	Deadline    *time.Time        `json:"deadline,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Deliveries  int64             `json:"deliveries,omitempty"`
+	DeadLetter  *model.DeadLetter `json:"dead_letter,omitempty"`
 }
 
 func (r QueueGetResponse) GetStatusCode() int {
@@ -90,6 +154,263 @@ func (r QueueGetResponse) AsJSON() ([]byte, error) {
 	return jMarshalIndent(r)
 }
 
+// ConsumedMessage is one message of a batch consume
+type ConsumedMessage struct {
+	Data        string            `json:"data"`
+	Encoding    string            `json:"encoding,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	DeadLetter  *model.DeadLetter `json:"dead_letter,omitempty"`
+}
+
+func NewConsumedMessage(message queue.Message) ConsumedMessage {
+	data, encoding := model.EncodeData(message.Value)
+	return ConsumedMessage{
+		Data:        data,
+		Encoding:    encoding,
+		ContentType: message.ContentType,
+		Headers:     message.Headers,
+		Priority:    message.Priority,
+		Group:       message.Group,
+		DeadLetter:  NewDeadLetter(message.DeadLetter),
+	}
+}
+
+func NewDeadLetter(dl *queue.DeadLetter) *model.DeadLetter {
+	if dl == nil {
+		return nil
+	}
+	return &model.DeadLetter{Reason: dl.Reason, Queue: dl.Queue, EnqueuedAt: dl.EnqueuedAt}
+}
+
+// Message decodes the message's data
+func (m ConsumedMessage) Message() (model.Message, error) {
+	data, err := model.DecodeData(m.Data, m.Encoding)
+	if err != nil {
+		return model.Message{}, err
+	}
+	return model.Message{
+		Data:        data,
+		ContentType: m.ContentType,
+		Headers:     m.Headers,
+		Priority:    m.Priority,
+		Group:       m.Group,
+		DeadLetter:  m.DeadLetter,
+	}, nil
+}
+
+type BatchGetResponse struct {
+	StatusCode int
+	Messages   []ConsumedMessage `json:"messages"`
+}
+
+func (r BatchGetResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r BatchGetResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type PublishResponse struct {
+	StatusCode int
+	ID         int `json:"id"`
+}
+
+func (r PublishResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r PublishResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+// ExchangePublishResponse maps each queue the exchange routed the message into onto the ID it got there
+type ExchangePublishResponse struct {
+	StatusCode int
+	IDs        map[string]int `json:"ids"`
+	// Error lists the queues the message could not be published into, if some of them failed
+	Error string `json:"error,omitempty"`
+}
+
+func (r ExchangePublishResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r ExchangePublishResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type PublishBatchResponse struct {
+	StatusCode int
+	IDs        []int `json:"ids"`
+}
+
+func (r PublishBatchResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r PublishBatchResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type PauseResponse struct {
+	StatusCode int
+	Paused     string `json:"paused"`
+}
+
+func (r PauseResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r PauseResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type PurgeResponse struct {
+	StatusCode int
+	Purged     int `json:"purged"`
+}
+
+func (r PurgeResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r PurgeResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type MessageResponse struct {
+	StatusCode int
+	model.MessageInfo
+}
+
+func (r MessageResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r MessageResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+func NewMessageInfo(info queue.MessageInfo) model.MessageInfo {
+	data, encoding := model.EncodeData(info.Value)
+	return model.MessageInfo{
+		ID:          info.ID,
+		State:       info.State,
+		Data:        data,
+		Encoding:    encoding,
+		ContentType: info.ContentType,
+		Headers:     info.Headers,
+		Priority:    info.Priority,
+		Group:       info.Group,
+		TTL:         info.TTL.Milliseconds(),
+		EnqueuedAt:  info.EnqueuedAt,
+		Age:         info.Age.Milliseconds(),
+		Deliveries:  info.Deliveries,
+		DeadLetter:  NewDeadLetter(info.DeadLetter),
+	}
+}
+
+// RawResponse is written as is instead of as JSON, with its metadata in headers
+type RawResponse struct {
+	StatusCode  int
+	ContentType string
+	Headers     map[string]string
+	Body        []byte
+}
+
+func (r RawResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+// AsJSON only describes the body, since it may not be representable as JSON
+func (r RawResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(map[string]any{
+		"content_type": r.ContentType,
+		"headers":      r.Headers,
+		"size":         len(r.Body),
+	})
+}
+
+type BrowseResponse struct {
+	StatusCode int
+	Messages   []model.MessageInfo `json:"messages"`
+	// Next is the cursor for the following page, only set if this page is full
+	Next *int `json:"next,omitempty"`
+}
+
+func (r BrowseResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r BrowseResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type UnsentResponse struct {
+	StatusCode int
+	Messages   []model.UnsentMessage `json:"messages"`
+	// Dropped counts the messages which were dropped because the queue's unsent spool was full
+	Dropped int64 `json:"dropped"`
+}
+
+func (r UnsentResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r UnsentResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+func NewUnsentMessage(msg broker.UnsentMessage) model.UnsentMessage {
+	data, encoding := model.EncodeData(msg.Value)
+	rv := model.UnsentMessage{
+		ID:          msg.ID,
+		Data:        data,
+		Encoding:    encoding,
+		ContentType: msg.Options.ContentType,
+		Headers:     msg.Options.Headers,
+		Priority:    msg.Options.Priority,
+		Group:       msg.Options.Group,
+		FailedAt:    msg.FailedAt,
+		Attempts:    msg.Attempts,
+	}
+	if msg.Err != nil {
+		rv.Error = msg.Err.Error()
+	}
+	return rv
+}
+
+type RetryUnsentResponse struct {
+	StatusCode int
+	Retried    int `json:"retried"`
+	Remaining  int `json:"remaining"`
+}
+
+func (r RetryUnsentResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r RetryUnsentResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
+type DiscardUnsentResponse struct {
+	StatusCode int
+	Discarded  int `json:"discarded"`
+}
+
+func (r DiscardUnsentResponse) GetStatusCode() int {
+	return r.StatusCode
+}
+
+func (r DiscardUnsentResponse) AsJSON() ([]byte, error) {
+	return jMarshalIndent(r)
+}
+
 type EmptyResponse struct {
 	StatusCode int
 }
@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 	"yambol/pkg/telemetry"
 	"yambol/pkg/transport/model"
+	"yambol/pkg/util"
 
 	"yambol/config"
 	"yambol/pkg/transport/httpx"
@@ -91,6 +94,14 @@ func (c *Client) put(ctx context.Context, url string, body io.Reader, headers ma
 	return c.do(ctx, req, headers)
 }
 
+func (c *Client) patch(ctx context.Context, url string, body io.Reader, headers map[string]string) (resp *http.Response, err error) {
+	req, err := http.NewRequest(http.MethodPatch, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build PATCH request: %v", err)
+	}
+	return c.do(ctx, req, headers)
+}
+
 func (c *Client) delete(ctx context.Context, url string, headers map[string]string) (resp *http.Response, err error) {
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
@@ -100,14 +111,12 @@ func (c *Client) delete(ctx context.Context, url string, headers map[string]stri
 }
 
 func (c *Client) do(ctx context.Context, req *http.Request, headers map[string]string) (resp *http.Response, err error) {
-	if headers != nil {
-		for k, v := range headers {
-			req.Header.Set(k, v)
-		}
-	}
 	for key, value := range c.headers() {
 		req.Header.Set(key, value)
 	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	if ctx == nil {
 		var cancel context.CancelFunc
 		ctx, cancel = c.context()
@@ -172,64 +181,617 @@ func (c *Client) StatsContext(ctx context.Context) (map[string]telemetry.QueueSt
 	return response, nil
 }
 
-func (c *Client) Publish(queue, value string) error {
+func (c *Client) Publish(queue, value string) (int, error) {
 	return c.PublishTimeout(queue, value, c.defaultTimeout)
 }
 
-func (c *Client) PublishTimeout(queue, value string, ttl time.Duration) error {
+func (c *Client) PublishTimeout(queue, value string, ttl time.Duration) (int, error) {
 	ctx, cancel := c.context()
 	defer cancel()
 	return c.PublishContextTimeout(ctx, queue, value, ttl)
 }
 
-func (c *Client) PublishContext(ctx context.Context, queue, value string) error {
+func (c *Client) PublishContext(ctx context.Context, queue, value string) (int, error) {
 	return c.PublishContextTimeout(ctx, queue, value, c.defaultTimeout)
 }
 
-func (c *Client) PublishContextTimeout(ctx context.Context, queue, value string, ttl time.Duration) error {
-	endpoint := httpx.UrlJoin(c.Url, "queues", queue)
-	request := httpx.MessageRequest{
+// PublishContextTimeout publishes a value with a TTL and returns the ID the message got in the queue
+func (c *Client) PublishContextTimeout(ctx context.Context, queue, value string, ttl time.Duration) (int, error) {
+	return c.PublishMessageContext(ctx, queue, httpx.MessageRequest{
 		Message: value,
-		TTL:     int64(ttl.Seconds()),
-	}
+		TTL:     util.Duration(ttl),
+	})
+}
+
+func (c *Client) PublishMessage(queue string, request httpx.MessageRequest) (int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PublishMessageContext(ctx, queue, request)
+}
+
+// PublishMessageContext publishes a message with every per-message option the API supports, such as its priority
+func (c *Client) PublishMessageContext(ctx context.Context, queue string, request httpx.MessageRequest) (int, error) {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(request); err != nil {
-		return fmt.Errorf("failed to encode config: %v", err)
+		return 0, fmt.Errorf("failed to encode config: %v", err)
+	}
+	return c.publish(ctx, queue, &buf, nil)
+}
+
+func (c *Client) PublishBytes(queue string, value []byte, contentType string) (int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PublishBytesContext(ctx, queue, value, contentType)
+}
+
+// PublishBytesContext publishes a binary payload as the raw request body. An empty content type
+// is sent as application/octet-stream.
+func (c *Client) PublishBytesContext(ctx context.Context, queue string, value []byte, contentType string) (int, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
+	return c.publish(ctx, queue, bytes.NewReader(value), map[string]string{"Content-Type": contentType})
+}
+
+func (c *Client) publish(ctx context.Context, queue string, body io.Reader, headers map[string]string) (int, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue)
+	resp, err := c.post(ctx, endpoint, body, headers)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send value to queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return 0, fmt.Errorf("[%d] failed to send value to queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.PublishResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode publish response: %v", err)
+	}
+	return response.ID, nil
+}
+
+func (c *Client) PublishBatch(queue string, messages []httpx.MessageRequest) ([]int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PublishBatchContext(ctx, queue, messages)
+}
+
+// PublishBatchContext publishes several messages in one request. Either all of them are published, or none are.
+// Returns the ID of each message, in the order they were given.
+func (c *Client) PublishBatchContext(ctx context.Context, queue string, messages []httpx.MessageRequest) ([]int, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(httpx.BatchMessageRequest{Messages: messages}); err != nil {
+		return nil, fmt.Errorf("failed to encode batch: %v", err)
+	}
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "batch")
 	resp, err := c.post(ctx, endpoint, &buf, nil)
 	if err != nil {
-		return fmt.Errorf("failed to send value to queue %s: %v", queue, err)
+		return nil, fmt.Errorf("failed to send batch to queue %s: %v", queue, err)
 	}
 	defer resp.Body.Close()
 	if !c.ok(resp) {
-		return fmt.Errorf("[%d] failed to send value to queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+		return nil, fmt.Errorf("[%d] failed to send batch to queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
 	}
-	return nil
+	var response httpx.PublishBatchResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode publish response: %v", err)
+	}
+	return response.IDs, nil
 }
 
+// Consume returns straight away, with an empty value if the queue is empty
 func (c *Client) Consume(queue string) (string, error) {
 	ctx, cancel := c.context()
 	defer cancel()
-	return c.ConsumeContext(ctx, queue)
+	return c.ConsumeWaitContext(ctx, queue, 0)
 }
 
+// ConsumeContext long-polls the queue for as long as ctx allows, up to the server's maximum wait,
+// keeping a little of the time left to get the response back. Without a deadline on ctx, it returns
+// straight away. Returns an empty value if no message arrived in time.
 func (c *Client) ConsumeContext(ctx context.Context, queue string) (string, error) {
+	return c.ConsumeWaitContext(ctx, queue, waitFor(ctx))
+}
+
+func (c *Client) ConsumeWait(queue string, wait time.Duration) (string, error) {
+	to := c.defaultTimeout
+	if to < wait+time.Second {
+		to = wait + time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), to)
+	defer cancel()
+	return c.ConsumeWaitContext(ctx, queue, wait)
+}
+
+// ConsumeWaitContext long-polls the queue: the server holds the request for up to wait until a message
+// arrives. Returns an empty value if none arrived in time.
+func (c *Client) ConsumeWaitContext(ctx context.Context, queue string, wait time.Duration) (string, error) {
+	message, err := c.consumeMessage(ctx, queue, wait)
+	if err != nil || message == nil {
+		return "", err
+	}
+	return string(message.Data), nil
+}
+
+// waitFor is how long a consume may wait for a message without outliving ctx
+func waitFor(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	left := time.Until(deadline)
+	margin := left / 10
+	if margin > time.Second {
+		margin = time.Second
+	}
+	if wait := left - margin; wait < maxConsumeWait {
+		return wait
+	}
+	return maxConsumeWait
+}
+
+// waitParam formats a wait for the `wait` query parameter, in seconds when it is a whole number of them
+func waitParam(wait time.Duration) string {
+	if wait%time.Second == 0 {
+		return strconv.FormatInt(int64(wait/time.Second), 10)
+	}
+	return wait.String()
+}
+
+func (c *Client) ConsumeMessage(queue string) (*model.Message, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.ConsumeMessageContext(ctx, queue)
+}
+
+// ConsumeMessageContext consumes a message along with its metadata, such as its headers, without waiting
+// for one. Returns nil if the queue is empty.
+func (c *Client) ConsumeMessageContext(ctx context.Context, queue string) (*model.Message, error) {
+	return c.consumeMessage(ctx, queue, 0)
+}
+
+func (c *Client) consumeMessage(ctx context.Context, queue string, wait time.Duration) (*model.Message, error) {
 	endpoint := httpx.UrlJoin(c.Url, "queues", queue)
+	if wait > 0 {
+		endpoint += "?wait=" + waitParam(wait)
+	}
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume from queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to consume value from queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.QueueGetResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consume response: %v", err)
+	}
+	// an empty queue gets an empty response, which is all an empty message without metadata would be too
+	if response.Data == "" && response.ContentType == "" && len(response.Headers) == 0 && response.Group == "" && response.DeadLetter == nil {
+		return nil, nil
+	}
+	data, err := model.DecodeData(response.Data, response.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consumed value: %v", err)
+	}
+	return &model.Message{
+		Data:        data,
+		ContentType: response.ContentType,
+		Headers:     response.Headers,
+		Priority:    response.Priority,
+		Group:       response.Group,
+		DeadLetter:  response.DeadLetter,
+	}, nil
+}
+
+func (c *Client) ConsumeBytes(queue string) ([]byte, string, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.ConsumeBytesContext(ctx, queue)
+}
+
+// ConsumeBytesContext consumes the original payload of a message, along with its content type.
+// Returns a nil payload if the queue is empty.
+func (c *Client) ConsumeBytesContext(ctx context.Context, queue string) ([]byte, string, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue) + "?raw"
+	resp, err := c.get(ctx, endpoint, map[string]string{"Accept": "*/*"})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to consume from queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, "", fmt.Errorf("[%d] failed to consume value from queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, "", nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read consumed value: %v", err)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+func (c *Client) ConsumeBatch(queue string, max int) ([]model.Message, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.ConsumeBatchContext(ctx, queue, max)
+}
+
+// ConsumeBatchContext consumes up to max messages in one request. Returns an empty slice if the queue is empty.
+func (c *Client) ConsumeBatchContext(ctx context.Context, queue string, max int) ([]model.Message, error) {
+	return c.ConsumeBatchWaitContext(ctx, queue, max, 0)
+}
+
+func (c *Client) ConsumeBatchWait(queue string, max int, wait time.Duration) ([]model.Message, error) {
+	to := c.defaultTimeout
+	if to < wait+time.Second {
+		to = wait + time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), to)
+	defer cancel()
+	return c.ConsumeBatchWaitContext(ctx, queue, max, wait)
+}
+
+// ConsumeBatchWaitContext is like ConsumeBatchContext, but the server holds the request for up to wait
+// until at least one message arrives
+func (c *Client) ConsumeBatchWaitContext(ctx context.Context, queue string, max int, wait time.Duration) ([]model.Message, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue) + fmt.Sprintf("?max=%d", max)
+	if wait > 0 {
+		endpoint += "&wait=" + waitParam(wait)
+	}
 	resp, err := c.get(ctx, endpoint, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to consume from queue %s: %v", queue, err)
+		return nil, fmt.Errorf("failed to consume from queue %s: %v", queue, err)
 	}
 	defer resp.Body.Close()
 	if !c.ok(resp) {
-		return "", fmt.Errorf("[%d] failed to consume value from queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+		return nil, fmt.Errorf("[%d] failed to consume batch from queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.BatchGetResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode consume response: %v", err)
+	}
+	messages := make([]model.Message, len(response.Messages))
+	for i, consumed := range response.Messages {
+		if messages[i], err = consumed.Message(); err != nil {
+			return nil, fmt.Errorf("failed to decode consumed value: %v", err)
+		}
+	}
+	return messages, nil
+}
+
+func (c *Client) ConsumeLease(queue string, visibility time.Duration) (*model.Lease, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.ConsumeLeaseContext(ctx, queue, visibility)
+}
+
+// ConsumeLeaseContext consumes a message which stays invisible to other consumers until it is acked, nacked
+// or the visibility timeout passes. A zero visibility uses the queue's default. Returns nil if the queue is empty.
+func (c *Client) ConsumeLeaseContext(ctx context.Context, queue string, visibility time.Duration) (*model.Lease, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue) + "?lease"
+	if seconds := int64(visibility.Seconds()); seconds > 0 {
+		endpoint += fmt.Sprintf("&visibility_timeout=%d", seconds)
+	}
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease from queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to lease value from queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
 	}
 	var response httpx.QueueGetResponse
 
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode consume response: %v", err)
+		return nil, fmt.Errorf("failed to decode lease response: %v", err)
+	}
+	if response.Receipt == "" {
+		return nil, nil
+	}
+	data, err := model.DecodeData(response.Data, response.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode leased value: %v", err)
+	}
+	return &model.Lease{
+		Receipt:     response.Receipt,
+		Data:        data,
+		ContentType: response.ContentType,
+		Headers:     response.Headers,
+		Group:       response.Group,
+		Deadline:    *response.Deadline,
+		Deliveries:  response.Deliveries,
+		DeadLetter:  response.DeadLetter,
+	}, nil
+}
+
+func (c *Client) Ack(queue, receipt string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.AckContext(ctx, queue, receipt)
+}
+
+func (c *Client) AckContext(ctx context.Context, queue, receipt string) error {
+	return c.settleLease(ctx, queue, receipt, "ack")
+}
+
+func (c *Client) Nack(queue, receipt string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.NackContext(ctx, queue, receipt)
+}
+
+func (c *Client) NackContext(ctx context.Context, queue, receipt string) error {
+	return c.settleLease(ctx, queue, receipt, "nack")
+}
+
+func (c *Client) settleLease(ctx context.Context, queue, receipt, action string) error {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, action)
+	b, err := json.Marshal(httpx.LeaseRequest{Receipt: receipt})
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s request: %v", action, err)
+	}
+	resp, err := c.post(ctx, endpoint, bytes.NewBuffer(b), nil)
+	if err != nil {
+		return fmt.Errorf("failed to %s message in queue %s: %v", action, queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return fmt.Errorf("[%d] failed to %s message in queue %s: %v", resp.StatusCode, action, queue, c.checkError(resp))
+	}
+	return nil
+}
+
+func (c *Client) PurgeQueue(queue string) (int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PurgeQueueContext(ctx, queue, 0)
+}
+
+func (c *Client) PurgeQueueOlderThan(queue string, olderThan time.Duration) (int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PurgeQueueContext(ctx, queue, olderThan)
+}
+
+// PurgeQueueContext throws away the messages of the queue which were published at least olderThan ago
+// (rounded down to whole seconds), or all of them if olderThan is zero. Returns how many were purged.
+func (c *Client) PurgeQueueContext(ctx context.Context, queue string, olderThan time.Duration) (int, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "purge")
+	b, err := json.Marshal(httpx.PurgeRequest{OlderThan: int64(olderThan.Seconds())})
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize purge request: %v", err)
+	}
+	resp, err := c.post(ctx, endpoint, bytes.NewBuffer(b), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return 0, fmt.Errorf("[%d] failed to purge queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.PurgeResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode purge response: %v", err)
+	}
+	return response.Purged, nil
+}
+
+func (c *Client) PauseQueue(queue, direction string) (string, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PauseQueueContext(ctx, queue, direction)
+}
+
+// PauseQueueContext pauses consuming from the queue, publishing into it, or both, depending on direction,
+// which is one of the config.Pause values. An empty direction pauses both. Returns the directions now paused.
+func (c *Client) PauseQueueContext(ctx context.Context, queue, direction string) (string, error) {
+	return c.setPaused(ctx, queue, direction, "pause")
+}
+
+func (c *Client) ResumeQueue(queue, direction string) (string, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.ResumeQueueContext(ctx, queue, direction)
+}
+
+// ResumeQueueContext undoes PauseQueueContext for the given direction. Returns the directions still paused.
+func (c *Client) ResumeQueueContext(ctx context.Context, queue, direction string) (string, error) {
+	return c.setPaused(ctx, queue, direction, "resume")
+}
+
+func (c *Client) setPaused(ctx context.Context, queue, direction, action string) (string, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, action)
+	b, err := json.Marshal(httpx.PauseRequest{Direction: direction})
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize %s request: %v", action, err)
+	}
+	resp, err := c.post(ctx, endpoint, bytes.NewBuffer(b), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to %s queue %s: %v", action, queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return "", fmt.Errorf("[%d] failed to %s queue %s: %v", resp.StatusCode, action, queue, c.checkError(resp))
+	}
+	var response httpx.PauseResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode %s response: %v", action, err)
+	}
+	return response.Paused, nil
+}
+
+func (c *Client) Browse(queue string, limit int) ([]model.MessageInfo, *int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.BrowseContext(ctx, queue, limit)
+}
+
+// BrowseContext lists up to limit of the next messages in the queue without consuming them.
+// A limit of 0 uses the server's default. Also returns the cursor of the next page, if there might be one.
+func (c *Client) BrowseContext(ctx context.Context, queue string, limit int) ([]model.MessageInfo, *int, error) {
+	return c.browse(ctx, queue, limit, nil)
+}
+
+func (c *Client) BrowseAfter(queue string, after, limit int) ([]model.MessageInfo, *int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.BrowseAfterContext(ctx, queue, after, limit)
+}
+
+// BrowseAfterContext is like BrowseContext, but lists the messages following the one with the given ID
+func (c *Client) BrowseAfterContext(ctx context.Context, queue string, after, limit int) ([]model.MessageInfo, *int, error) {
+	return c.browse(ctx, queue, limit, &after)
+}
+
+func (c *Client) browse(ctx context.Context, queue string, limit int, after *int) ([]model.MessageInfo, *int, error) {
+	params := url.Values{}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	if after != nil {
+		params.Set("after", strconv.Itoa(*after))
+	}
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "messages")
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to browse queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, nil, fmt.Errorf("[%d] failed to browse queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.BrowseResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode browse response: %v", err)
+	}
+	return response.Messages, response.Next, nil
+}
+
+func (c *Client) GetMessage(queue string, id int) (*model.MessageInfo, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.GetMessageContext(ctx, queue, id)
+}
+
+// GetMessageContext describes the message with the given ID without consuming it
+func (c *Client) GetMessageContext(ctx context.Context, queue string, id int) (*model.MessageInfo, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "messages", id)
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message %d from queue %s: %v", id, queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to get message %d from queue %s: %v", resp.StatusCode, id, queue, c.checkError(resp))
+	}
+	var response httpx.MessageResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode message response: %v", err)
+	}
+	return &response.MessageInfo, nil
+}
+
+func (c *Client) DeleteMessage(queue string, id int) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.DeleteMessageContext(ctx, queue, id)
+}
+
+// DeleteMessageContext removes the message with the given ID from the queue for good
+func (c *Client) DeleteMessageContext(ctx context.Context, queue string, id int) error {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "messages", id)
+	resp, err := c.delete(ctx, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete message %d from queue %s: %v", id, queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return fmt.Errorf("[%d] failed to delete message %d from queue %s: %v", resp.StatusCode, id, queue, c.checkError(resp))
+	}
+	return nil
+}
+
+func (c *Client) Unsent(queue string) ([]model.UnsentMessage, int64, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.UnsentContext(ctx, queue)
+}
+
+// UnsentContext lists the messages which failed to be published into the queue and wait to be retried,
+// oldest first. Also returns how many were dropped because there were too many of them.
+func (c *Client) UnsentContext(ctx context.Context, queue string) ([]model.UnsentMessage, int64, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "unsent")
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list unsent messages of queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, 0, fmt.Errorf("[%d] failed to list unsent messages of queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.UnsentResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode unsent response: %v", err)
 	}
-	return response.Data, nil
+	return response.Messages, response.Dropped, nil
+}
+
+func (c *Client) RetryUnsent(queue string) (retried, remaining int, err error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.RetryUnsentContext(ctx, queue)
+}
+
+// RetryUnsentContext publishes the unsent messages of the queue, oldest first, until one of them fails again.
+// Returns how many were published, and how many are still unsent.
+func (c *Client) RetryUnsentContext(ctx context.Context, queue string) (retried, remaining int, err error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "unsent")
+	resp, err := c.post(ctx, endpoint, nil, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to retry unsent messages of queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return 0, 0, fmt.Errorf("[%d] failed to retry unsent messages of queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.RetryUnsentResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode retry response: %v", err)
+	}
+	return response.Retried, response.Remaining, nil
+}
+
+func (c *Client) DiscardUnsent(queue string) (int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.DiscardUnsentContext(ctx, queue)
+}
+
+// DiscardUnsentContext throws away the unsent messages of the queue. Returns how many were discarded.
+func (c *Client) DiscardUnsentContext(ctx context.Context, queue string) (int, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue, "unsent")
+	resp, err := c.delete(ctx, endpoint, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to discard unsent messages of queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return 0, fmt.Errorf("[%d] failed to discard unsent messages of queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var response httpx.DiscardUnsentResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode discard response: %v", err)
+	}
+	return response.Discarded, nil
 }
 
 func (c *Client) GetQueues() (map[string]telemetry.QueueStats, error) {
@@ -277,6 +839,39 @@ func (c *Client) CreateQueueContext(ctx context.Context, queue string, opts conf
 	return nil
 }
 
+func (c *Client) UpdateQueue(queue string, changes map[string]any, force bool) (*config.QueueConfig, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.UpdateQueueContext(ctx, queue, changes, force)
+}
+
+// UpdateQueueContext changes the config of a live queue. The changes are keyed by the JSON names of the
+// config.QueueConfig fields, and every other field keeps its current value. Limits below what the queue
+// currently holds are only accepted if force is set. Returns the queue's config after the change.
+func (c *Client) UpdateQueueContext(ctx context.Context, queue string, changes map[string]any, force bool) (*config.QueueConfig, error) {
+	endpoint := httpx.UrlJoin(c.Url, "queues", queue)
+	if force {
+		endpoint += "?force"
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize queue changes: %v", err)
+	}
+	resp, err := c.patch(ctx, endpoint, bytes.NewBuffer(b), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update queue %s: %v", queue, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to update queue %s: %v", resp.StatusCode, queue, c.checkError(resp))
+	}
+	var cfg config.QueueConfig
+	if err = json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode queue config: %v", err)
+	}
+	return &cfg, nil
+}
+
 func (c *Client) DeleteQueue(queue string) error {
 	ctx, cancel := c.context()
 	defer cancel()
@@ -302,6 +897,172 @@ func (c *Client) CreateQueue(queue string, opts config.QueueConfig) error {
 	return c.CreateQueueContext(ctx, queue, opts)
 }
 
+func (c *Client) GetExchanges() (config.ExchangeMap, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.GetExchangesContext(ctx)
+}
+
+func (c *Client) GetExchangesContext(ctx context.Context) (config.ExchangeMap, error) {
+	endpoint := httpx.UrlJoin(c.Url, "exchanges")
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exchanges: %v", err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to get exchanges: %v", resp.StatusCode, c.checkError(resp))
+	}
+	var exchanges config.ExchangeMap
+	if err = json.NewDecoder(resp.Body).Decode(&exchanges); err != nil {
+		return nil, fmt.Errorf("failed to decode exchanges response: %v", err)
+	}
+	return exchanges, nil
+}
+
+func (c *Client) CreateExchange(exchange string, cfg config.ExchangeConfig) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.CreateExchangeContext(ctx, exchange, cfg)
+}
+
+// CreateExchangeContext creates an exchange. Every queue it is bound to must exist.
+func (c *Client) CreateExchangeContext(ctx context.Context, exchange string, cfg config.ExchangeConfig) error {
+	b, err := json.Marshal(httpx.ExchangesPostRequest{Name: exchange, ExchangeConfig: cfg})
+	if err != nil {
+		return fmt.Errorf("failed to serialize exchange info: %v", err)
+	}
+	endpoint := httpx.UrlJoin(c.Url, "exchanges")
+	resp, err := c.post(ctx, endpoint, bytes.NewBuffer(b), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create exchange %s: %v", exchange, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("[%d] failed to create exchange %s: %v", resp.StatusCode, exchange, c.checkError(resp))
+	}
+	return nil
+}
+
+func (c *Client) GetExchange(exchange string) (*config.ExchangeConfig, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.GetExchangeContext(ctx, exchange)
+}
+
+func (c *Client) GetExchangeContext(ctx context.Context, exchange string) (*config.ExchangeConfig, error) {
+	endpoint := httpx.UrlJoin(c.Url, "exchanges", exchange)
+	resp, err := c.get(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exchange %s: %v", exchange, err)
+	}
+	return c.exchangeConfig(resp, exchange)
+}
+
+func (c *Client) DeleteExchange(exchange string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.DeleteExchangeContext(ctx, exchange)
+}
+
+func (c *Client) DeleteExchangeContext(ctx context.Context, exchange string) error {
+	endpoint := httpx.UrlJoin(c.Url, "exchanges", exchange)
+	resp, err := c.delete(ctx, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete exchange %s: %v", exchange, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return fmt.Errorf("[%d] failed to delete exchange %s: %v", resp.StatusCode, exchange, c.checkError(resp))
+	}
+	return nil
+}
+
+func (c *Client) Bind(exchange, queue, pattern string) (*config.ExchangeConfig, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.BindContext(ctx, exchange, queue, pattern)
+}
+
+// BindContext routes the messages of the exchange whose routing key matches pattern into the queue.
+// Returns the exchange's bindings after the change.
+func (c *Client) BindContext(ctx context.Context, exchange, queue, pattern string) (*config.ExchangeConfig, error) {
+	b, err := json.Marshal(config.Binding{Queue: queue, Pattern: pattern})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize binding: %v", err)
+	}
+	endpoint := httpx.UrlJoin(c.Url, "exchanges", exchange, "bindings")
+	resp, err := c.post(ctx, endpoint, bytes.NewBuffer(b), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind queue %s to exchange %s: %v", queue, exchange, err)
+	}
+	return c.exchangeConfig(resp, exchange)
+}
+
+func (c *Client) Unbind(exchange, queue, pattern string) (*config.ExchangeConfig, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.UnbindContext(ctx, exchange, queue, pattern)
+}
+
+// UnbindContext undoes BindContext. Returns the exchange's bindings after the change.
+func (c *Client) UnbindContext(ctx context.Context, exchange, queue, pattern string) (*config.ExchangeConfig, error) {
+	params := url.Values{}
+	params.Set("queue", queue)
+	params.Set("pattern", pattern)
+	endpoint := httpx.UrlJoin(c.Url, "exchanges", exchange, "bindings") + "?" + params.Encode()
+	resp, err := c.delete(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unbind queue %s from exchange %s: %v", queue, exchange, err)
+	}
+	return c.exchangeConfig(resp, exchange)
+}
+
+func (c *Client) exchangeConfig(resp *http.Response, exchange string) (*config.ExchangeConfig, error) {
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to get exchange %s: %v", resp.StatusCode, exchange, c.checkError(resp))
+	}
+	var cfg config.ExchangeConfig
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode exchange config: %v", err)
+	}
+	return &cfg, nil
+}
+
+func (c *Client) PublishToExchange(exchange, routingKey string, request httpx.MessageRequest) (map[string]int, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.PublishToExchangeContext(ctx, exchange, routingKey, request)
+}
+
+// PublishToExchangeContext publishes a message into every queue the exchange routes its routing key to,
+// and returns the ID it got in each of them. A message which matches no binding gets no IDs.
+// If it could only be published into some of the queues, returns their IDs along with an error.
+func (c *Client) PublishToExchangeContext(ctx context.Context, exchange, routingKey string, request httpx.MessageRequest) (map[string]int, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(httpx.ExchangePublishRequest{RoutingKey: routingKey, MessageRequest: request}); err != nil {
+		return nil, fmt.Errorf("failed to encode message: %v", err)
+	}
+	endpoint := httpx.UrlJoin(c.Url, "exchanges", exchange, "publish")
+	resp, err := c.post(ctx, endpoint, &buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish to exchange %s: %v", exchange, err)
+	}
+	defer resp.Body.Close()
+	if !c.ok(resp) {
+		return nil, fmt.Errorf("[%d] failed to publish to exchange %s: %v", resp.StatusCode, exchange, c.checkError(resp))
+	}
+	var response httpx.ExchangePublishResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode publish response: %v", err)
+	}
+	if response.Error != "" {
+		return response.IDs, fmt.Errorf("failed to publish to every queue of exchange %s: %s", exchange, response.Error)
+	}
+	return response.IDs, nil
+}
+
 func (c *Client) GetRunningConfig() (*config.Configuration, error) {
 
 	ctx, cancel := c.context()
@@ -0,0 +1,172 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"yambol/config"
+	"yambol/pkg/transport/httpx"
+
+	"github.com/gorilla/mux"
+)
+
+func exchangeName(r *http.Request) string {
+	return mux.Vars(r)["exchange"]
+}
+
+func (s *Server) exchanges() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		target, err := resolveHTTPMethodTarget(r, map[string]HandlerFunc{
+			http.MethodGet:  s.getExchanges(),
+			http.MethodPost: s.addNewExchange(),
+		})
+		if err != nil {
+			return s.error(w, http.StatusMethodNotAllowed, err)
+		}
+		return target(w, r)
+	}
+}
+
+func (s *Server) getExchanges() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		return s.respond(w, httpx.ExchangesResponse{StatusCode: http.StatusOK, Exchanges: s.b.Exchanges()})
+	}
+}
+
+func (s *Server) addNewExchange() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		var body httpx.ExchangesPostRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+		}
+		if s.b.ExchangeExists(body.Name) {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to create exchange `%s` as it already exists", body.Name))
+		}
+		if !isValidPath(body.Name) {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("the exchange name `%s` is not valid", body.Name))
+		}
+
+		if err := s.b.AddExchange(body.Name, body.ExchangeConfig); err != nil {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to create exchange `%s`: %v", body.Name, err))
+		}
+		return s.respond(w, httpx.EmptyResponse{StatusCode: http.StatusCreated})
+	}
+}
+
+func (s *Server) exchange() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		target, err := resolveHTTPMethodTarget(r, map[string]HandlerFunc{
+			http.MethodGet:    s.getExchange(),
+			http.MethodDelete: s.deleteExchange(),
+		})
+		if err != nil {
+			return s.error(w, http.StatusMethodNotAllowed, err)
+		}
+		return target(w, r)
+	}
+}
+
+func (s *Server) getExchange() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		exName := exchangeName(r)
+
+		cfg, err := s.b.Exchange(exName)
+		if err != nil {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("exchange `%s` does not exist", exName))
+		}
+		return s.respond(w, httpx.ExchangeConfigResponse{StatusCode: http.StatusOK, Config: cfg})
+	}
+}
+
+func (s *Server) deleteExchange() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		exName := exchangeName(r)
+
+		if !s.b.ExchangeExists(exName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("exchange `%s` does not exist", exName))
+		}
+
+		if err := s.b.RemoveExchange(exName); err != nil {
+			return s.error(w, http.StatusInternalServerError, fmt.Errorf("failed to remove exchange `%s`: %v", exName, err))
+		}
+		return s.respond(w, httpx.EmptyResponse{StatusCode: http.StatusOK})
+	}
+}
+
+// bindings binds a queue to an exchange, from a binding in the body, or unbinds it, from the `queue` and `pattern`
+// query parameters. Either way, responds with the exchange's bindings.
+func (s *Server) bindings() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		exName := exchangeName(r)
+
+		if !s.b.ExchangeExists(exName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("exchange `%s` does not exist", exName))
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			var body config.Binding
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+			}
+			if err := s.b.Bind(exName, body.Queue, body.Pattern); err != nil {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to bind queue `%s`: %v", body.Queue, err))
+			}
+		case http.MethodDelete:
+			query := r.URL.Query()
+			if err := s.b.Unbind(exName, query.Get("queue"), query.Get("pattern")); err != nil {
+				return s.error(w, http.StatusNotFound, err)
+			}
+		default:
+			return s.error(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on (%s)", r.Method, r.URL.Path))
+		}
+
+		cfg, err := s.b.Exchange(exName)
+		if err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.ExchangeConfigResponse{StatusCode: http.StatusOK, Config: cfg})
+	}
+}
+
+// publishToExchange publishes a message to every queue the exchange routes its routing key to. Like publishing
+// to a queue, the message is either JSON, or a raw body which takes its routing key and options from the query.
+func (s *Server) publishToExchange() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		exName := exchangeName(r)
+
+		if !s.b.ExchangeExists(exName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("exchange `%s` does not exist", exName))
+		}
+
+		var body httpx.ExchangePublishRequest
+		if httpx.IsJSONRequest(r) {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+			}
+		} else {
+			message, err := rawMessageRequest(r)
+			if err != nil {
+				return s.error(w, http.StatusBadRequest, err)
+			}
+			body = httpx.ExchangePublishRequest{RoutingKey: r.URL.Query().Get("routing_key"), MessageRequest: message}
+		}
+		if body.DedupKey == "" {
+			body.DedupKey = r.Header.Get(httpx.HeaderIdempotencyKey)
+		}
+		if err := body.Options().Validate(); err != nil {
+			return s.error(w, http.StatusBadRequest, err)
+		}
+
+		ids, err := s.b.PublishToExchangeContext(r.Context(), exName, body.RoutingKey, body.Body(), body.Options())
+		if err != nil && len(ids) == 0 {
+			return s.error(w, errorStatus(err), fmt.Errorf("failed to publish message: %v", err))
+		}
+		resp := httpx.ExchangePublishResponse{StatusCode: http.StatusOK, IDs: ids}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		return s.respond(w, resp)
+	}
+}
@@ -1,15 +1,24 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 	"yambol/config"
 	"yambol/pkg/util"
 
+	"yambol/pkg/broker"
 	"yambol/pkg/queue"
 	"yambol/pkg/transport/httpx"
+	"yambol/pkg/transport/model"
+
+	"github.com/gorilla/mux"
 )
 
 func (s *Server) queues() HandlerFunc {
@@ -46,15 +55,19 @@ func (s *Server) addNewQueue() HandlerFunc {
 			return s.error(w, http.StatusBadRequest, fmt.Errorf("the queue name `%s` is not valid", qInfo.Name))
 		}
 
-		if err := s.b.AddQueue(qInfo.Name, config.QueueConfig{
-			MinLength:    qInfo.MinLength,
-			MaxLength:    qInfo.MaxLength,
-			MaxSizeBytes: qInfo.MaxSizeBytes,
-			TTL:          qInfo.TTL,
-		}); err != nil {
+		dlq := qInfo.DeadLetterQueue
+		if dlq != "" && !s.b.QueueExists(dlq) && !isValidPath(dlq) {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("the dead-letter queue name `%s` is not valid", dlq))
+		}
+		newDLQ := dlq != "" && !s.b.QueueExists(dlq)
+
+		if err := s.b.AddQueue(qInfo.Name, qInfo.QueueConfig); err != nil {
 			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to create queue `%s`: %v", qInfo.Name, err))
 		}
 
+		if newDLQ {
+			s.addQueueRoute(dlq, httpx.DebugPrintHook(s.logger))
+		}
 		s.addQueueRoute(qInfo.Name, httpx.DebugPrintHook(s.logger))
 		return s.respond(w, httpx.EmptyResponse{StatusCode: http.StatusCreated})
 	}
@@ -65,6 +78,7 @@ func (s *Server) queue() HandlerFunc {
 		target, err := resolveHTTPMethodTarget(r, map[string]HandlerFunc{
 			http.MethodGet:    s.consumeFromQueue(),
 			http.MethodPost:   s.sendMessageToQueue(),
+			http.MethodPatch:  s.updateQueue(),
 			http.MethodDelete: s.deleteQueue(),
 		})
 		if err != nil {
@@ -76,24 +90,498 @@ func (s *Server) queue() HandlerFunc {
 
 func (s *Server) consumeFromQueue() HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
-		qName := r.URL.Path[len("/queues/"):]
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		wait, err := parseWait(r)
+		if err != nil {
+			return s.error(w, http.StatusBadRequest, err)
+		}
+
+		if r.URL.Query().Has("max") {
+			return s.consumeBatch(w, r, qName, wait)
+		}
+		if r.URL.Query().Has("lease") {
+			return s.consumeLease(w, r, qName, wait)
+		}
+
+		var message queue.Message
+		if wait > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			defer cancel()
+			message, err = s.b.ConsumeMessageContext(ctx, qName)
+		} else {
+			message, err = s.b.ConsumeMessage(qName)
+		}
+		if err != nil {
+			if consumedNothing(err) {
+				return s.respondNothing(w, r)
+			}
+			return s.error(w, errorStatus(err), err)
+		}
+
+		return s.respondMessage(w, r, message, httpx.QueueGetResponse{
+			StatusCode: 200,
+			Priority:   message.Priority,
+			DeadLetter: httpx.NewDeadLetter(message.DeadLetter),
+		})
+	}
+}
+
+func (s *Server) consumeLease(w http.ResponseWriter, r *http.Request, qName string, wait time.Duration) httpx.Response {
+	var visibility *time.Duration
+	if raw := r.URL.Query().Get("visibility_timeout"); raw != "" {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seconds <= 0 {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("invalid visibility_timeout `%s`", raw))
+		}
+		v := util.Seconds(seconds)
+		visibility = &v
+	}
+
+	var (
+		lease queue.Lease
+		err   error
+	)
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		lease, err = s.b.ConsumeLeaseContext(ctx, qName, visibility)
+	} else {
+		lease, err = s.b.ConsumeLease(qName, visibility)
+	}
+	if err != nil {
+		if consumedNothing(err) {
+			return s.respondNothing(w, r)
+		}
+		return s.error(w, errorStatus(err), err)
+	}
+
+	return s.respondMessage(w, r, lease.Message, httpx.QueueGetResponse{
+		StatusCode: 200,
+		Receipt:    lease.Receipt,
+		Deadline:   &lease.Deadline,
+		Priority:   lease.Priority,
+		Deliveries: lease.Deliveries,
+		DeadLetter: httpx.NewDeadLetter(lease.DeadLetter),
+	})
+}
+
+// consumeBatch consumes up to `max` messages at once. It only waits, if asked to, for the first one.
+func (s *Server) consumeBatch(w http.ResponseWriter, r *http.Request, qName string, wait time.Duration) httpx.Response {
+	query := r.URL.Query()
+	if query.Has("lease") || query.Has("raw") {
+		return s.error(w, http.StatusBadRequest, fmt.Errorf("max cannot be combined with lease or raw"))
+	}
+	raw := query.Get("max")
+	max, err := strconv.Atoi(raw)
+	if err != nil || max <= 0 || max > maxBatchSize {
+		return s.error(w, http.StatusBadRequest, fmt.Errorf("invalid max `%s`, must be between 1 and %d", raw, maxBatchSize))
+	}
+
+	var messages []queue.Message
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		messages, err = s.b.ConsumeBatchContext(ctx, qName, max)
+	} else {
+		messages, err = s.b.ConsumeBatch(qName, max)
+	}
+	if err != nil && !consumedNothing(err) {
+		return s.error(w, errorStatus(err), err)
+	}
+
+	resp := httpx.BatchGetResponse{
+		StatusCode: http.StatusOK,
+		Messages:   make([]httpx.ConsumedMessage, len(messages)),
+	}
+	for i, message := range messages {
+		resp.Messages[i] = httpx.NewConsumedMessage(message)
+	}
+	return s.respond(w, resp)
+}
+
+// wantsRaw tells whether a consumer asked for the message as a raw body, with its metadata in headers
+func wantsRaw(r *http.Request) bool {
+	return r.URL.Query().Has("raw")
+}
+
+// respondMessage writes a consumed message either as JSON, or as a raw body if the consumer asked for one
+func (s *Server) respondMessage(w http.ResponseWriter, r *http.Request, message queue.Message, resp httpx.QueueGetResponse) httpx.Response {
+	if !wantsRaw(r) {
+		resp.Data, resp.Encoding = model.EncodeData(message.Value)
+		resp.ContentType = message.ContentType
+		resp.Headers = message.Headers
+		resp.Group = message.Group
+		return s.respond(w, resp)
+	}
+
+	contentType := message.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	headers := map[string]string{
+		httpx.HeaderPriority: strconv.Itoa(resp.Priority),
+	}
+	if resp.Receipt != "" {
+		headers[httpx.HeaderReceipt] = resp.Receipt
+		headers[httpx.HeaderDeadline] = resp.Deadline.Format(time.RFC3339Nano)
+		headers[httpx.HeaderDeliveries] = strconv.FormatInt(resp.Deliveries, 10)
+	}
+	if message.Group != "" {
+		headers[httpx.HeaderGroup] = message.Group
+	}
+	if len(message.Headers) > 0 {
+		headers[httpx.HeaderMessageHeaders] = encodeMessageHeaders(message.Headers)
+	}
+	if resp.DeadLetter != nil {
+		headers[httpx.HeaderDeadLetterReason] = resp.DeadLetter.Reason
+		headers[httpx.HeaderDeadLetterQueue] = resp.DeadLetter.Queue
+	}
+	return s.respondRaw(w, httpx.RawResponse{
+		StatusCode:  resp.StatusCode,
+		ContentType: contentType,
+		Headers:     headers,
+		Body:        message.Value,
+	})
+}
+
+// respondNothing answers a consume which found no message. Raw consumers get no content, since an empty body
+// could be a message of its own.
+func (s *Server) respondNothing(w http.ResponseWriter, r *http.Request) httpx.Response {
+	if wantsRaw(r) {
+		return s.respondRaw(w, httpx.RawResponse{StatusCode: http.StatusNoContent})
+	}
+	return s.respond(w, httpx.QueueGetResponse{StatusCode: 200})
+}
+
+// parseWait reads the optional `wait` query parameter, which is how long a consume may block for,
+// in seconds or as a duration string such as "500ms"
+func parseWait(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := util.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait `%s`", raw)
+	}
+	if wait <= maxConsumeWait {
+		return wait, nil
+	}
+	return 0, fmt.Errorf("wait `%s` is longer than the maximum of %s", raw, maxConsumeWait)
+}
+
+// errorStatus picks the status code for a failed consume or publish. A queue with no room for a publish,
+// whatever its overflow policy, is reported as out of storage so clients can tell it apart from a failure.
+func errorStatus(err error) int {
+	if errors.Is(err, queue.ErrConsumePaused) || errors.Is(err, queue.ErrPublishPaused) ||
+		errors.Is(err, broker.ErrQueueUnavailable) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, queue.ErrQueueFull) || errors.Is(err, queue.ErrQueueTooLarge) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// consumedNothing tells apart a consume which found no message, possibly after waiting for one, from a failed one
+func consumedNothing(err error) bool {
+	return errors.Is(err, queue.ErrQueueEmpty) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled)
+}
+
+func (s *Server) browseQueue() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
 
 		if !s.b.QueueExists(qName) {
 			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
 		}
 
-		message, err := s.b.Consume(qName)
-		if err != nil && !errors.Is(err, queue.ErrQueueEmpty) {
+		limit := defaultBrowseLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 || n > maxBrowseLimit {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("invalid limit `%s`, must be between 1 and %d", raw, maxBrowseLimit))
+			}
+			limit = n
+		}
+
+		var (
+			infos []queue.MessageInfo
+			err   error
+		)
+		if raw := r.URL.Query().Get("after"); raw != "" {
+			after, convErr := strconv.Atoi(raw)
+			if convErr != nil {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("invalid cursor `%s`", raw))
+			}
+			infos, err = s.b.BrowseAfter(qName, after, limit)
+		} else {
+			infos, err = s.b.Browse(qName, limit)
+		}
+		if err != nil {
+			if errors.Is(err, queue.ErrCursorNotFound) {
+				return s.error(w, http.StatusNotFound, err)
+			}
 			return s.error(w, http.StatusInternalServerError, err)
 		}
 
-		return s.respond(w, httpx.QueueGetResponse{StatusCode: 200, Data: message})
+		resp := httpx.BrowseResponse{
+			StatusCode: http.StatusOK,
+			Messages:   make([]model.MessageInfo, len(infos)),
+		}
+		for i, info := range infos {
+			resp.Messages[i] = httpx.NewMessageInfo(info)
+		}
+		if len(infos) == limit {
+			resp.Next = &infos[len(infos)-1].ID
+		}
+		return s.respond(w, resp)
+	}
+}
+
+func (s *Server) message() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		target, err := resolveHTTPMethodTarget(r, map[string]HandlerFunc{
+			http.MethodGet:    s.getMessage(),
+			http.MethodDelete: s.deleteMessage(),
+		})
+		if err != nil {
+			return s.error(w, http.StatusMethodNotAllowed, err)
+		}
+		return target(w, r)
+	}
+}
+
+func (s *Server) getMessage() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("invalid message id `%s`", mux.Vars(r)["id"]))
+		}
+
+		info, err := s.b.GetMessage(qName, id)
+		if err != nil {
+			if errors.Is(err, queue.ErrMessageNotFound) {
+				return s.error(w, http.StatusNotFound, err)
+			}
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.MessageResponse{StatusCode: http.StatusOK, MessageInfo: httpx.NewMessageInfo(info)})
+	}
+}
+
+func (s *Server) deleteMessage() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("invalid message id `%s`", mux.Vars(r)["id"]))
+		}
+
+		if err = s.b.DeleteMessage(qName, id); err != nil {
+			if errors.Is(err, queue.ErrMessageNotFound) {
+				return s.error(w, http.StatusNotFound, err)
+			}
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.EmptyResponse{StatusCode: http.StatusOK})
+	}
+}
+
+func (s *Server) ackMessage() HandlerFunc {
+	return s.settleLease(s.b.Ack)
+}
+
+func (s *Server) nackMessage() HandlerFunc {
+	return s.settleLease(s.b.Nack)
+}
+
+// settleLease handles both acks and nacks, which only differ in what the broker does with the receipt
+func (s *Server) settleLease(settle func(queueName, receipt string) error) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		var body httpx.LeaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+		}
+
+		if err := settle(qName, body.Receipt); err != nil {
+			if errors.Is(err, queue.ErrLeaseNotFound) {
+				return s.error(w, http.StatusNotFound, err)
+			}
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+
+		return s.respond(w, httpx.EmptyResponse{StatusCode: http.StatusOK})
+	}
+}
+
+func (s *Server) pauseQueue() HandlerFunc {
+	return s.setPaused(s.b.PauseQueue)
+}
+
+func (s *Server) resumeQueue() HandlerFunc {
+	return s.setPaused(s.b.ResumeQueue)
+}
+
+// setPaused handles both pausing and resuming, which only differ in what the broker does with the direction
+func (s *Server) setPaused(set func(queueName, direction string) error) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		var body httpx.PauseRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+		}
+		if body.Direction == "" {
+			body.Direction = config.PauseBoth
+		}
+
+		if err := set(qName, body.Direction); err != nil {
+			if errors.Is(err, queue.ErrInvalidPauseDirection) {
+				return s.error(w, http.StatusBadRequest, err)
+			}
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		paused, err := s.b.QueuePaused(qName)
+		if err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.PauseResponse{StatusCode: http.StatusOK, Paused: paused})
+	}
+}
+
+func (s *Server) purgeQueue() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		var body httpx.PurgeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+		}
+		if body.OlderThan < 0 {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("older_than may not be negative"))
+		}
+
+		purged, err := s.b.PurgeQueue(qName, util.Seconds(body.OlderThan))
+		if err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.PurgeResponse{StatusCode: http.StatusOK, Purged: purged})
+	}
+}
+
+// unsent lists, retries or discards the messages which failed to be published into a queue
+func (s *Server) unsent() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		target, err := resolveHTTPMethodTarget(r, map[string]HandlerFunc{
+			http.MethodGet:    s.listUnsent(),
+			http.MethodPost:   s.retryUnsent(),
+			http.MethodDelete: s.discardUnsent(),
+		})
+		if err != nil {
+			return s.error(w, http.StatusMethodNotAllowed, err)
+		}
+		return target(w, r)
+	}
+}
+
+func (s *Server) listUnsent() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		unsent, err := s.b.Unsent(qName)
+		if err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		resp := httpx.UnsentResponse{
+			StatusCode: http.StatusOK,
+			Messages:   make([]model.UnsentMessage, len(unsent.Messages)),
+			Dropped:    unsent.Dropped,
+		}
+		for i, msg := range unsent.Messages {
+			resp.Messages[i] = httpx.NewUnsentMessage(msg)
+		}
+		return s.respond(w, resp)
+	}
+}
+
+func (s *Server) retryUnsent() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		retried, err := s.b.RetryUnsent(qName)
+		if err != nil {
+			return s.error(w, errorStatus(err), err)
+		}
+		unsent, err := s.b.Unsent(qName)
+		if err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.RetryUnsentResponse{
+			StatusCode: http.StatusOK,
+			Retried:    retried,
+			Remaining:  len(unsent.Messages),
+		})
+	}
+}
+
+func (s *Server) discardUnsent() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		discarded, err := s.b.DiscardUnsent(qName)
+		if err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.DiscardUnsentResponse{StatusCode: http.StatusOK, Discarded: discarded})
 	}
 }
 
 func (s *Server) sendMessageToQueue() HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
-		qName := r.URL.Path[len("/queues/"):]
+		qName := queueName(r)
 
 		if !s.b.QueueExists(qName) {
 			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
@@ -104,26 +592,171 @@ func (s *Server) sendMessageToQueue() HandlerFunc {
 			err  error
 		)
 
-		if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if httpx.IsJSONRequest(r) {
+			if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+			}
+		} else if body, err = rawMessageRequest(r); err != nil {
+			return s.error(w, http.StatusBadRequest, err)
+		}
+		if body.DedupKey == "" {
+			body.DedupKey = r.Header.Get(httpx.HeaderIdempotencyKey)
+		}
+		if err = body.Options().Validate(); err != nil {
+			return s.error(w, http.StatusBadRequest, err)
+		}
+		ids, err := s.b.PublishBytesContext(r.Context(), body.Body(), body.Options(), qName)
+		if err != nil {
+			return s.error(w, errorStatus(err), fmt.Errorf("failed to publish message: %v", err))
+		}
+
+		return s.respond(w, httpx.PublishResponse{StatusCode: http.StatusOK, ID: ids[qName]})
+	}
+}
+
+func (s *Server) sendBatchToQueue() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		if !s.b.QueueExists(qName) {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+
+		var body httpx.BatchMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
 		}
-		ttl := util.Seconds(body.TTL)
-		if ttl == 0 {
-			err = s.b.Publish(body.Message, qName)
-		} else {
-			err = s.b.PublishWithTTL(body.Message, &ttl, qName)
+		if len(body.Messages) == 0 || len(body.Messages) > maxBatchSize {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("a batch must have between 1 and %d messages", maxBatchSize))
 		}
+		entries := body.Entries()
+		for i, entry := range entries {
+			if err := entry.Options.Validate(); err != nil {
+				return s.error(w, http.StatusBadRequest, fmt.Errorf("message %d: %v", i, err))
+			}
+		}
+		ids, err := s.b.PublishBatchContext(r.Context(), qName, entries)
 		if err != nil {
-			return s.error(w, http.StatusInternalServerError, fmt.Errorf("failed to publish message: %v", err))
+			return s.error(w, errorStatus(err), fmt.Errorf("failed to publish batch: %v", err))
 		}
 
-		return s.respond(w, httpx.EmptyResponse{StatusCode: http.StatusOK})
+		return s.respond(w, httpx.PublishBatchResponse{StatusCode: http.StatusOK, IDs: ids})
+	}
+}
+
+// rawMessageRequest reads a message published as a raw body, which takes its options from the query instead
+func rawMessageRequest(r *http.Request) (httpx.MessageRequest, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return httpx.MessageRequest{}, fmt.Errorf("failed to read request body: %v", err)
+	}
+	body := httpx.MessageRequest{
+		Data:        data,
+		ContentType: r.Header.Get("Content-Type"),
+	}
+	if raw := r.Header.Get(httpx.HeaderMessageHeaders); raw != "" {
+		if body.Headers, err = decodeMessageHeaders(raw); err != nil {
+			return httpx.MessageRequest{}, fmt.Errorf("invalid %s header: %v", httpx.HeaderMessageHeaders, err)
+		}
+	}
+
+	query := r.URL.Query()
+	for param, target := range map[string]*int64{"ttl_ms": &body.TTLMilliseconds, "delay": &body.Delay} {
+		if raw := query.Get(param); raw != "" {
+			if *target, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return httpx.MessageRequest{}, fmt.Errorf("invalid %s `%s`", param, raw)
+			}
+		}
+	}
+	if raw := query.Get("ttl"); raw != "" {
+		ttl, err := util.ParseDuration(raw)
+		if err != nil {
+			return httpx.MessageRequest{}, fmt.Errorf("invalid ttl: %v", err)
+		}
+		body.TTL = util.Duration(ttl)
+	}
+	if raw := query.Get("priority"); raw != "" {
+		if body.Priority, err = strconv.Atoi(raw); err != nil {
+			return httpx.MessageRequest{}, fmt.Errorf("invalid priority `%s`", raw)
+		}
+	}
+	body.Group = query.Get("group")
+	if raw := query.Get("deliver_at"); raw != "" {
+		deliverAt, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return httpx.MessageRequest{}, fmt.Errorf("invalid deliver_at `%s`: %v", raw, err)
+		}
+		body.DeliverAt = &deliverAt
+	}
+	return body, nil
+}
+
+// encodeMessageHeaders fits message headers, whose keys need not be valid HTTP header names, into one HTTP header
+func encodeMessageHeaders(headers map[string]string) string {
+	values := url.Values{}
+	for k, v := range headers {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func decodeMessageHeaders(raw string) (map[string]string, error) {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(values))
+	for k := range values {
+		headers[k] = values.Get(k)
+	}
+	return headers, nil
+}
+
+// updateQueue changes the config of a live queue. Only the fields in the body are changed, and limits below
+// what the queue currently holds are only accepted with the `force` query parameter.
+func (s *Server) updateQueue() HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
+		qName := queueName(r)
+
+		cfg, err := s.b.QueueConfig(qName)
+		if err != nil {
+			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
+		}
+		// a body without a pause leaves the queue's pause as it is, even if it changes meanwhile
+		cfg.Paused = nil
+		if err = json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %v", err))
+		}
+		dlq := cfg.DeadLetterQueue
+		if dlq != "" && !s.b.QueueExists(dlq) && !isValidPath(dlq) {
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("the dead-letter queue name `%s` is not valid", dlq))
+		}
+		newDLQ := dlq != "" && !s.b.QueueExists(dlq)
+
+		update := s.b.UpdateQueue
+		if r.URL.Query().Has("force") {
+			update = s.b.ForceUpdateQueue
+		}
+		if err = update(qName, cfg); err != nil {
+			if errors.Is(err, queue.ErrLimitBelowContents) {
+				return s.error(w, http.StatusConflict, fmt.Errorf("failed to update queue `%s`: %v", qName, err))
+			}
+			return s.error(w, http.StatusBadRequest, fmt.Errorf("failed to update queue `%s`: %v", qName, err))
+		}
+		if newDLQ {
+			s.addQueueRoute(dlq, httpx.DebugPrintHook(s.logger))
+		}
+
+		if cfg, err = s.b.QueueConfig(qName); err != nil {
+			return s.error(w, http.StatusInternalServerError, err)
+		}
+		return s.respond(w, httpx.QueueConfigResponse{StatusCode: http.StatusOK, Config: cfg})
 	}
 }
 
 func (s *Server) deleteQueue() HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) httpx.Response {
-		qName := r.URL.Path[len("/queues/"):]
+		qName := queueName(r)
 
 		if !s.b.QueueExists(qName) {
 			return s.error(w, http.StatusNotFound, fmt.Errorf("queue `%s` does not exist", qName))
@@ -142,5 +775,59 @@ func (s *Server) addQueueRoute(qName string, hooks ...httpx.Middleware) {
 		fmt.Sprintf("/queues/%s", qName),
 		s.queue(),
 		hooks...,
+	).Methods(http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/batch", qName),
+		s.sendBatchToQueue(),
+		hooks...,
+	).Methods(http.MethodPost)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/messages", qName),
+		s.browseQueue(),
+		hooks...,
+	).Methods(http.MethodGet)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/messages/{id:[0-9]+}", qName),
+		s.message(),
+		hooks...,
+	).Methods(http.MethodGet, http.MethodDelete)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/purge", qName),
+		s.purgeQueue(),
+		hooks...,
+	).Methods(http.MethodPost)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/pause", qName),
+		s.pauseQueue(),
+		hooks...,
+	).Methods(http.MethodPost)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/resume", qName),
+		s.resumeQueue(),
+		hooks...,
+	).Methods(http.MethodPost)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/unsent", qName),
+		s.unsent(),
+		hooks...,
 	).Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/ack", qName),
+		s.ackMessage(),
+		hooks...,
+	).Methods(http.MethodPost)
+
+	s.route(
+		fmt.Sprintf("/queues/%s/nack", qName),
+		s.nackMessage(),
+		hooks...,
+	).Methods(http.MethodPost)
 }
@@ -18,6 +18,17 @@ var forbiddenQueueNames = []string{
 	"broadcast",
 }
 
+const (
+	// maxConsumeWait caps how long a consume request may be held open while waiting for a message
+	maxConsumeWait = time.Second * 20
+
+	defaultBrowseLimit = 10
+	maxBrowseLimit     = 1000
+
+	// maxBatchSize caps how many messages a single request may consume or publish
+	maxBatchSize = 1000
+)
+
 type HandlerFunc = func(w http.ResponseWriter, r *http.Request) httpx.Response
 
 type Server struct {
@@ -96,6 +107,30 @@ func (s *Server) routes() {
 		httpx.DebugPrintHook(s.logger),
 	).Methods(http.MethodPut)
 
+	s.route(
+		"/exchanges",
+		s.exchanges(),
+		httpx.DebugPrintHook(s.logger),
+	).Methods(http.MethodGet, http.MethodPost)
+
+	s.route(
+		"/exchanges/{exchange}",
+		s.exchange(),
+		httpx.DebugPrintHook(s.logger),
+	).Methods(http.MethodGet, http.MethodDelete)
+
+	s.route(
+		"/exchanges/{exchange}/bindings",
+		s.bindings(),
+		httpx.DebugPrintHook(s.logger),
+	).Methods(http.MethodPost, http.MethodDelete)
+
+	s.route(
+		"/exchanges/{exchange}/publish",
+		s.publishToExchange(),
+		httpx.DebugPrintHook(s.logger),
+	).Methods(http.MethodPost)
+
 	for _, qName := range s.b.Queues() {
 		s.addQueueRoute(qName, httpx.DebugPrintHook(s.logger))
 	}
@@ -145,6 +180,21 @@ func (s *Server) respond(w http.ResponseWriter, response httpx.Response) httpx.R
 	return response
 }
 
+func (s *Server) respondRaw(w http.ResponseWriter, response httpx.RawResponse) httpx.Response {
+	for k, v := range s.defaultHeaders {
+		w.Header().Set(k, v)
+	}
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+	if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+	w.WriteHeader(response.GetStatusCode())
+	w.Write(response.Body)
+	return response
+}
+
 func resolveHTTPMethodTarget(r *http.Request, targets map[string]HandlerFunc) (HandlerFunc, error) {
 	allowedMethods := make([]string, 0, len(targets))
 	for k := range targets {
@@ -158,6 +208,15 @@ func resolveHTTPMethodTarget(r *http.Request, targets map[string]HandlerFunc) (H
 
 }
 
+// queueName extracts the queue name from a `/queues/{name}[/...]` request path
+func queueName(r *http.Request) string {
+	name := strings.TrimPrefix(r.URL.Path, "/queues/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
 func normalizeQueueName(name string) string {
 	return strings.ToLower(
 		strings.TrimPrefix(
@@ -1,6 +1,8 @@
 package httpx
 
 import (
+	"mime"
+	"net/http"
 	"strconv"
 	"strings"
 )
@@ -19,3 +21,13 @@ func UrlJoin(url string, components ...any) string {
 	}
 	return url
 }
+
+// IsJSONRequest tells whether the request body is JSON, which is assumed when no content type is given
+func IsJSONRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
@@ -1,8 +1,107 @@
 package model
 
-import "time"
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
 
 type BasicInfo struct {
 	Uptime  time.Duration `json:"uptime"`
 	Version string        `json:"version"`
 }
+
+// EncodingBase64 marks message data which was not valid UTF-8 and had to be base64 encoded to fit in JSON
+const EncodingBase64 = "base64"
+
+// EncodeData turns a message payload into a JSON string, base64 encoding it only if it is not valid UTF-8
+func EncodeData(value []byte) (data string, encoding string) {
+	if utf8.Valid(value) {
+		return string(value), ""
+	}
+	return base64.StdEncoding.EncodeToString(value), EncodingBase64
+}
+
+// DecodeData reverses EncodeData
+func DecodeData(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(data), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(data)
+	default:
+		return nil, fmt.Errorf("unknown data encoding `%s`", encoding)
+	}
+}
+
+// DeadLetter tells why a message was dead-lettered, and where it came from
+type DeadLetter struct {
+	Reason     string    `json:"reason"`
+	Queue      string    `json:"queue"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// Message is a consumed message along with its metadata
+type Message struct {
+	Data        []byte            `json:"data"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	DeadLetter  *DeadLetter       `json:"dead_letter,omitempty"`
+}
+
+// Lease is a message consumed under a visibility timeout, which must be acked or nacked with its receipt
+type Lease struct {
+	Receipt     string            `json:"receipt"`
+	Data        []byte            `json:"data"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Deadline    time.Time         `json:"deadline"`
+	Deliveries  int64             `json:"deliveries"`
+	DeadLetter  *DeadLetter       `json:"dead_letter,omitempty"`
+}
+
+// MessageInfo describes a message waiting in a queue, as listed by browsing it
+type MessageInfo struct {
+	ID          int               `json:"id"`
+	State       string            `json:"state"`
+	Data        string            `json:"data"`
+	Encoding    string            `json:"encoding,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	TTL         int64             `json:"ttl_ms"`
+	EnqueuedAt  time.Time         `json:"enqueued_at"`
+	Age         int64             `json:"age_ms"`
+	Deliveries  int64             `json:"deliveries,omitempty"`
+	DeadLetter  *DeadLetter       `json:"dead_letter,omitempty"`
+}
+
+// Bytes returns the original payload of the message
+func (m MessageInfo) Bytes() ([]byte, error) {
+	return DecodeData(m.Data, m.Encoding)
+}
+
+// UnsentMessage is a message which could not be published into a queue, and waits in the broker to be retried.
+// Messages published as typed values in-process have no data.
+type UnsentMessage struct {
+	ID          int               `json:"id"`
+	Data        string            `json:"data"`
+	Encoding    string            `json:"encoding,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Error       string            `json:"error"`
+	FailedAt    time.Time         `json:"failed_at"`
+	Attempts    int               `json:"attempts"`
+}
+
+// Bytes returns the original payload of the message
+func (m UnsentMessage) Bytes() ([]byte, error) {
+	return DecodeData(m.Data, m.Encoding)
+}
@@ -1,6 +1,10 @@
 package util
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -8,6 +12,10 @@ func Seconds(n int64) time.Duration {
 	return time.Duration(n) * time.Second
 }
 
+func Milliseconds(n int64) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
 func LittleLongerThan(d time.Duration) time.Duration {
 	return d + time.Microsecond*50
 }
@@ -15,3 +23,52 @@ func LittleLongerThan(d time.Duration) time.Duration {
 func DurationAlmostEqual(expected, actual time.Duration, maxOffset time.Duration) bool {
 	return (expected - actual).Abs() <= maxOffset
 }
+
+// ParseDuration reads a whole number of seconds, such as "5", or a duration string, such as "250ms"
+func ParseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Seconds(n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration `%s`, expected seconds or a duration such as 250ms", s)
+	}
+	return d, nil
+}
+
+// Duration is a time.Duration which reads from JSON as either a number of seconds or a duration string.
+// It is written as a number of seconds when it is a whole number of them, so it stays compatible with
+// fields which used to hold seconds, and as a duration string otherwise.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if time.Duration(d)%time.Second == 0 {
+		return []byte(strconv.FormatInt(int64(time.Duration(d)/time.Second), 10)), nil
+	}
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	raw := string(b)
+	if raw == "null" {
+		return nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		parsed, err := ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	seconds, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s, expected seconds or a duration string", raw)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
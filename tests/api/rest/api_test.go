@@ -2,9 +2,14 @@ package rest
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 	"yambol/config"
+	"yambol/pkg/queue"
+	"yambol/pkg/transport/httpx"
 	"yambol/pkg/transport/httpx/rest"
 	"yambol/pkg/util"
 
@@ -21,6 +26,25 @@ func TestAPI(t *testing.T) {
 	testBasicOps(t, ctx, client, testStartTime)
 	testQueueManagement(t, ctx, client)
 	testQueueLogic(t, ctx, client)
+	testLeases(t, ctx, client)
+	testDeadLetters(t, ctx, client)
+	testPriority(t, ctx, client)
+	testScheduled(t, ctx, client)
+	testLongPolling(t, ctx, client)
+	testBrowse(t, ctx, client)
+	testBinary(t, ctx, client)
+	testHeaders(t, ctx, client)
+	testMessageIDs(t, ctx, client)
+	testDedup(t, ctx, client)
+	testBatch(t, ctx, client)
+	testOverflow(t, ctx, client)
+	testGroups(t, ctx, client)
+	testPause(t, ctx, client)
+	testPurge(t, ctx, client)
+	testUpdateQueue(t, ctx, client)
+	testTTLPrecision(t, ctx, client)
+	testUnsent(t, ctx, client)
+	testExchanges(t, ctx, client)
 
 }
 
@@ -48,7 +72,7 @@ func testQueueManagement(t *testing.T, ctx context.Context, client *rest.Client)
 		MinLength:    12,
 		MaxLength:    1024,
 		MaxSizeBytes: 42069,
-		TTL:          defaultTimeoutSeconds,
+		TTL:          util.Duration(util.Seconds(defaultTimeoutSeconds)),
 	}
 
 	testCreateQueue(t, ctx, client, qOpts)
@@ -62,26 +86,26 @@ func testQueueLogic(t *testing.T, ctx context.Context, client *rest.Client) {
 		MinLength:    12,
 		MaxLength:    1024,
 		MaxSizeBytes: 42069,
-		TTL:          defaultTimeoutSeconds,
+		TTL:          util.Duration(util.Seconds(defaultTimeoutSeconds)),
 	})
-	err := client.PublishContext(ctx, "nonexistent-queue", "?")
+	_, err := client.PublishContext(ctx, "nonexistent-queue", "?")
 	assert.Error(t, err, "published to nonexistent queue")
 
 	_, err = client.ConsumeContext(ctx, "nonexistent-queue")
 	assert.Error(t, err, "consumed from nonexistent queue")
 
-	val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+	val, err := client.ConsumeWaitContext(ctx, defaultTestQueueName, 0)
 	assert.NoError(t, err, "error consuming from empty queue")
 	assert.Equal(t, "", val, "got non-empty value from empty queue")
 
-	err = client.PublishContext(ctx, defaultTestQueueName, testValue)
+	_, err = client.PublishContext(ctx, defaultTestQueueName, testValue)
 	assert.NoError(t, err, "failed to publish to queue")
 
 	val, err = client.ConsumeContext(ctx, defaultTestQueueName)
 	assert.NoError(t, err, "failed to consume from queue")
 	assert.Equal(t, testValue, val, "failed to consume correct value from queue")
 
-	err = client.PublishContextTimeout(ctx, defaultTestQueueName, testValue, time.Second)
+	_, err = client.PublishContextTimeout(ctx, defaultTestQueueName, testValue, time.Second)
 	assert.NoError(t, err, "failed to publish to queue")
 	time.Sleep(time.Second)
 	v, err := client.Consume(defaultTestQueueName)
@@ -165,3 +189,702 @@ func testRemoveQueue(t *testing.T, ctx context.Context, client *rest.Client) {
 	runCfg := config.GetRunningConfig()
 	assert.NotContains(t, runCfg.Broker.Queues, defaultTestQueueName, "the queue is still in the running config")
 }
+
+func testLeases(t *testing.T, ctx context.Context, client *rest.Client) {
+	testValue := "leased_value"
+
+	lease, err := client.ConsumeLeaseContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "error leasing from empty queue")
+	assert.Nil(t, lease, "got a lease from an empty queue")
+
+	_, err = client.PublishContext(ctx, defaultTestQueueName, testValue)
+	assert.NoError(t, err, "failed to publish to queue")
+
+	lease, err = client.ConsumeLeaseContext(ctx, defaultTestQueueName, time.Second)
+	assert.NoError(t, err, "failed to lease from queue")
+	assert.NotNil(t, lease, "got no lease from a non-empty queue")
+	assert.Equal(t, testValue, string(lease.Data), "leased the wrong value")
+
+	val, err := client.ConsumeWaitContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "error consuming from queue with only leased values")
+	assert.Equal(t, "", val, "consumed a leased value")
+
+	err = client.NackContext(ctx, defaultTestQueueName, lease.Receipt)
+	assert.NoError(t, err, "failed to nack")
+	err = client.AckContext(ctx, defaultTestQueueName, lease.Receipt)
+	assert.Error(t, err, "acked a nacked lease")
+
+	lease, err = client.ConsumeLeaseContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to lease nacked value")
+	assert.Equal(t, testValue, string(lease.Data), "nacked value was not redelivered")
+
+	err = client.AckContext(ctx, defaultTestQueueName, lease.Receipt)
+	assert.NoError(t, err, "failed to ack")
+
+	val, err = client.ConsumeWaitContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "error consuming from empty queue")
+	assert.Equal(t, "", val, "acked value was redelivered")
+}
+
+func testDeadLetters(t *testing.T, ctx context.Context, client *rest.Client) {
+	source, dlq := "_rest_api_test_source", "_rest_api_test_dlq"
+	err := client.CreateQueueContext(ctx, source, config.QueueConfig{
+		MaxDeliveries:   1,
+		DeadLetterQueue: dlq,
+	})
+	assert.NoError(t, err, "failed to create queue with dead-letter queue")
+
+	queues, err := client.GetQueuesContext(ctx)
+	assert.NoError(t, err, "failed to get queues")
+	assert.Contains(t, queues, dlq, "the dead-letter queue was not created")
+
+	_, err = client.PublishContext(ctx, source, "poison")
+	assert.NoError(t, err, "failed to publish to queue")
+	lease, err := client.ConsumeLeaseContext(ctx, source, 0)
+	assert.NoError(t, err, "failed to lease from queue")
+	err = client.NackContext(ctx, source, lease.Receipt)
+	assert.NoError(t, err, "failed to nack")
+
+	lease, err = client.ConsumeLeaseContext(ctx, dlq, 0)
+	assert.NoError(t, err, "failed to lease from dead-letter queue")
+	assert.NotNil(t, lease, "message was not dead-lettered")
+	assert.Equal(t, "poison", string(lease.Data))
+	assert.NotNil(t, lease.DeadLetter, "dead-letter details missing")
+	assert.Equal(t, source, lease.DeadLetter.Queue)
+	assert.Equal(t, queue.DeadLetterMaxDeliveries, lease.DeadLetter.Reason)
+	assert.NoError(t, client.AckContext(ctx, dlq, lease.Receipt), "failed to ack dead letter")
+
+	assert.Error(t, client.DeleteQueueContext(ctx, dlq), "deleted a dead-letter queue in use")
+	assert.NoError(t, client.DeleteQueueContext(ctx, source), "failed to delete queue")
+	assert.NoError(t, client.DeleteQueueContext(ctx, dlq), "failed to delete dead-letter queue")
+}
+
+func testPriority(t *testing.T, ctx context.Context, client *rest.Client) {
+	qName := "_rest_api_test_priority"
+	err := client.CreateQueueContext(ctx, qName, config.QueueConfig{Type: config.QueueTypePriority})
+	assert.NoError(t, err, "failed to create priority queue")
+
+	err = client.CreateQueueContext(ctx, "_rest_api_test_bad_type", config.QueueConfig{Type: "lifo"})
+	assert.Error(t, err, "created a queue with an unknown type")
+
+	for _, req := range []httpx.MessageRequest{
+		{Message: "low", Priority: -1},
+		{Message: "normal"},
+		{Message: "high", Priority: 3},
+	} {
+		_, err := client.PublishMessageContext(ctx, qName, req)
+		assert.NoError(t, err, "failed to publish", req.Message)
+	}
+	for _, expected := range []string{"high", "normal", "low"} {
+		val, err := client.ConsumeContext(ctx, qName)
+		assert.NoError(t, err, "failed to consume")
+		assert.Equal(t, expected, val, "mismatched consume order")
+	}
+	assert.NoError(t, client.DeleteQueueContext(ctx, qName), "failed to delete queue")
+}
+
+func testScheduled(t *testing.T, ctx context.Context, client *rest.Client) {
+	deliverAt := time.Now().Add(time.Millisecond * 200)
+	_, err := client.PublishMessageContext(ctx, defaultTestQueueName, httpx.MessageRequest{
+		Message:   "scheduled",
+		DeliverAt: &deliverAt,
+	})
+	assert.NoError(t, err, "failed to publish scheduled message")
+
+	val, err := client.ConsumeWaitContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "error consuming from queue with only scheduled messages")
+	assert.Equal(t, "", val, "consumed a message before it was due")
+
+	stats, err := client.StatsContext(ctx)
+	assert.NoError(t, err, "failed to get stats")
+	assert.Equal(t, int64(1), stats[defaultTestQueueName].Scheduled, "mismatched scheduled count")
+
+	time.Sleep(time.Until(deliverAt))
+	val, err = client.ConsumeContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume due message")
+	assert.Equal(t, "scheduled", val, "due message was not delivered")
+}
+
+func testLongPolling(t *testing.T, ctx context.Context, client *rest.Client) {
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		_, _ = client.Publish(defaultTestQueueName, "long_polled")
+	}()
+	start := time.Now()
+	val, err := client.ConsumeWaitContext(ctx, defaultTestQueueName, time.Second*2)
+	assert.NoError(t, err, "failed to long poll")
+	assert.Equal(t, "long_polled", val, "long poll did not return the published value")
+	assert.Less(t, time.Since(start), time.Second, "long poll should return as soon as a message arrives")
+
+	start = time.Now()
+	val, err = client.ConsumeWaitContext(ctx, defaultTestQueueName, time.Second)
+	assert.NoError(t, err, "error long polling an empty queue")
+	assert.Equal(t, "", val, "got a value from an empty queue")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "long poll returned before the wait expired")
+
+	start = time.Now()
+	val, err = client.ConsumeWaitContext(ctx, defaultTestQueueName, time.Millisecond*300)
+	assert.NoError(t, err, "error long polling for less than a second")
+	assert.Equal(t, "", val, "got a value from an empty queue")
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*300, "a sub-second wait should not be rounded down")
+
+	// a consume with a deadline long-polls for as long as the deadline allows
+	pollCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		_, _ = client.Publish(defaultTestQueueName, "polled_until_deadline")
+	}()
+	val, err = client.ConsumeContext(pollCtx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to long poll until the deadline")
+	assert.Equal(t, "polled_until_deadline", val, "a consume with a deadline should wait for a message")
+}
+
+func testBrowse(t *testing.T, ctx context.Context, client *rest.Client) {
+	for _, v := range []string{"browse_1", "browse_2", "browse_3"} {
+		_, err := client.PublishContext(ctx, defaultTestQueueName, v)
+		assert.NoError(t, err, "failed to publish", v)
+	}
+
+	messages, next, err := client.BrowseContext(ctx, defaultTestQueueName, 2)
+	assert.NoError(t, err, "failed to browse")
+	assert.Len(t, messages, 2, "mismatched page size")
+	assert.Equal(t, "browse_1", messages[0].Data)
+	assert.Equal(t, "browse_2", messages[1].Data)
+	if assert.NotNil(t, next, "expected a cursor for the next page") {
+		messages, next, err = client.BrowseAfterContext(ctx, defaultTestQueueName, *next, 2)
+		assert.NoError(t, err, "failed to browse next page")
+		assert.Len(t, messages, 1, "mismatched last page size")
+		assert.Equal(t, "browse_3", messages[0].Data)
+		assert.Nil(t, next, "last page should not have a cursor")
+	}
+
+	_, _, err = client.BrowseAfterContext(ctx, defaultTestQueueName, -1, 2)
+	assert.Error(t, err, "expected an unknown cursor to fail")
+	_, _, err = client.BrowseContext(ctx, "does_not_exist", 2)
+	assert.Error(t, err, "expected to fail to browse non existent queue")
+
+	for _, v := range []string{"browse_1", "browse_2", "browse_3"} {
+		val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+		assert.NoError(t, err, "failed to consume browsed message")
+		assert.Equal(t, v, val, "browsing should not consume")
+	}
+}
+
+func testBinary(t *testing.T, ctx context.Context, client *rest.Client) {
+	payload := []byte{0x00, 0xff, 0xfe, 'y', 0x80}
+
+	_, err := client.PublishBytesContext(ctx, defaultTestQueueName, payload, "application/x-test")
+	assert.NoError(t, err, "failed to publish raw body")
+	data, contentType, err := client.ConsumeBytesContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume raw body")
+	assert.Equal(t, payload, data, "raw payload was not returned as is")
+	assert.Equal(t, "application/x-test", contentType, "mismatched content type")
+
+	data, _, err = client.ConsumeBytesContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "error consuming raw body from an empty queue")
+	assert.Nil(t, data, "got a raw body from an empty queue")
+
+	_, err = client.PublishMessageContext(ctx, defaultTestQueueName, httpx.MessageRequest{
+		Data:        payload,
+		ContentType: "application/x-test",
+	})
+	assert.NoError(t, err, "failed to publish binary data in a JSON envelope")
+	lease, err := client.ConsumeLeaseContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to lease binary data")
+	if assert.NotNil(t, lease, "expected a lease") {
+		assert.Equal(t, payload, lease.Data, "binary data did not survive the JSON envelope")
+		assert.Equal(t, "application/x-test", lease.ContentType, "mismatched content type")
+		assert.NoError(t, client.AckContext(ctx, defaultTestQueueName, lease.Receipt), "failed to ack")
+	}
+
+	_, err = client.PublishBytesContext(ctx, defaultTestQueueName, []byte("plain text"), "text/plain")
+	assert.NoError(t, err, "failed to publish text body")
+	val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume text body as JSON")
+	assert.Equal(t, "plain text", val)
+}
+
+func testHeaders(t *testing.T, ctx context.Context, client *rest.Client) {
+	headers := map[string]string{"correlation-id": "abc-123", "event type": "created"}
+	_, err := client.PublishMessageContext(ctx, defaultTestQueueName, httpx.MessageRequest{
+		Message: "with_headers",
+		Headers: headers,
+	})
+	assert.NoError(t, err, "failed to publish with headers")
+	msg, err := client.ConsumeMessageContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume with headers")
+	if assert.NotNil(t, msg, "expected a message") {
+		assert.Equal(t, "with_headers", string(msg.Data))
+		assert.Equal(t, headers, msg.Headers, "mismatched headers")
+	}
+
+	_, err = client.PublishMessageContext(ctx, defaultTestQueueName, httpx.MessageRequest{
+		Message: "leased_with_headers",
+		Headers: headers,
+	})
+	assert.NoError(t, err, "failed to publish with headers")
+	lease, err := client.ConsumeLeaseContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to lease with headers")
+	if assert.NotNil(t, lease, "expected a lease") {
+		assert.Equal(t, headers, lease.Headers, "mismatched leased headers")
+		assert.NoError(t, client.AckContext(ctx, defaultTestQueueName, lease.Receipt), "failed to ack")
+	}
+
+	_, err = client.PublishMessageContext(ctx, defaultTestQueueName, httpx.MessageRequest{
+		Message: "invalid_headers",
+		Headers: map[string]string{"": "no key"},
+	})
+	assert.Error(t, err, "expected invalid headers to be rejected")
+
+	msg, err = client.ConsumeMessageContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "error consuming from an empty queue")
+	assert.Nil(t, msg, "got a message from an empty queue")
+}
+
+func testMessageIDs(t *testing.T, ctx context.Context, client *rest.Client) {
+	first, err := client.PublishContext(ctx, defaultTestQueueName, "by_id_1")
+	assert.NoError(t, err, "failed to publish")
+	second, err := client.PublishContext(ctx, defaultTestQueueName, "by_id_2")
+	assert.NoError(t, err, "failed to publish")
+	assert.Greater(t, second, first, "IDs should increase in publish order")
+
+	info, err := client.GetMessageContext(ctx, defaultTestQueueName, first)
+	assert.NoError(t, err, "failed to get message by ID")
+	if assert.NotNil(t, info, "expected a message") {
+		assert.Equal(t, first, info.ID)
+		assert.Equal(t, "by_id_1", info.Data)
+		assert.Equal(t, queue.MessageVisible, info.State)
+	}
+
+	assert.NoError(t, client.DeleteMessageContext(ctx, defaultTestQueueName, first), "failed to delete message by ID")
+	assert.Error(t, client.DeleteMessageContext(ctx, defaultTestQueueName, first), "deleted a message twice")
+	_, err = client.GetMessageContext(ctx, defaultTestQueueName, first)
+	assert.Error(t, err, "got a deleted message")
+
+	val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "by_id_2", val, "deleted message was consumed")
+}
+
+func testDedup(t *testing.T, ctx context.Context, client *rest.Client) {
+	request := httpx.MessageRequest{Message: "dedup", DedupKey: "dedup-key"}
+	first, err := client.PublishMessageContext(ctx, defaultTestQueueName, request)
+	assert.NoError(t, err, "failed to publish with a dedup key")
+	again, err := client.PublishMessageContext(ctx, defaultTestQueueName, request)
+	assert.NoError(t, err, "failed to publish a duplicate")
+	assert.Equal(t, first, again, "duplicate should return the original ID")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Url+"/queues/"+defaultTestQueueName, strings.NewReader("dedup"))
+	assert.NoError(t, err, "failed to build request")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(httpx.HeaderIdempotencyKey, "dedup-key")
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err, "failed to publish with an Idempotency-Key header") {
+		var response httpx.PublishResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response), "failed to decode publish response")
+		assert.Equal(t, first, response.ID, "Idempotency-Key header should deduplicate too")
+		resp.Body.Close()
+	}
+
+	stats, err := client.StatsContext(ctx)
+	assert.NoError(t, err, "failed to get stats")
+	assert.Equal(t, int64(2), stats[defaultTestQueueName].DedupHits, "mismatched dedup hits")
+
+	val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "dedup", val)
+	val, err = client.ConsumeWaitContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "", val, "duplicates were enqueued")
+}
+
+func testBatch(t *testing.T, ctx context.Context, client *rest.Client) {
+	ids, err := client.PublishBatchContext(ctx, defaultTestQueueName, []httpx.MessageRequest{
+		{Message: "first"},
+		{Data: []byte{0xff, 0x00}, ContentType: "application/octet-stream"},
+		{Message: "third", Headers: map[string]string{"k": "v"}},
+	})
+	assert.NoError(t, err, "failed to publish batch")
+	if assert.Len(t, ids, 3, "expected an ID for each message") {
+		assert.Less(t, ids[0], ids[1], "IDs should follow the batch's order")
+		assert.Less(t, ids[1], ids[2], "IDs should follow the batch's order")
+	}
+
+	_, err = client.PublishBatchContext(ctx, defaultTestQueueName, nil)
+	assert.Error(t, err, "published an empty batch")
+	_, err = client.ConsumeBatchContext(ctx, defaultTestQueueName, 0)
+	assert.Error(t, err, "consumed a batch with an invalid max")
+
+	messages, err := client.ConsumeBatchContext(ctx, defaultTestQueueName, 2)
+	assert.NoError(t, err, "failed to consume batch")
+	if assert.Len(t, messages, 2, "mismatched batch size") {
+		assert.Equal(t, "first", string(messages[0].Data))
+		assert.Equal(t, []byte{0xff, 0x00}, messages[1].Data, "binary data should survive a batch")
+	}
+	messages, err = client.ConsumeBatchContext(ctx, defaultTestQueueName, 2)
+	assert.NoError(t, err, "failed to consume batch")
+	if assert.Len(t, messages, 1, "mismatched batch size") {
+		assert.Equal(t, map[string]string{"k": "v"}, messages[0].Headers)
+	}
+	messages, err = client.ConsumeBatchContext(ctx, defaultTestQueueName, 2)
+	assert.NoError(t, err, "failed to consume batch")
+	assert.Empty(t, messages, "consumed from an empty queue")
+}
+
+func testOverflow(t *testing.T, ctx context.Context, client *rest.Client) {
+	name := "_rest_api_test_overflow"
+	err := client.CreateQueueContext(ctx, name, config.QueueConfig{
+		MaxLength: 1,
+		Overflow:  "sideways",
+	})
+	assert.Error(t, err, "created a queue with an unknown overflow policy")
+	err = client.CreateQueueContext(ctx, name, config.QueueConfig{
+		MaxLength: 1,
+		Overflow:  config.OverflowDropOldest,
+	})
+	assert.NoError(t, err, "failed to create queue with an overflow policy")
+	assert.Equal(t, config.OverflowDropOldest, config.GetRunningConfig().Broker.Queues[name].Overflow, "overflow policy missing from the running config")
+
+	_, err = client.PublishContext(ctx, name, "old")
+	assert.NoError(t, err, "failed to publish to queue")
+	_, err = client.PublishContext(ctx, name, "new")
+	assert.NoError(t, err, "failed to publish to a full queue with the drop-oldest policy")
+	val, err := client.ConsumeContext(ctx, name)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "new", val, "the oldest message should have been dropped")
+
+	stats, err := client.StatsContext(ctx)
+	assert.NoError(t, err, "failed to get stats")
+	assert.Equal(t, int64(1), stats[name].OverflowDroppedOldest, "mismatched dropped oldest count")
+	assert.NoError(t, client.DeleteQueueContext(ctx, name), "failed to delete queue")
+
+	err = client.CreateQueueContext(ctx, name, config.QueueConfig{
+		MaxLength:    1,
+		MaxSizeBytes: 8,
+		Overflow:     config.OverflowReject,
+	})
+	assert.NoError(t, err, "failed to create queue with the reject policy")
+	publishStatus := func(value string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Url+"/queues/"+name, strings.NewReader(value))
+		assert.NoError(t, err, "failed to build request")
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err, "failed to publish") {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusInsufficientStorage, publishStatus("over the byte budget"), "a publish over the byte budget should be out of storage")
+	assert.Equal(t, http.StatusOK, publishStatus("kept"), "failed to publish to queue")
+	assert.Equal(t, http.StatusInsufficientStorage, publishStatus("rejected"), "a publish to a full queue should be out of storage")
+	assert.NoError(t, client.DeleteQueueContext(ctx, name), "failed to delete queue")
+}
+
+func testGroups(t *testing.T, ctx context.Context, client *rest.Client) {
+	for _, value := range []string{"first", "second"} {
+		_, err := client.PublishMessageContext(ctx, defaultTestQueueName, httpx.MessageRequest{Message: value, Group: "customer-1"})
+		assert.NoError(t, err, "failed to publish to a group")
+	}
+
+	lease, err := client.ConsumeLeaseContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to lease")
+	if !assert.NotNil(t, lease, "nothing was leased") {
+		return
+	}
+	assert.Equal(t, "first", string(lease.Data))
+	assert.Equal(t, "customer-1", lease.Group)
+	other, err := client.ConsumeLeaseContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to lease")
+	assert.Nil(t, other, "leased a second message of a group in flight")
+
+	stats, err := client.StatsContext(ctx)
+	assert.NoError(t, err, "failed to get stats")
+	assert.Equal(t, int64(1), stats[defaultTestQueueName].ActiveGroups, "mismatched active groups")
+
+	assert.NoError(t, client.AckContext(ctx, defaultTestQueueName, lease.Receipt), "failed to ack")
+	message, err := client.ConsumeMessageContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume")
+	if assert.NotNil(t, message, "the group's next message was not released") {
+		assert.Equal(t, "second", string(message.Data))
+		assert.Equal(t, "customer-1", message.Group)
+	}
+}
+
+func testPause(t *testing.T, ctx context.Context, client *rest.Client) {
+	_, err := client.PublishContext(ctx, defaultTestQueueName, "held")
+	assert.NoError(t, err, "failed to publish")
+
+	paused, err := client.PauseQueueContext(ctx, defaultTestQueueName, config.PauseConsume)
+	assert.NoError(t, err, "failed to pause consuming")
+	assert.Equal(t, config.PauseConsume, paused)
+	assert.Equal(t, config.PauseConsume, config.GetRunningConfig().Broker.Queues[defaultTestQueueName].PausedDirection(), "paused state not persisted")
+	_, err = client.ConsumeContext(ctx, defaultTestQueueName)
+	assert.ErrorContains(t, err, queue.ErrConsumePaused.Error(), "consumed from a paused queue")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.Url+"/queues/"+defaultTestQueueName, nil)
+	assert.NoError(t, err, "failed to build request")
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err, "failed to consume") {
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "a paused consume should be a conflict")
+		resp.Body.Close()
+	}
+
+	paused, err = client.PauseQueueContext(ctx, defaultTestQueueName, "")
+	assert.NoError(t, err, "failed to pause both directions")
+	assert.Equal(t, config.PauseBoth, paused)
+	_, err = client.PublishContext(ctx, defaultTestQueueName, "rejected")
+	assert.ErrorContains(t, err, queue.ErrPublishPaused.Error(), "published to a paused queue")
+	_, err = client.PauseQueueContext(ctx, defaultTestQueueName, "sideways")
+	assert.ErrorContains(t, err, queue.ErrInvalidPauseDirection.Error(), "paused an unknown direction")
+
+	paused, err = client.ResumeQueueContext(ctx, defaultTestQueueName, "")
+	assert.NoError(t, err, "failed to resume")
+	assert.Empty(t, paused)
+	val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+	assert.NoError(t, err, "failed to consume after resuming")
+	assert.Equal(t, "held", val)
+}
+
+func testPurge(t *testing.T, ctx context.Context, client *rest.Client) {
+	for _, value := range []string{"a", "b", "c"} {
+		_, err := client.PublishContext(ctx, defaultTestQueueName, value)
+		assert.NoError(t, err, "failed to publish")
+	}
+
+	purged, err := client.PurgeQueueOlderThan(defaultTestQueueName, time.Hour)
+	assert.NoError(t, err, "failed to purge old messages")
+	assert.Zero(t, purged, "purged messages which are not old enough")
+	purged, err = client.PurgeQueueContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to purge")
+	assert.Equal(t, 3, purged, "mismatched number of purged messages")
+
+	stats, err := client.StatsContext(ctx)
+	assert.NoError(t, err, "failed to get stats")
+	assert.Equal(t, int64(3), stats[defaultTestQueueName].Purged, "mismatched purged count")
+	assert.Contains(t, config.GetRunningConfig().Broker.Queues, defaultTestQueueName, "purging should keep the queue")
+
+	val, err := client.ConsumeWaitContext(ctx, defaultTestQueueName, 0)
+	assert.NoError(t, err, "failed to consume")
+	assert.Empty(t, val, "messages left after purging")
+}
+
+func testUpdateQueue(t *testing.T, ctx context.Context, client *rest.Client) {
+	before := config.GetRunningConfig().Broker.Queues[defaultTestQueueName]
+	for _, value := range []string{"a", "b"} {
+		_, err := client.PublishContext(ctx, defaultTestQueueName, value)
+		assert.NoError(t, err, "failed to publish")
+	}
+
+	_, err := client.UpdateQueueContext(ctx, defaultTestQueueName, map[string]any{"max_length": 1}, false)
+	assert.ErrorContains(t, err, queue.ErrLimitBelowContents.Error(), "shrunk the queue below its length")
+	_, err = client.UpdateQueueContext(ctx, defaultTestQueueName, map[string]any{"type": config.QueueTypePriority}, false)
+	assert.Error(t, err, "changed the queue type")
+
+	cfg, err := client.UpdateQueueContext(ctx, defaultTestQueueName, map[string]any{"max_length": 1, "ttl": 30}, true)
+	assert.NoError(t, err, "failed to force an update")
+	if assert.NotNil(t, cfg) {
+		assert.Equal(t, int64(1), cfg.MaxLength)
+		assert.Equal(t, util.Duration(30*time.Second), cfg.TTL)
+		assert.Equal(t, before.MaxSizeBytes, cfg.MaxSizeBytes, "fields missing from the patch should be kept")
+	}
+	assert.Equal(t, *cfg, config.GetRunningConfig().Broker.Queues[defaultTestQueueName], "running config not updated")
+	for _, expected := range []string{"a", "b"} {
+		val, err := client.ConsumeContext(ctx, defaultTestQueueName)
+		assert.NoError(t, err, "failed to consume")
+		assert.Equal(t, expected, val, "messages should be kept by an update")
+	}
+
+	_, err = client.PauseQueueContext(ctx, defaultTestQueueName, config.PausePublish)
+	assert.NoError(t, err, "failed to pause queue")
+	cfg, err = client.UpdateQueueContext(ctx, defaultTestQueueName, map[string]any{"ttl": 20}, false)
+	assert.NoError(t, err, "failed to update a paused queue")
+	if assert.NotNil(t, cfg) {
+		assert.Equal(t, config.PausePublish, cfg.PausedDirection(), "a patch without a pause should keep the queue paused")
+	}
+	_, err = client.ResumeQueueContext(ctx, defaultTestQueueName, config.PauseBoth)
+	assert.NoError(t, err, "failed to resume queue")
+
+	changes := map[string]any{"max_length": before.MaxLength, "ttl": before.TTL}
+	_, err = client.UpdateQueueContext(ctx, defaultTestQueueName, changes, false)
+	assert.NoError(t, err, "failed to restore the queue config")
+}
+
+func testTTLPrecision(t *testing.T, ctx context.Context, client *rest.Client) {
+	name := "_rest_api_test_ttl"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Url+"/queues", strings.NewReader(`{"name": "`+name+`", "ttl_ms": 1500}`))
+	assert.NoError(t, err, "failed to build request")
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err, "failed to create queue with a ttl in milliseconds") {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	assert.Equal(t, util.Duration(time.Millisecond*1500), config.GetRunningConfig().Broker.Queues[name].TTL, "ttl truncated in the running config")
+
+	_, err = client.PublishMessageContext(ctx, name, httpx.MessageRequest{Message: "queue default"})
+	assert.NoError(t, err, "failed to publish")
+	_, err = client.PublishMessageContext(ctx, name, httpx.MessageRequest{Message: "duration", TTL: util.Duration(time.Millisecond * 250)})
+	assert.NoError(t, err, "failed to publish with a duration ttl")
+	_, err = client.PublishMessageContext(ctx, name, httpx.MessageRequest{Message: "milliseconds", TTLMilliseconds: 100})
+	assert.NoError(t, err, "failed to publish with a ttl in milliseconds")
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, client.Url+"/queues/"+name+"?ttl=2s", strings.NewReader("raw"))
+	assert.NoError(t, err, "failed to build request")
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err = http.DefaultClient.Do(req)
+	if assert.NoError(t, err, "failed to publish raw with a duration ttl") {
+		_ = resp.Body.Close()
+	}
+
+	messages, _, err := client.BrowseContext(ctx, name, 10)
+	assert.NoError(t, err, "failed to browse")
+	ttls := make([]int64, len(messages))
+	for i := range messages {
+		ttls[i] = messages[i].TTL
+	}
+	assert.Equal(t, []int64{1500, 250, 100, 2000}, ttls, "mismatched message ttls")
+
+	_, err = client.PublishMessageContext(ctx, name, httpx.MessageRequest{Message: "bad", TTL: util.Duration(-time.Second)})
+	assert.NoError(t, err, "a negative message ttl is ignored like before")
+	err = client.CreateQueueContext(ctx, name+"_negative", config.QueueConfig{TTL: util.Duration(-time.Millisecond)})
+	assert.Error(t, err, "created a queue with a negative ttl")
+	assert.NoError(t, client.DeleteQueueContext(ctx, name), "failed to delete queue")
+}
+
+func testUnsent(t *testing.T, ctx context.Context, client *rest.Client) {
+	name := "_rest_api_test_unsent"
+	assert.NoError(t, client.CreateQueueContext(ctx, name, config.QueueConfig{MaxLength: 1}), "failed to create queue")
+	for _, value := range []string{"a", "b", "c"} {
+		_, _ = client.PublishContext(ctx, name, value)
+	}
+
+	messages, dropped, err := client.UnsentContext(ctx, name)
+	assert.NoError(t, err, "failed to list unsent messages")
+	assert.Zero(t, dropped)
+	if assert.Len(t, messages, 2, "failed publishes were not spooled") {
+		data, err := messages[0].Bytes()
+		assert.NoError(t, err)
+		assert.Equal(t, "b", string(data))
+		assert.Contains(t, messages[0].Error, queue.ErrQueueFull.Error())
+		assert.Equal(t, 1, messages[0].Attempts)
+	}
+	retried, remaining, err := client.RetryUnsentContext(ctx, name)
+	assert.NoError(t, err, "failed to retry unsent messages")
+	assert.Zero(t, retried, "retried into a full queue")
+	assert.Equal(t, 2, remaining)
+
+	// consuming makes room for the oldest unsent message
+	val, err := client.ConsumeContext(ctx, name)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "a", val)
+	messages, _, err = client.UnsentContext(ctx, name)
+	assert.NoError(t, err, "failed to list unsent messages")
+	assert.Len(t, messages, 1, "unsent message was not retried")
+
+	discarded, err := client.DiscardUnsentContext(ctx, name)
+	assert.NoError(t, err, "failed to discard unsent messages")
+	assert.Equal(t, 1, discarded)
+	val, err = client.ConsumeContext(ctx, name)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "b", val)
+	val, err = client.ConsumeWaitContext(ctx, name, 0)
+	assert.NoError(t, err, "failed to consume")
+	assert.Empty(t, val, "discarded message was published")
+
+	_, _, err = client.UnsentContext(ctx, "nonexistent")
+	assert.Error(t, err, "listed unsent messages of a non existent queue")
+	assert.NoError(t, client.DeleteQueueContext(ctx, name), "failed to delete queue")
+}
+
+func testExchanges(t *testing.T, ctx context.Context, client *rest.Client) {
+	exName := "_rest_api_test_exchange"
+	created, shipped, logs := "_rest_api_test_ex_created", "_rest_api_test_ex_shipped", "_rest_api_test_ex_logs"
+	for _, name := range []string{created, shipped, logs} {
+		assert.NoError(t, client.CreateQueueContext(ctx, name, config.QueueConfig{}), "failed to create queue")
+	}
+
+	cfg := config.ExchangeConfig{Bindings: []config.Binding{{Queue: created, Pattern: "orders.*.created"}}}
+	assert.NoError(t, client.CreateExchangeContext(ctx, exName, cfg), "failed to create exchange")
+	assert.Error(t, client.CreateExchangeContext(ctx, exName, cfg), "created an exchange twice")
+	assert.Error(t, client.CreateExchangeContext(ctx, "_rest_api_test_bad_exchange", config.ExchangeConfig{
+		Bindings: []config.Binding{{Queue: created, Pattern: "orders.a*"}},
+	}), "created an exchange with an invalid pattern")
+	assert.Error(t, client.CreateExchangeContext(ctx, "_rest_api_test_bad_exchange", config.ExchangeConfig{
+		Bindings: []config.Binding{{Queue: "nonexistent", Pattern: "#"}},
+	}), "created an exchange bound to a non existent queue")
+
+	_, err := client.BindContext(ctx, exName, shipped, "orders.*.shipped")
+	assert.NoError(t, err, "failed to bind queue")
+	got, err := client.BindContext(ctx, exName, logs, "orders.#")
+	assert.NoError(t, err, "failed to bind queue")
+	if assert.NotNil(t, got) {
+		assert.Len(t, got.Bindings, 3)
+	}
+	_, err = client.BindContext(ctx, exName, "nonexistent", "#")
+	assert.Error(t, err, "bound a non existent queue")
+
+	exchanges, err := client.GetExchangesContext(ctx)
+	assert.NoError(t, err, "failed to get exchanges")
+	assert.Contains(t, exchanges, exName)
+	running, err := client.GetRunningConfigContext(ctx)
+	assert.NoError(t, err, "failed to get running config")
+	if assert.NotNil(t, running) {
+		assert.Len(t, running.Broker.Exchanges[exName].Bindings, 3, "bindings were not saved to the running config")
+	}
+
+	ids, err := client.PublishToExchangeContext(ctx, exName, "orders.eu.created", httpx.MessageRequest{Message: "new order"})
+	assert.NoError(t, err, "failed to publish to exchange")
+	assert.Len(t, ids, 2)
+	assert.Contains(t, ids, created)
+	assert.Contains(t, ids, logs)
+	ids, err = client.PublishToExchangeContext(ctx, exName, "invoices.eu.created", httpx.MessageRequest{Message: "dropped"})
+	assert.NoError(t, err, "failed to publish an unrouted message")
+	assert.Empty(t, ids)
+
+	// a raw body takes its routing key from the query
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Url+"/exchanges/"+exName+"/publish?routing_key=orders.us.shipped", strings.NewReader("shipped order"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	val, err := client.ConsumeContext(ctx, created)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "new order", val)
+	val, err = client.ConsumeContext(ctx, shipped)
+	assert.NoError(t, err, "failed to consume")
+	assert.Equal(t, "shipped order", val)
+	for _, want := range []string{"new order", "shipped order"} {
+		val, err = client.ConsumeContext(ctx, logs)
+		assert.NoError(t, err, "failed to consume")
+		assert.Equal(t, want, val)
+	}
+
+	got, err = client.UnbindContext(ctx, exName, logs, "orders.#")
+	assert.NoError(t, err, "failed to unbind queue")
+	if assert.NotNil(t, got) {
+		assert.Len(t, got.Bindings, 2)
+	}
+	_, err = client.UnbindContext(ctx, exName, logs, "orders.#")
+	assert.Error(t, err, "unbound a queue which is not bound")
+
+	// removing a queue drops its bindings
+	assert.NoError(t, client.DeleteQueueContext(ctx, shipped), "failed to delete queue")
+	got, err = client.GetExchangeContext(ctx, exName)
+	assert.NoError(t, err, "failed to get exchange")
+	if assert.NotNil(t, got) {
+		assert.Equal(t, []config.Binding{{Queue: created, Pattern: "orders.*.created"}}, got.Bindings)
+	}
+
+	assert.NoError(t, client.DeleteExchangeContext(ctx, exName), "failed to delete exchange")
+	assert.Error(t, client.DeleteExchangeContext(ctx, exName), "deleted a non existent exchange")
+	_, err = client.GetExchangeContext(ctx, exName)
+	assert.Error(t, err, "got a deleted exchange")
+	_, err = client.PublishToExchangeContext(ctx, exName, "orders.eu.created", httpx.MessageRequest{Message: "lost"})
+	assert.Error(t, err, "published to a deleted exchange")
+	for _, name := range []string{created, logs} {
+		assert.NoError(t, client.DeleteQueueContext(ctx, name), "failed to delete queue")
+	}
+}
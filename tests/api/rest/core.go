@@ -67,7 +67,7 @@ func testInit(t *testing.T) (*rest.Server, *rest.Client, context.Context, contex
 func run(t *testing.T, s *rest.Server) {
 	go func() {
 		if err := s.ListenAndServeInsecure(restApiTestServerPort); err != nil {
-			t.Fatalf(">>>>>>REST API server FAILED: %v", err)
+			t.Errorf(">>>>>>REST API server FAILED: %v", err)
 		}
 	}()
 	time.Sleep(time.Millisecond * 10)